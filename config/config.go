@@ -0,0 +1,305 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rancher/log-aggregator/fifo"
+)
+
+const DefaultPath = "/etc/rancher/log-aggregator/config.json"
+
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration should be a string, got %s", string(b))
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// Size is a byte count, written either as a plain number or with a Ki, Mi, Gi or Ti suffix.
+type Size int64
+
+var sizeSuffixes = []struct {
+	suffix string
+	factor int64
+}{
+	{"Ki", 1 << 10},
+	{"Mi", 1 << 20},
+	{"Gi", 1 << 30},
+	{"Ti", 1 << 40},
+}
+
+func ParseSize(s string) (Size, error) {
+	s = strings.TrimSpace(s)
+	factor := int64(1)
+	for _, v := range sizeSuffixes {
+		if strings.HasSuffix(s, v.suffix) {
+			s = strings.TrimSuffix(s, v.suffix)
+			factor = v.factor
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return Size(n * factor), nil
+}
+
+func (s *Size) UnmarshalJSON(b []byte) error {
+	var n int64
+	if err := json.Unmarshal(b, &n); err == nil {
+		*s = Size(n)
+		return nil
+	}
+
+	var str string
+	if err := json.Unmarshal(b, &str); err != nil {
+		return fmt.Errorf("size should be a number or a string, got %s", string(b))
+	}
+	v, err := ParseSize(str)
+	if err != nil {
+		return err
+	}
+	*s = v
+	return nil
+}
+
+type LogConfig struct {
+	File       string `json:"file,omitempty"`
+	Level      string `json:"level,omitempty"`
+	Format     string `json:"format,omitempty"`
+	MaxSize    Size   `json:"maxSize,omitempty"`
+	MaxBackups int    `json:"maxBackups,omitempty"`
+}
+
+type ForwardConfig struct {
+	Address            string `json:"address,omitempty"`
+	TLS                bool   `json:"tls,omitempty"`
+	CAFile             string `json:"caFile,omitempty"`
+	ServerName         string `json:"serverName,omitempty"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
+	SharedKey          string `json:"sharedKey,omitempty"`
+	// Hostname is sent in the handshake, the node name when empty
+	Hostname    string   `json:"hostname,omitempty"`
+	RequireAck  bool     `json:"requireAck"`
+	Timeout     Duration `json:"timeout,omitempty"`
+	BufferDir   string   `json:"bufferDir,omitempty"`
+	BufferLimit Size     `json:"bufferLimit,omitempty"`
+}
+
+// HTTPOutputConfig is shared by the outputs posting to an HTTP API.
+type HTTPOutputConfig struct {
+	URL                string   `json:"url,omitempty"`
+	Username           string   `json:"username,omitempty"`
+	Password           string   `json:"password,omitempty"`
+	CAFile             string   `json:"caFile,omitempty"`
+	InsecureSkipVerify bool     `json:"insecureSkipVerify,omitempty"`
+	Gzip               bool     `json:"gzip"`
+	BatchSize          Size     `json:"batchSize,omitempty"`
+	Timeout            Duration `json:"timeout,omitempty"`
+	BufferDir          string   `json:"bufferDir,omitempty"`
+	BufferLimit        Size     `json:"bufferLimit,omitempty"`
+}
+
+type LokiConfig struct {
+	HTTPOutputConfig
+	TenantID string `json:"tenantID,omitempty"`
+	// Labels are the record fields making up a stream
+	Labels []string `json:"labels,omitempty"`
+}
+
+type ElasticsearchConfig struct {
+	HTTPOutputConfig
+	// Index is a template over the record fields and date
+	Index string `json:"index,omitempty"`
+}
+
+type Config struct {
+	Log LogConfig `json:"log,omitempty"`
+
+	DrainTimeout  Duration `json:"drainTimeout,omitempty"`
+	DrainInterval Duration `json:"drainInterval,omitempty"`
+
+	KubeletDir     string   `json:"kubeletDir,omitempty"`
+	ListenAddress  string   `json:"listenAddress,omitempty"`
+	GCInterval     Duration `json:"gcInterval,omitempty"`
+	RotateInterval Duration `json:"rotateInterval,omitempty"`
+	RotateSize     Size     `json:"rotateSize,omitempty"`
+	RotateKeep     int      `json:"rotateKeep,omitempty"`
+	BudgetInterval Duration `json:"budgetInterval,omitempty"`
+	// DiskBudget bounds the disk usage of all volumes of the node, unbounded at 0 like the project budgets
+	DiskBudget Size `json:"diskBudget,omitempty"`
+	// ProjectDiskBudget bounds the volumes of every project, ProjectDiskBudgets overrides it by project id
+	ProjectDiskBudget  Size            `json:"projectDiskBudget,omitempty"`
+	ProjectDiskBudgets map[string]Size `json:"projectDiskBudgets,omitempty"`
+	// MemoryBudget bounds the sizeLimit of all memory volumes of the node together, unbounded at 0
+	MemoryBudget Size `json:"memoryBudget,omitempty"`
+
+	MetricsInterval Duration `json:"metricsInterval,omitempty"`
+
+	EventInterval      Duration `json:"eventInterval,omitempty"`
+	EventQPS           float64  `json:"eventQPS,omitempty"`
+	EventBurst         int      `json:"eventBurst,omitempty"`
+	EventFlushInterval Duration `json:"eventFlushInterval,omitempty"`
+
+	// TailerEnabled has the daemon follow the volumes itself, for nodes without fluentd
+	TailerEnabled bool     `json:"tailerEnabled,omitempty"`
+	TailInterval  Duration `json:"tailInterval,omitempty"`
+	TailOutput    string   `json:"tailOutput,omitempty"`
+
+	// SocketInterval is how soon the sockets of a new syslog or forward mode volume appear
+	SocketInterval Duration `json:"socketInterval,omitempty"`
+
+	// FifoInterval is how often the lines read from the pipes of fifo mode volumes are shipped, up to
+	// FifoBufferLimit bytes per volume are kept in memory until then
+	FifoInterval    Duration `json:"fifoInterval,omitempty"`
+	FifoBufferLimit Size     `json:"fifoBufferLimit,omitempty"`
+	FifoDropPolicy  string   `json:"fifoDropPolicy,omitempty"`
+
+	Forward       ForwardConfig       `json:"forward,omitempty"`
+	Loki          LokiConfig          `json:"loki,omitempty"`
+	Elasticsearch ElasticsearchConfig `json:"elasticsearch,omitempty"`
+}
+
+func Default() *Config {
+	return &Config{
+		Log: LogConfig{
+			File:       "/var/log/rancher-flexvolume.log",
+			Level:      "debug",
+			Format:     "text",
+			MaxSize:    10 << 20,
+			MaxBackups: 3,
+		},
+
+		DrainTimeout:   Duration{time.Minute},
+		DrainInterval:  Duration{2 * time.Second},
+		KubeletDir:     "/var/lib/kubelet",
+		ListenAddress:  ":9099",
+		GCInterval:     Duration{time.Minute},
+		RotateInterval: Duration{30 * time.Second},
+		RotateSize:     100 << 20,
+		RotateKeep:     2,
+		BudgetInterval: Duration{30 * time.Second},
+		MemoryBudget:   1 << 30,
+
+		MetricsInterval: Duration{15 * time.Second},
+
+		EventInterval:      Duration{5 * time.Minute},
+		EventQPS:           1,
+		EventBurst:         10,
+		EventFlushInterval: Duration{5 * time.Second},
+
+		TailInterval: Duration{time.Second},
+		TailOutput:   "stdout",
+
+		SocketInterval: Duration{time.Second},
+
+		FifoInterval:    Duration{time.Second},
+		FifoBufferLimit: 8 << 20,
+		FifoDropPolicy:  "drop-oldest",
+
+		Forward: ForwardConfig{
+			RequireAck:  true,
+			Timeout:     Duration{10 * time.Second},
+			BufferDir:   "/var/lib/rancher/log-aggregator/buffer/forward",
+			BufferLimit: 1 << 30,
+		},
+		Loki: LokiConfig{
+			HTTPOutputConfig: HTTPOutputConfig{
+				Gzip:        true,
+				BatchSize:   1 << 20,
+				Timeout:     Duration{10 * time.Second},
+				BufferDir:   "/var/lib/rancher/log-aggregator/buffer/loki",
+				BufferLimit: 1 << 30,
+			},
+			Labels: []string{"cluster", "project", "namespace", "workload", "container"},
+		},
+		Elasticsearch: ElasticsearchConfig{
+			HTTPOutputConfig: HTTPOutputConfig{
+				Gzip:        true,
+				BatchSize:   5 << 20,
+				Timeout:     Duration{10 * time.Second},
+				BufferDir:   "/var/lib/rancher/log-aggregator/buffer/elasticsearch",
+				BufferLimit: 1 << 30,
+			},
+			Index: "log-volume-{{.namespace}}-{{.date}}",
+		},
+	}
+}
+
+// Load reads the node level config file, a missing file means all defaults.
+func Load(path string) (*Config, error) {
+	conf := Default()
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return conf, nil
+		}
+		return conf, fmt.Errorf("read config file %s failed, %v", path, err)
+	}
+
+	if err := json.Unmarshal(b, conf); err != nil {
+		return Default(), fmt.Errorf("parse config file %s failed, %v", path, err)
+	}
+	if err := conf.validate(); err != nil {
+		return Default(), fmt.Errorf("invalid config file %s, %v", path, err)
+	}
+	return conf, nil
+}
+
+// validate rejects the intervals and timeouts that are not positive, the daemon tickers panic on them,
+// and fifo settings that would drop every line.
+func (c *Config) validate() error {
+	durations := []struct {
+		name  string
+		value Duration
+	}{
+		{"drainInterval", c.DrainInterval},
+		{"gcInterval", c.GCInterval},
+		{"rotateInterval", c.RotateInterval},
+		{"budgetInterval", c.BudgetInterval},
+		{"metricsInterval", c.MetricsInterval},
+		{"eventInterval", c.EventInterval},
+		{"eventFlushInterval", c.EventFlushInterval},
+		{"tailInterval", c.TailInterval},
+		{"socketInterval", c.SocketInterval},
+		{"fifoInterval", c.FifoInterval},
+		{"forward.timeout", c.Forward.Timeout},
+		{"loki.timeout", c.Loki.Timeout},
+		{"elasticsearch.timeout", c.Elasticsearch.Timeout},
+	}
+	for _, v := range durations {
+		if v.value.Duration <= 0 {
+			return fmt.Errorf("%s must be positive, got %s", v.name, v.value)
+		}
+	}
+
+	if c.FifoBufferLimit <= 0 {
+		return fmt.Errorf("fifoBufferLimit must be positive, got %d", c.FifoBufferLimit)
+	}
+	if c.FifoDropPolicy != fifo.DropOldest && c.FifoDropPolicy != fifo.DropNewest {
+		return fmt.Errorf("fifoDropPolicy must be %s or %s, got %q", fifo.DropOldest, fifo.DropNewest, c.FifoDropPolicy)
+	}
+	return nil
+}
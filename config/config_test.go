@@ -0,0 +1,63 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func loadString(t *testing.T, content string) (*Config, error) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "config.json")
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return Load(file)
+}
+
+func TestLoad(t *testing.T) {
+	conf, err := loadString(t, `{"gcInterval":"5m","fifoBufferLimit":"1Mi","fifoDropPolicy":"drop-newest"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.GCInterval.Duration != 5*time.Minute || conf.FifoBufferLimit != 1<<20 || conf.FifoDropPolicy != "drop-newest" {
+		t.Errorf("config %+v does not hold the file values", conf)
+	}
+	if conf.DrainInterval != Default().DrainInterval {
+		t.Errorf("drainInterval %s is not the default", conf.DrainInterval)
+	}
+
+	conf, err = Load(path.Join(os.TempDir(), "missing-log-aggregator-config.json"))
+	if err != nil || !reflect.DeepEqual(conf, Default()) {
+		t.Errorf("a missing file did not load the defaults, %v", err)
+	}
+}
+
+func TestLoadRejectsInvalidValues(t *testing.T) {
+	for _, content := range []string{
+		`{"gcInterval":"0s"}`,
+		`{"budgetInterval":"-1m"}`,
+		`{"forward":{"timeout":"0s"}}`,
+		`{"fifoBufferLimit":"0"}`,
+		`{"fifoBufferLimit":"-1Ki"}`,
+		`{"fifoDropPolicy":"drop-all"}`,
+		`{"fifoDropPolicy":""}`,
+		`{"gcInterval":5}`,
+	} {
+		conf, err := loadString(t, content)
+		if err == nil {
+			t.Errorf("config %s was accepted", content)
+			continue
+		}
+		if !reflect.DeepEqual(conf, Default()) {
+			t.Errorf("config %s did not fall back to the defaults", content)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package daemon
+
+import (
+	"github.com/rancher/log-aggregator/driver"
+	"github.com/rancher/log-aggregator/metrics"
+)
+
+type budgetKey struct {
+	scope  string
+	action string
+}
+
+type budgetCount struct {
+	files     uint64
+	bytes     int64
+	unshipped int64
+}
+
+// enforceDiskBudget adds the actions of every run to the budget counters.
+func (d *Daemon) enforceDiskBudget() error {
+	report, err := d.driver.EnforceDiskBudget()
+	if report == nil {
+		return err
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for _, a := range report.Actions {
+		key := budgetKey{scope: a.Scope, action: a.Action}
+		c := d.budgetCounts[key]
+		c.files++
+		c.bytes += a.Bytes
+		c.unshipped += a.Unshipped
+		d.budgetCounts[key] = c
+	}
+	d.budgetReport = report
+	return err
+}
+
+func (d *Daemon) budgetMetrics() []metrics.Family {
+	files := metrics.Family{
+		Name: "log_aggregator_budget_reclaimed_files_total",
+		Help: "Files removed or truncated to enforce the disk budgets.",
+		Type: metrics.TypeCounter,
+	}
+	bytes := metrics.Family{
+		Name: "log_aggregator_budget_reclaimed_bytes_total",
+		Help: "Bytes freed to enforce the disk budgets.",
+		Type: metrics.TypeCounter,
+	}
+	unshipped := metrics.Family{
+		Name: "log_aggregator_budget_dropped_bytes_total",
+		Help: "Bytes of logs no tailer read yet, dropped to enforce the disk budgets.",
+		Type: metrics.TypeCounter,
+	}
+	usage := metrics.Family{
+		Name: "log_aggregator_disk_budget_usage_bytes",
+		Help: "Bytes the log volumes use on disk after the last enforcement, of the node and by project.",
+		Type: metrics.TypeGauge,
+	}
+	budget := metrics.Family{
+		Name: "log_aggregator_disk_budget_bytes",
+		Help: "Disk budget of the node and of the projects with log volumes, 0 when unbounded.",
+		Type: metrics.TypeGauge,
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for key, c := range d.budgetCounts {
+		labels := map[string]string{"scope": key.scope, "action": key.action}
+		files.Metrics = append(files.Metrics, metrics.Metric{Labels: labels, Value: float64(c.files)})
+		bytes.Metrics = append(bytes.Metrics, metrics.Metric{Labels: labels, Value: float64(c.bytes)})
+		unshipped.Metrics = append(unshipped.Metrics, metrics.Metric{Labels: labels, Value: float64(c.unshipped)})
+	}
+	if d.budgetReport != nil {
+		node := map[string]string{"scope": driver.BudgetScopeNode}
+		usage.Metrics = append(usage.Metrics, metrics.Metric{Labels: node, Value: float64(d.budgetReport.NodeBytes)})
+		budget.Metrics = append(budget.Metrics, metrics.Metric{Labels: node, Value: float64(d.Config.DiskBudget)})
+		for project, n := range d.budgetReport.ProjectBytes {
+			if project == "" {
+				continue
+			}
+			labels := map[string]string{"scope": driver.BudgetScopeProject, "project_id": project}
+			usage.Metrics = append(usage.Metrics, metrics.Metric{Labels: labels, Value: float64(n)})
+			budget.Metrics = append(budget.Metrics, metrics.Metric{Labels: labels, Value: float64(d.driver.ProjectBudget(project))})
+		}
+	}
+	return []metrics.Family{files, bytes, unshipped, usage, budget}
+}
@@ -0,0 +1,352 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/rancher/log-aggregator/config"
+	"github.com/rancher/log-aggregator/driver"
+	"github.com/rancher/log-aggregator/events"
+	"github.com/rancher/log-aggregator/metrics"
+	"github.com/rancher/log-aggregator/output"
+	"github.com/rancher/log-aggregator/tailer"
+)
+
+const (
+	shutdownTimeout = 10 * time.Second
+
+	// a core task is unhealthy after failing this many runs in a row, or when it did not finish a run
+	// for staleIntervals of its interval plus staleGrace
+	maxConsecutiveFailures = 3
+	staleIntervals         = 3
+	staleGrace             = time.Minute
+)
+
+type task struct {
+	name     string
+	interval time.Duration
+	run      func() error
+	// core tasks keep the logs flowing, only they fail the health check
+	core bool
+}
+
+type taskStatus struct {
+	Core                bool      `json:"core"`
+	LastRun             time.Time `json:"lastRun"`
+	LastError           string    `json:"lastError,omitempty"`
+	Failures            int       `json:"failures"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	Stale               bool      `json:"stale,omitempty"`
+
+	interval time.Duration
+	since    time.Time
+}
+
+// healthy is false for a core task failing repeatedly or not finishing its runs, a single failure of a
+// transient error does not restart the daemon.
+func (s *taskStatus) healthy(now time.Time) bool {
+	last := s.LastRun
+	if last.IsZero() {
+		last = s.since
+	}
+	s.Stale = now.Sub(last) > staleIntervals*s.interval+staleGrace
+	return !s.Core || (s.ConsecutiveFailures < maxConsecutiveFailures && !s.Stale)
+}
+
+type health struct {
+	Healthy bool                   `json:"healthy"`
+	Tasks   map[string]*taskStatus `json:"tasks"`
+}
+
+// Daemon runs the periodic node level work of the driver next to the metrics and health endpoints.
+type Daemon struct {
+	Logger *logrus.Logger
+	Config *config.Config
+
+	driver   *driver.FlexVolumeDriver
+	drainer  *driver.Drainer
+	recorder *events.Recorder
+	output   output.Output
+	tailers  map[string]*tailer.Tailer
+	sockets  map[string]*socketServer
+	fifos    map[string]*fifoVolume
+
+	lock          sync.Mutex
+	status        map[string]*taskStatus
+	samples       map[string]volumeSample
+	volumeMetrics []metrics.Family
+	fifoMetrics   []metrics.Family
+	budgetCounts  map[budgetKey]budgetCount
+	budgetReport  *driver.BudgetReport
+}
+
+func New(logger *logrus.Logger, conf *config.Config) *Daemon {
+	return &Daemon{
+		Logger: logger,
+		Config: conf,
+		driver: &driver.FlexVolumeDriver{
+			Logger: logrus.NewEntry(logger),
+			Config: conf,
+		},
+		drainer: &driver.Drainer{
+			Logger:   logrus.NewEntry(logger),
+			Interval: conf.DrainInterval.Duration,
+		},
+		status:       make(map[string]*taskStatus),
+		budgetCounts: make(map[budgetKey]budgetCount),
+		tailers:      make(map[string]*tailer.Tailer),
+		sockets:      make(map[string]*socketServer),
+		fifos:        make(map[string]*fifoVolume),
+	}
+}
+
+// Run blocks until ctx is done or the http server fails, running tasks finish before it returns.
+func (d *Daemon) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if _, err := driver.MigrateVolumes(d.driver.Logger); err != nil {
+		d.Logger.Errorf("migrate volumes failed, %v", err)
+	}
+
+	d.recorder = d.newRecorder()
+	out, err := newOutput(d.Config)
+	if err != nil {
+		return err
+	}
+	d.output = out
+	defer d.closeOutput()
+	if d.Config.TailerEnabled {
+		defer d.closeTailers()
+	}
+	defer d.closeFifos()
+	defer d.closeSockets()
+
+	tasks := d.tasks()
+	d.registerTasks(tasks, time.Now())
+
+	var wg sync.WaitGroup
+	for _, t := range tasks {
+		wg.Add(1)
+		go func(t task) {
+			defer wg.Done()
+			d.loop(ctx, t)
+		}(t)
+	}
+
+	srv := &http.Server{
+		Addr:    d.Config.ListenAddress,
+		Handler: d.handler(),
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			errCh <- err
+		}
+	}()
+	d.Logger.Infof("daemon listening on %s", d.Config.ListenAddress)
+
+	select {
+	case <-ctx.Done():
+	case err = <-errCh:
+		cancel()
+	}
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer shutdownCancel()
+	if shutdownErr := srv.Shutdown(shutdownCtx); shutdownErr != nil {
+		d.Logger.Warnf("shutdown http server failed, %v", shutdownErr)
+	}
+
+	wg.Wait()
+	d.Logger.Info("daemon stopped")
+	return err
+}
+
+func (d *Daemon) tasks() []task {
+	tasks := []task{
+		{
+			name:     "drain",
+			interval: d.Config.DrainInterval.Duration,
+			core:     true,
+			run: func() error {
+				_, err := d.drainer.DrainPending()
+				return err
+			},
+		},
+		{
+			name:     "gc",
+			interval: d.Config.GCInterval.Duration,
+			run: func() error {
+				_, err := d.driver.CollectGarbage()
+				return err
+			},
+		},
+		{
+			name:     "rotate",
+			interval: d.Config.RotateInterval.Duration,
+			run:      d.driver.RotateFiles,
+		},
+		{
+			name:     "budget",
+			interval: d.Config.BudgetInterval.Duration,
+			run:      d.enforceDiskBudget,
+		},
+		{
+			name:     "metrics",
+			interval: d.Config.MetricsInterval.Duration,
+			run:      d.collectVolumeMetrics,
+		},
+		{
+			name:     "socket",
+			interval: d.Config.SocketInterval.Duration,
+			core:     true,
+			run:      d.serveSockets,
+		},
+		{
+			name:     "fifo",
+			interval: d.Config.FifoInterval.Duration,
+			core:     true,
+			run:      d.shipFifos,
+		},
+	}
+	if d.Config.TailerEnabled {
+		tasks = append(tasks, task{
+			name:     "tail",
+			interval: d.Config.TailInterval.Duration,
+			core:     true,
+			run:      d.tailVolumes,
+		})
+	}
+	if d.recorder != nil {
+		tasks = append(tasks, task{
+			name:     "events",
+			interval: d.Config.EventFlushInterval.Duration,
+			run:      d.flushEvents,
+		})
+	}
+	return tasks
+}
+
+// registerTasks lists the tasks in the health status before their first run, so a task stuck in it
+// becomes stale.
+func (d *Daemon) registerTasks(tasks []task, now time.Time) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for _, t := range tasks {
+		d.status[t.name] = &taskStatus{Core: t.core, interval: t.interval, since: now}
+	}
+}
+
+func (d *Daemon) loop(ctx context.Context, t task) {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		d.runTask(t)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *Daemon) runTask(t task) {
+	err := t.run()
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	status, ok := d.status[t.name]
+	if !ok {
+		status = &taskStatus{Core: t.core, interval: t.interval}
+		d.status[t.name] = status
+	}
+	status.LastRun = time.Now()
+	status.LastError = ""
+	if err != nil {
+		d.Logger.Errorf("task %s failed, %v", t.name, err)
+		status.LastError = err.Error()
+		status.Failures++
+		status.ConsecutiveFailures++
+		return
+	}
+	status.ConsecutiveFailures = 0
+}
+
+func (d *Daemon) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", d.serveHealth)
+	mux.HandleFunc("/metrics", d.serveMetrics)
+	return mux
+}
+
+// serveHealth answers 503 while a core task is unhealthy, the body shows the status of every task.
+func (d *Daemon) serveHealth(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	d.lock.Lock()
+	h := health{Healthy: true, Tasks: d.status}
+	for _, v := range d.status {
+		if !v.healthy(now) {
+			h.Healthy = false
+		}
+	}
+	b, err := json.Marshal(h)
+	d.lock.Unlock()
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !h.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(b)
+}
+
+func (d *Daemon) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	stats, err := driver.LoadStats()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	d.lock.Lock()
+	families := append(append([]metrics.Family{}, d.volumeMetrics...), d.fifoMetrics...)
+	d.lock.Unlock()
+	families = append(families, stats.Metrics()...)
+	families = append(families, d.budgetMetrics()...)
+	families = append(families, d.taskMetrics()...)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := metrics.Write(w, families); err != nil {
+		d.Logger.Warnf("write metrics failed, %v", err)
+	}
+}
+
+func (d *Daemon) taskMetrics() []metrics.Family {
+	failures := metrics.Family{
+		Name: "log_aggregator_daemon_task_failures_total",
+		Help: "Failed runs of the periodic daemon tasks.",
+		Type: metrics.TypeCounter,
+	}
+	lastRun := metrics.Family{
+		Name: "log_aggregator_daemon_task_last_run_timestamp_seconds",
+		Help: "Unix time of the last run of the periodic daemon tasks.",
+		Type: metrics.TypeGauge,
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for name, v := range d.status {
+		labels := map[string]string{"task": name}
+		failures.Metrics = append(failures.Metrics, metrics.Metric{Labels: labels, Value: float64(v.Failures)})
+		lastRun.Metrics = append(lastRun.Metrics, metrics.Metric{Labels: labels, Value: float64(v.LastRun.Unix())})
+	}
+	return []metrics.Family{failures, lastRun}
+}
@@ -0,0 +1,84 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func testDaemon(tasks []task) *Daemon {
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	d := &Daemon{Logger: logger, status: map[string]*taskStatus{}}
+	d.registerTasks(tasks, time.Now())
+	return d
+}
+
+func checkHealth(t *testing.T, d *Daemon) (int, health) {
+	w := httptest.NewRecorder()
+	d.serveHealth(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	var h health
+	if err := json.Unmarshal(w.Body.Bytes(), &h); err != nil {
+		t.Fatalf("invalid health body %s, %v", w.Body.String(), err)
+	}
+	return w.Code, h
+}
+
+func TestHealthToleratesTransientFailures(t *testing.T) {
+	var coreErr, otherErr error
+	core := task{name: "drain", interval: time.Minute, core: true, run: func() error { return coreErr }}
+	other := task{name: "gc", interval: time.Minute, run: func() error { return otherErr }}
+	d := testDaemon([]task{core, other})
+
+	otherErr = errors.New("gc failed")
+	for i := 0; i < maxConsecutiveFailures+1; i++ {
+		d.runTask(other)
+	}
+	coreErr = errors.New("drain failed")
+	for i := 0; i < maxConsecutiveFailures-1; i++ {
+		d.runTask(core)
+	}
+	code, h := checkHealth(t, d)
+	if code != http.StatusOK || !h.Healthy {
+		t.Errorf("transient core failures and a failing gc made the daemon unhealthy, %d", code)
+	}
+	if s := h.Tasks["gc"]; s == nil || s.ConsecutiveFailures != maxConsecutiveFailures+1 || s.LastError == "" {
+		t.Errorf("gc status %+v", s)
+	}
+
+	d.runTask(core)
+	if code, h := checkHealth(t, d); code != http.StatusServiceUnavailable || h.Healthy {
+		t.Errorf("%d failures in a row of a core task are healthy", maxConsecutiveFailures)
+	}
+
+	coreErr = nil
+	d.runTask(core)
+	if code, h := checkHealth(t, d); code != http.StatusOK || h.Tasks["drain"].ConsecutiveFailures != 0 || h.Tasks["drain"].Failures != maxConsecutiveFailures {
+		t.Errorf("a successful run did not recover, %d %+v", code, h.Tasks["drain"])
+	}
+}
+
+func TestHealthStaleCoreTask(t *testing.T) {
+	core := task{name: "tail", interval: time.Second, core: true, run: func() error { return nil }}
+	d := testDaemon([]task{core})
+	if code, _ := checkHealth(t, d); code != http.StatusOK {
+		t.Fatalf("a core task before its first run is unhealthy")
+	}
+
+	d.status["tail"].since = time.Now().Add(-staleIntervals*time.Second - staleGrace - time.Second)
+	code, h := checkHealth(t, d)
+	if code != http.StatusServiceUnavailable || !h.Tasks["tail"].Stale {
+		t.Errorf("a core task stuck in its first run is healthy, %+v", h.Tasks["tail"])
+	}
+
+	d.runTask(core)
+	if code, _ := checkHealth(t, d); code != http.StatusOK {
+		t.Errorf("a core task that finished a run is unhealthy")
+	}
+}
@@ -0,0 +1,40 @@
+package daemon
+
+import (
+	"os"
+
+	"github.com/rancher/log-aggregator/driver"
+	"github.com/rancher/log-aggregator/events"
+)
+
+const eventComponent = "log-aggregator"
+
+func (d *Daemon) newRecorder() *events.Recorder {
+	sink, err := events.InClusterSink(eventComponent, os.Getenv("NODE_NAME"))
+	if err != nil {
+		d.Logger.Warnf("pod events are disabled, %v", err)
+		return nil
+	}
+	return events.NewRecorder(sink, d.Config.EventInterval.Duration, d.Config.EventQPS, d.Config.EventBurst)
+}
+
+// flushEvents posts the events spooled by the driver calls, an event that fails to post stays for the next run.
+func (d *Daemon) flushEvents() error {
+	spool := driver.EventSpool()
+	spooled, err := spool.List()
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for _, v := range spooled {
+		if _, err := d.recorder.Record(v.Event); err != nil {
+			lastErr = err
+			continue
+		}
+		if err := spool.Remove(v); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
@@ -0,0 +1,126 @@
+package daemon
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rancher/log-aggregator/driver"
+	"github.com/rancher/log-aggregator/fifo"
+	"github.com/rancher/log-aggregator/metrics"
+	"github.com/rancher/log-aggregator/output"
+)
+
+type fifoVolume struct {
+	reader *fifo.Reader
+	tag    string
+	fields map[string]string
+	// labels are the metric labels of the volume
+	labels map[string]string
+}
+
+// shipFifos starts reading the pipes of every new fifo mode volume and hands what was read since the
+// last run to the tail output. The lines of a removed volume are shipped once more before it is closed.
+func (d *Daemon) shipFifos() error {
+	sources, err := driver.TailSources()
+	if err != nil {
+		return err
+	}
+
+	wanted := map[string]bool{}
+	var errs []string
+	for _, v := range sources {
+		if driver.VolumeMode(v.Volume) != driver.ModeFifo {
+			continue
+		}
+		name := v.Volume.IdentifyName()
+		wanted[name] = true
+		if _, ok := d.fifos[name]; ok {
+			continue
+		}
+
+		r, err := fifo.Open(v.Volume.HostDir, driver.FifoNames(v.Volume), int(d.Config.FifoBufferLimit), d.Config.FifoDropPolicy)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("read fifos in %s failed, %v", v.Volume.HostDir, err))
+			continue
+		}
+		d.Logger.Infof("read fifos in %s", v.Volume.HostDir)
+		d.fifos[name] = &fifoVolume{
+			reader: r,
+			tag:    tagPrefix + name,
+			fields: driver.RecordFields(v.Volume),
+			labels: driver.VolumeLabels(v.Volume),
+		}
+	}
+
+	for name, v := range d.fifos {
+		if err := d.shipFifo(v); err != nil {
+			errs = append(errs, err.Error())
+		}
+		if !wanted[name] {
+			d.Logger.Infof("stop reading fifos in %s", v.reader.Dir)
+			v.reader.Close()
+			delete(d.fifos, name)
+		}
+	}
+	if err := d.output.Flush(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	d.collectFifoMetrics()
+
+	if len(errs) != 0 {
+		return fmt.Errorf("%d volumes failed, %s", len(errs), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// shipFifo requeues the lines the output rejects, the buffer limit drops them once the output stays down.
+func (d *Daemon) shipFifo(v *fifoVolume) error {
+	lines := v.reader.Take()
+	if len(lines) == 0 {
+		return nil
+	}
+	records := make([]output.Record, 0, len(lines))
+	for _, line := range lines {
+		records = append(records, newRecord(v.tag, v.fields, line.Path, line.Text, line.Time))
+	}
+	if err := d.output.Write(records); err != nil {
+		v.reader.Requeue(lines)
+		return err
+	}
+	return nil
+}
+
+func (d *Daemon) collectFifoMetrics() {
+	buffered := metrics.Family{
+		Name: "log_aggregator_fifo_buffered_bytes",
+		Help: "Bytes read from the pipes of the fifo volume and not shipped yet.",
+		Type: metrics.TypeGauge,
+	}
+	dropped := metrics.Family{
+		Name: "log_aggregator_fifo_dropped_lines_total",
+		Help: "Lines of the fifo volume dropped because its buffer was full.",
+		Type: metrics.TypeCounter,
+	}
+	for _, v := range d.fifos {
+		size, n := v.reader.Stats()
+		buffered.Metrics = append(buffered.Metrics, metrics.Metric{Labels: v.labels, Value: float64(size)})
+		dropped.Metrics = append(dropped.Metrics, metrics.Metric{Labels: v.labels, Value: float64(n)})
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.fifoMetrics = []metrics.Family{buffered, dropped}
+}
+
+func (d *Daemon) closeFifos() {
+	for name, v := range d.fifos {
+		if err := d.shipFifo(v); err != nil {
+			d.Logger.Warnf("ship fifo lines of %s failed, %v", v.reader.Dir, err)
+		}
+		v.reader.Close()
+		delete(d.fifos, name)
+	}
+	if err := d.output.Flush(); err != nil {
+		d.Logger.Warnf("flush tail output failed, %v", err)
+	}
+}
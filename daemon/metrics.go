@@ -0,0 +1,83 @@
+package daemon
+
+import (
+	"time"
+
+	"github.com/rancher/log-aggregator/driver"
+	"github.com/rancher/log-aggregator/metrics"
+	"github.com/rancher/log-aggregator/volume"
+)
+
+type volumeSample struct {
+	written int64
+	at      time.Time
+}
+
+// collectVolumeMetrics samples every volume, the write rate is derived from the previous sample.
+func (d *Daemon) collectVolumeMetrics() error {
+	lags, err := driver.ListLags()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	diskBytes := metrics.Family{
+		Name: "log_aggregator_volume_disk_bytes",
+		Help: "Bytes allocated on disk by the log volume.",
+		Type: metrics.TypeGauge,
+	}
+	files := metrics.Family{
+		Name: "log_aggregator_volume_files",
+		Help: "Files in the log volume.",
+		Type: metrics.TypeGauge,
+	}
+	writeRate := metrics.Family{
+		Name: "log_aggregator_volume_write_bytes_per_second",
+		Help: "Bytes per second written to the log volume since the previous sample.",
+		Type: metrics.TypeGauge,
+	}
+	lastWrite := metrics.Family{
+		Name: "log_aggregator_volume_last_write_seconds",
+		Help: "Seconds since the last write to the log volume.",
+		Type: metrics.TypeGauge,
+	}
+
+	samples := make(map[string]volumeSample, len(lags))
+	for _, v := range lags {
+		labels := driver.VolumeLabels(v.Volume)
+		usage, err := volume.DiskUsage(v.Dir)
+		if err != nil {
+			d.Logger.Warnf("compute disk usage of %s failed, %v", v.IdentifyName(), err)
+			continue
+		}
+		diskBytes.Metrics = append(diskBytes.Metrics, metrics.Metric{Labels: labels, Value: float64(usage.Bytes)})
+		files.Metrics = append(files.Metrics, metrics.Metric{Labels: labels, Value: float64(len(v.Files))})
+
+		var written int64
+		var lastModified time.Time
+		for _, f := range v.Files {
+			written += f.Size
+			if f.ModTime.After(lastModified) {
+				lastModified = f.ModTime
+			}
+		}
+
+		sample := volumeSample{written: written, at: now}
+		samples[v.IdentifyName()] = sample
+		// a rotation shrinks the volume, skip the rate until the next sample
+		if prev, ok := d.samples[v.IdentifyName()]; ok && written >= prev.written {
+			rate := float64(written-prev.written) / now.Sub(prev.at).Seconds()
+			writeRate.Metrics = append(writeRate.Metrics, metrics.Metric{Labels: labels, Value: rate})
+		}
+		if !lastModified.IsZero() {
+			lastWrite.Metrics = append(lastWrite.Metrics, metrics.Metric{Labels: labels, Value: now.Sub(lastModified).Seconds()})
+		}
+	}
+
+	families := append([]metrics.Family{diskBytes, files, writeRate, lastWrite}, driver.LagMetrics(lags, now)...)
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.samples = samples
+	d.volumeMetrics = families
+	return nil
+}
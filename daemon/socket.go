@@ -0,0 +1,145 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rancher/log-aggregator/driver"
+	"github.com/rancher/log-aggregator/forward"
+	"github.com/rancher/log-aggregator/parser"
+	"github.com/rancher/log-aggregator/syslog"
+	"github.com/rancher/log-aggregator/volume"
+)
+
+// socketServer is the syslog or forward server of a volume.
+type socketServer struct {
+	mode   string
+	dir    string
+	server io.Closer
+}
+
+// serveSockets starts the sockets of every new syslog or forward mode volume and stops the ones of
+// removed volumes.
+func (d *Daemon) serveSockets() error {
+	sources, err := driver.TailSources()
+	if err != nil {
+		return err
+	}
+
+	wanted := map[string]bool{}
+	var errs []string
+	for _, v := range sources {
+		mode := driver.VolumeMode(v.Volume)
+		if mode != driver.ModeSyslog && mode != driver.ModeForward {
+			continue
+		}
+		name := v.Volume.IdentifyName()
+		wanted[name] = true
+		if _, ok := d.sockets[name]; ok {
+			continue
+		}
+
+		server, err := d.listen(mode, v.Volume)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		d.Logger.Infof("serve %s sockets in %s", mode, v.Volume.HostDir)
+		d.sockets[name] = &socketServer{mode: mode, dir: v.Volume.HostDir, server: server}
+	}
+
+	for name, s := range d.sockets {
+		if !wanted[name] {
+			d.Logger.Infof("stop serving %s sockets in %s", s.mode, s.dir)
+			if err := s.server.Close(); err != nil {
+				errs = append(errs, err.Error())
+			}
+			delete(d.sockets, name)
+		}
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("%d volumes failed, %s", len(errs), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (d *Daemon) listen(mode string, v volume.Volume) (io.Closer, error) {
+	if mode == driver.ModeForward {
+		return forward.Listen(v.HostDir, d.forwardHandler(v))
+	}
+
+	loc, err := parser.ParseTimezone(v.Metadata["timezone"])
+	if err != nil {
+		return nil, err
+	}
+	return syslog.Listen(v.HostDir, d.syslogHandler(v), loc)
+}
+
+// syslogHandler appends the messages as json lines to the syslog file of the volume, from where they
+// are shipped with the volume metadata like the lines of any other file.
+func (d *Daemon) syslogHandler(v volume.Volume) syslog.Handler {
+	write := d.lineWriter(driver.SyslogFilePath(v))
+	return func(m syslog.Message) {
+		b, err := json.Marshal(m)
+		if err != nil {
+			d.Logger.Warnf("encode syslog message for %s failed, %v", v.HostDir, err)
+			return
+		}
+		write(append(b, '\n'))
+	}
+}
+
+// forwardHandler appends the events to the forward file of the volume like syslogHandler, the record
+// with the tag and time of the event.
+func (d *Daemon) forwardHandler(v volume.Volume) forward.Handler {
+	write := d.lineWriter(driver.ForwardFilePath(v))
+	return func(events []forward.Event) {
+		var lines []byte
+		for _, e := range events {
+			e.Record["tag"] = e.Tag
+			e.Record["time"] = e.Time.Format(time.RFC3339Nano)
+			b, err := json.Marshal(e.Record)
+			if err != nil {
+				d.Logger.Warnf("encode forward event for %s failed, %v", v.HostDir, err)
+				continue
+			}
+			lines = append(append(lines, b...), '\n')
+		}
+		if len(lines) != 0 {
+			write(lines)
+		}
+	}
+}
+
+// lineWriter opens the file for every write, so a rotated file is followed by a new one.
+func (d *Daemon) lineWriter(file string) func([]byte) {
+	var lock sync.Mutex
+	return func(b []byte) {
+		lock.Lock()
+		defer lock.Unlock()
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			d.Logger.Warnf("write to %s failed, %v", file, err)
+			return
+		}
+		defer f.Close()
+		if _, err := f.Write(b); err != nil {
+			d.Logger.Warnf("write to %s failed, %v", file, err)
+		}
+	}
+}
+
+func (d *Daemon) closeSockets() {
+	for name, s := range d.sockets {
+		if err := s.server.Close(); err != nil {
+			d.Logger.Warnf("close %s sockets in %s failed, %v", s.mode, s.dir, err)
+		}
+		delete(d.sockets, name)
+	}
+}
@@ -0,0 +1,229 @@
+package daemon
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rancher/log-aggregator/config"
+	"github.com/rancher/log-aggregator/driver"
+	"github.com/rancher/log-aggregator/output"
+	"github.com/rancher/log-aggregator/tailer"
+	"github.com/rancher/log-aggregator/volume"
+)
+
+const tagPrefix = "log-volume."
+
+func newOutput(conf *config.Config) (output.Output, error) {
+	switch conf.TailOutput {
+	case output.TypeStdout, "":
+		return &output.Stdout{W: os.Stdout}, nil
+	case output.TypeForward:
+		return newForward(conf.Forward)
+	case output.TypeLoki:
+		return newLoki(conf.Loki)
+	case output.TypeElasticsearch:
+		return newElasticsearch(conf.Elasticsearch)
+	default:
+		return nil, fmt.Errorf("unsupported tail output %s", conf.TailOutput)
+	}
+}
+
+func newForward(conf config.ForwardConfig) (*output.Forward, error) {
+	if conf.Address == "" {
+		return nil, fmt.Errorf("forward output requires an address")
+	}
+
+	hostname := conf.Hostname
+	if hostname == "" {
+		hostname = os.Getenv("NODE_NAME")
+	}
+	if hostname == "" {
+		hostname, _ = os.Hostname()
+	}
+
+	var tlsConfig *tls.Config
+	if conf.TLS {
+		var err error
+		if tlsConfig, err = newTLSConfig(conf.CAFile, conf.ServerName, conf.InsecureSkipVerify); err != nil {
+			return nil, err
+		}
+	}
+
+	return &output.Forward{
+		Address:    conf.Address,
+		TLS:        tlsConfig,
+		SharedKey:  conf.SharedKey,
+		Hostname:   hostname,
+		RequireAck: conf.RequireAck,
+		Timeout:    conf.Timeout.Duration,
+		Buffer: &output.Buffer{
+			Dir:   conf.BufferDir,
+			Limit: int64(conf.BufferLimit),
+		},
+	}, nil
+}
+
+func newLoki(conf config.LokiConfig) (*output.Loki, error) {
+	target, err := newHTTPTarget(conf.HTTPOutputConfig)
+	if err != nil {
+		return nil, err
+	}
+	labels := conf.Labels
+	if len(labels) == 0 {
+		labels = output.DefaultLokiLabels
+	}
+	return &output.Loki{
+		URL:       conf.URL,
+		TenantID:  conf.TenantID,
+		Labels:    labels,
+		BatchSize: int(conf.BatchSize),
+		HTTP:      target,
+		Buffer:    &output.Buffer{Dir: conf.BufferDir, Limit: int64(conf.BufferLimit)},
+	}, nil
+}
+
+func newElasticsearch(conf config.ElasticsearchConfig) (*output.Elasticsearch, error) {
+	target, err := newHTTPTarget(conf.HTTPOutputConfig)
+	if err != nil {
+		return nil, err
+	}
+	index := conf.Index
+	if index == "" {
+		index = output.DefaultIndex
+	}
+	tmpl, err := output.ParseIndex(index)
+	if err != nil {
+		return nil, err
+	}
+	return &output.Elasticsearch{
+		URL:       conf.URL,
+		Index:     tmpl,
+		BatchSize: int(conf.BatchSize),
+		HTTP:      target,
+		Buffer:    &output.Buffer{Dir: conf.BufferDir, Limit: int64(conf.BufferLimit)},
+	}, nil
+}
+
+func newHTTPTarget(conf config.HTTPOutputConfig) (output.HTTPTarget, error) {
+	if conf.URL == "" {
+		return output.HTTPTarget{}, fmt.Errorf("http output requires a url")
+	}
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	if strings.HasPrefix(conf.URL, "https://") {
+		tlsConfig, err := newTLSConfig(conf.CAFile, "", conf.InsecureSkipVerify)
+		if err != nil {
+			return output.HTTPTarget{}, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	return output.HTTPTarget{
+		Client:   &http.Client{Transport: transport, Timeout: conf.Timeout.Duration},
+		Username: conf.Username,
+		Password: conf.Password,
+		Gzip:     conf.Gzip,
+	}, nil
+}
+
+func newTLSConfig(caFile, serverName string, insecureSkipVerify bool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: insecureSkipVerify,
+	}
+	if caFile == "" {
+		return tlsConfig, nil
+	}
+	ca, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("read ca %s failed, %v", caFile, err)
+	}
+	tlsConfig.RootCAs = x509.NewCertPool()
+	if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificate found in ca %s", caFile)
+	}
+	return tlsConfig, nil
+}
+
+// tailVolumes starts a tailer for every new volume, stops the ones of removed volumes and polls the rest.
+func (d *Daemon) tailVolumes() error {
+	sources, err := driver.TailSources()
+	if err != nil {
+		return err
+	}
+
+	wanted := make(map[string]bool, len(sources))
+	var errs []string
+	for _, v := range sources {
+		name := v.Volume.IdentifyName()
+		wanted[name] = true
+		t, ok := d.tailers[name]
+		if !ok {
+			if t, err = tailer.New(v.Volume.HostDir, v.PosFile, d.tailHandler(v.Volume)); err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+			d.Logger.Infof("start tailing %s", v.Volume.HostDir)
+			d.tailers[name] = t
+		}
+		if err := t.Poll(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if err := d.output.Flush(); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	for name, t := range d.tailers {
+		if !wanted[name] {
+			d.Logger.Infof("stop tailing %s", t.Dir)
+			t.Close()
+			delete(d.tailers, name)
+		}
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("%d volumes failed, %s", len(errs), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (d *Daemon) tailHandler(v volume.Volume) tailer.Handler {
+	tag := tagPrefix + v.IdentifyName()
+	labels := driver.RecordFields(v)
+	return func(lines []tailer.Line) error {
+		records := make([]output.Record, 0, len(lines))
+		for _, line := range lines {
+			records = append(records, newRecord(tag, labels, line.Path, line.Text, line.Time))
+		}
+		return d.output.Write(records)
+	}
+}
+
+func newRecord(tag string, labels map[string]string, file string, text []byte, t time.Time) output.Record {
+	fields := make(map[string]interface{}, len(labels)+2)
+	for k, v := range labels {
+		fields[k] = v
+	}
+	fields["file"] = file
+	fields["log"] = string(text)
+	return output.Record{Tag: tag, Time: t, Fields: fields}
+}
+
+func (d *Daemon) closeTailers() {
+	for name, t := range d.tailers {
+		t.Close()
+		delete(d.tailers, name)
+	}
+}
+
+func (d *Daemon) closeOutput() {
+	if err := d.output.Close(); err != nil {
+		d.Logger.Warnf("close tail output failed, %v", err)
+	}
+}
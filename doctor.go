@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/rancher/log-aggregator/driver"
+)
+
+// printChecks prints the report and fails when any check failed, so scripts can rely on the exit code.
+func printChecks(checks []driver.Check, output string) error {
+	switch output {
+	case "json":
+		if err := printJSON(checks); err != nil {
+			return err
+		}
+	case "table", "":
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "STATUS\tCHECK\tMESSAGE")
+		for _, v := range checks {
+			status := "PASS"
+			if !v.Passed {
+				status = "FAIL"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", status, v.Name, v.Message)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported output %s", output)
+	}
+
+	var failures int
+	for _, v := range checks {
+		if !v.Passed {
+			failures++
+		}
+	}
+	if failures != 0 {
+		return fmt.Errorf("%d of %d checks failed", failures, len(checks))
+	}
+	return nil
+}
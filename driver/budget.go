@@ -0,0 +1,334 @@
+package driver
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/rancher/log-aggregator/events"
+	"github.com/rancher/log-aggregator/posfile"
+	"github.com/rancher/log-aggregator/volume"
+)
+
+const (
+	BudgetScopeNode    = "node"
+	BudgetScopeProject = "project"
+
+	// BudgetRemoveRotated removes a copy of RotateFiles, it was only made of data already shipped
+	BudgetRemoveRotated = "remove_rotated"
+	// BudgetTruncateShipped truncates a file the pos files show as read to the end
+	BudgetTruncateShipped = "truncate_shipped"
+	// BudgetTruncateUnshipped truncates a file with data no tailer read yet, that data is lost
+	BudgetTruncateUnshipped = "truncate_unshipped"
+)
+
+// BudgetAction is one file removed or truncated to get back within a budget.
+type BudgetAction struct {
+	Scope   string
+	Project string
+	Volume  volume.Volume
+	Action  string
+	Path    string
+	// Bytes is the disk space freed
+	Bytes int64
+	// Unshipped is the part of the file no tailer read yet
+	Unshipped int64
+}
+
+// BudgetReport is the disk usage of the volumes once the budgets are enforced.
+type BudgetReport struct {
+	NodeBytes    int64
+	ProjectBytes map[string]int64
+	Actions      []BudgetAction
+}
+
+type budgetVolume struct {
+	volume  volume.Volume
+	project string
+	usage   int64
+}
+
+type budgetFile struct {
+	volume *budgetVolume
+	path   string
+	size   int64
+	unread int64
+	action string
+	mtime  time.Time
+	done   bool
+}
+
+// budgetOrder reclaims shipped data before unshipped data, the oldest first.
+var budgetOrder = map[string]int{BudgetRemoveRotated: 0, BudgetTruncateShipped: 1, BudgetTruncateUnshipped: 2}
+
+// EnforceDiskBudget gets the log volumes of every project within its project budget and then all of
+// them within the disk budget, by removing rotated files, then truncating files already shipped and
+// only then truncating the oldest files with unshipped data. Memory volumes use no disk and are left out.
+func (f *FlexVolumeDriver) EnforceDiskBudget() (*BudgetReport, error) {
+	volumes, err := volume.List(svcLogBaseDir)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := posfile.ParseDir(svcLogPosDir)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &BudgetReport{ProjectBytes: map[string]int64{}}
+	var all []*budgetVolume
+	var files []*budgetFile
+	for _, v := range volumes {
+		if v.HostDir == "" || v.Metadata["medium"] == MediumMemory {
+			continue
+		}
+		if v.Metadata == nil {
+			v.Metadata = decodeHostDir(v.HostDir)
+		}
+
+		usage, err := volume.DiskUsage(v.Dir)
+		if err != nil {
+			return nil, err
+		}
+		bv := &budgetVolume{volume: v, project: v.Metadata["projectID"], usage: usage.Bytes}
+		all = append(all, bv)
+
+		volumeFiles, err := budgetFiles(bv, idx)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, volumeFiles...)
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		if budgetOrder[files[i].action] != budgetOrder[files[j].action] {
+			return budgetOrder[files[i].action] < budgetOrder[files[j].action]
+		}
+		return files[i].mtime.Before(files[j].mtime)
+	})
+
+	projects := map[string][]*budgetFile{}
+	for _, v := range files {
+		projects[v.volume.project] = append(projects[v.volume.project], v)
+	}
+	for _, v := range all {
+		report.ProjectBytes[v.project] += v.usage
+	}
+
+	var errs []string
+	for project, usage := range report.ProjectBytes {
+		budget := f.ProjectBudget(project)
+		if project == "" || budget <= 0 || usage <= budget {
+			continue
+		}
+		actions, usage, err := f.reclaim(projects[project], usage, budget, BudgetScopeProject, project)
+		report.Actions = append(report.Actions, actions...)
+		report.ProjectBytes[project] = usage
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	for _, v := range report.ProjectBytes {
+		report.NodeBytes += v
+	}
+	if budget := int64(f.Config.DiskBudget); budget > 0 && report.NodeBytes > budget {
+		actions, usage, err := f.reclaim(files, report.NodeBytes, budget, BudgetScopeNode, "")
+		report.Actions = append(report.Actions, actions...)
+		report.NodeBytes = usage
+		for _, a := range actions {
+			report.ProjectBytes[a.Project] -= a.Bytes
+		}
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	f.reportBudgetActions(report.Actions)
+	if len(errs) != 0 {
+		return report, fmt.Errorf("enforce disk budget failed, %s", strings.Join(errs, "; "))
+	}
+	return report, nil
+}
+
+// ProjectBudget returns the disk budget of the project, 0 when it has none.
+func (f *FlexVolumeDriver) ProjectBudget(project string) int64 {
+	if v, ok := f.Config.ProjectDiskBudgets[project]; ok {
+		return int64(v)
+	}
+	return int64(f.Config.ProjectDiskBudget)
+}
+
+// budgetFiles lists the rotated copies and the tailed files of the volume with what reclaiming them takes.
+func budgetFiles(bv *budgetVolume, idx posfile.Index) ([]*budgetFile, error) {
+	var result []*budgetFile
+	rotated, err := rotatedFiles(bv.volume.HostDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range rotated {
+		info, err := os.Stat(v)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		result = append(result, &budgetFile{volume: bv, path: v, size: volume.AllocatedSize(info), action: BudgetRemoveRotated, mtime: info.ModTime()})
+	}
+
+	lag, err := volume.ComputeLag(bv.volume.HostDir, idx)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range lag.Files {
+		info, err := os.Stat(v.Path)
+		if err != nil || volume.AllocatedSize(info) == 0 {
+			continue
+		}
+		action := BudgetTruncateUnshipped
+		if v.Tracked && v.UnreadBytes == 0 {
+			action = BudgetTruncateShipped
+		}
+		result = append(result, &budgetFile{volume: bv, path: v.Path, size: volume.AllocatedSize(info), unread: v.UnreadBytes, action: action, mtime: v.ModTime})
+	}
+	return result, nil
+}
+
+// rotatedFiles lists the copies in the rotated dirs of every dir under hostDir, rotateFile puts them
+// next to the file it rotated.
+func rotatedFiles(hostDir string) ([]string, error) {
+	var result []string
+	err := filepath.Walk(hostDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() || info.Name() != rotatedDirName {
+			return nil
+		}
+		copies, err := filepath.Glob(path.Join(p, "*"))
+		if err != nil {
+			return err
+		}
+		result = append(result, copies...)
+		return filepath.SkipDir
+	})
+	return result, err
+}
+
+// reclaim works through the sorted files until usage fits into budget, a file reclaimed for one
+// budget is skipped by the next.
+func (f *FlexVolumeDriver) reclaim(files []*budgetFile, usage, budget int64, scope, project string) ([]BudgetAction, int64, error) {
+	name := scope
+	if project != "" {
+		name += " " + project
+	}
+	var actions []BudgetAction
+	for _, v := range files {
+		if usage <= budget {
+			return actions, usage, nil
+		}
+		if v.done {
+			continue
+		}
+
+		var err error
+		if v.action == BudgetRemoveRotated {
+			if err = os.Remove(v.path); os.IsNotExist(err) {
+				err = nil
+			}
+		} else {
+			err = os.Truncate(v.path, 0)
+		}
+		if err != nil {
+			return actions, usage, errors.Wrapf(err, "%s %s failed", v.action, v.path)
+		}
+
+		v.done = true
+		v.volume.usage -= v.size
+		usage -= v.size
+		actions = append(actions, BudgetAction{
+			Scope:     scope,
+			Project:   v.volume.project,
+			Volume:    v.volume.volume,
+			Action:    v.action,
+			Path:      v.path,
+			Bytes:     v.size,
+			Unshipped: v.unread,
+		})
+		if v.action == BudgetTruncateUnshipped {
+			f.Logger.Warnf("truncated %s with %d unshipped bytes to enforce the %s disk budget", v.path, v.unread, name)
+		} else {
+			f.Logger.Infof("%s %s to enforce the %s disk budget", v.action, v.path, name)
+		}
+	}
+
+	if usage > budget {
+		f.Logger.Warnf("log volumes use %d bytes above the %s disk budget of %d bytes with nothing left to reclaim", usage, name, budget)
+	}
+	return actions, usage, nil
+}
+
+// reportBudgetActions leaves one pod event per volume for the daemon to post, a warning once
+// unshipped logs were dropped.
+func (f *FlexVolumeDriver) reportBudgetActions(actions []BudgetAction) {
+	type summary struct {
+		volume  volume.Volume
+		scopes  map[string]bool
+		counts  map[string]int
+		bytes   int64
+		dropped int64
+	}
+	var order []string
+	summaries := map[string]*summary{}
+	for _, a := range actions {
+		name := a.Volume.IdentifyName()
+		s, ok := summaries[name]
+		if !ok {
+			s = &summary{volume: a.Volume, scopes: map[string]bool{}, counts: map[string]int{}}
+			summaries[name] = s
+			order = append(order, name)
+		}
+		s.scopes[a.Scope] = true
+		s.counts[a.Action]++
+		s.bytes += a.Bytes
+		s.dropped += a.Unshipped
+	}
+
+	for _, name := range order {
+		s := summaries[name]
+		if s.volume.Metadata["namespace"] == "" || s.volume.Metadata["kubernetes.io/pod.name"] == "" {
+			continue
+		}
+		var scopes []string
+		for _, scope := range []string{BudgetScopeProject, BudgetScopeNode} {
+			if s.scopes[scope] {
+				scopes = append(scopes, scope)
+			}
+		}
+
+		e := events.Event{
+			Namespace: s.volume.Metadata["namespace"],
+			PodName:   s.volume.Metadata["kubernetes.io/pod.name"],
+			PodUID:    s.volume.PodUID,
+			Type:      events.TypeNormal,
+			Reason:    EventDiskBudgetExceeded,
+			Message: fmt.Sprintf("%s disk budget exceeded, freed %d bytes of log volume %s: %d rotated files removed, %d shipped and %d unshipped files truncated",
+				strings.Join(scopes, " and "), s.bytes, s.volume.VolumeName,
+				s.counts[BudgetRemoveRotated], s.counts[BudgetTruncateShipped], s.counts[BudgetTruncateUnshipped]),
+			Time: time.Now(),
+		}
+		if s.dropped != 0 {
+			e.Type = events.TypeWarning
+			e.Message += fmt.Sprintf(", %d bytes of logs were dropped before they were shipped", s.dropped)
+		}
+		if err := EventSpool().Write(e); err != nil {
+			f.Logger.Warnf("spool event for pod %s/%s failed, %v", e.Namespace, e.PodName, err)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package driver
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/rancher/log-aggregator/posfile"
+	"github.com/rancher/log-aggregator/volume"
+)
+
+func TestBudgetFilesFindsNestedRotatedCopies(t *testing.T) {
+	hostDir, err := ioutil.TempDir("", "budget")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(hostDir)
+
+	for _, file := range []string{"app.log", "nginx/access.log"} {
+		file = path.Join(hostDir, file)
+		if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte("line\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := rotateFile(file, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bv := &budgetVolume{volume: volume.Volume{HostDir: hostDir}}
+	files, err := budgetFiles(bv, posfile.Index{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated := map[string]bool{}
+	for _, v := range files {
+		if v.action != BudgetRemoveRotated {
+			continue
+		}
+		if v.unread != 0 {
+			t.Errorf("rotated copy %s counts %d unshipped bytes", v.path, v.unread)
+		}
+		rotated[path.Dir(v.path)] = true
+	}
+	for _, dir := range []string{path.Join(hostDir, rotatedDirName), path.Join(hostDir, "nginx", rotatedDirName)} {
+		if !rotated[dir] {
+			t.Errorf("the rotated copy in %s is not a budget candidate, %+v", dir, files)
+		}
+	}
+}
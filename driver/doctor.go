@@ -0,0 +1,327 @@
+package driver
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/rancher/log-aggregator/installer"
+	"github.com/rancher/log-aggregator/mountinfo"
+	"github.com/rancher/log-aggregator/posfile"
+	"github.com/rancher/log-aggregator/volume"
+)
+
+const (
+	// stagingGrace and trackGrace leave room for a mount that is still running
+	stagingGrace    = time.Minute
+	trackGrace      = time.Minute
+	fluentdPosDir   = "/fluentd/log/"
+	deletedRootMark = "//deleted"
+)
+
+type Check struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message"`
+}
+
+func passed(name, format string, args ...interface{}) Check {
+	return Check{Name: name, Passed: true, Message: fmt.Sprintf(format, args...)}
+}
+
+func failed(name, format string, args ...interface{}) Check {
+	return Check{Name: name, Message: fmt.Sprintf(format, args...)}
+}
+
+// Diagnose checks the node for the misconfigurations that break log volumes, opts locates the installed driver.
+func (f *FlexVolumeDriver) Diagnose(opts installer.Options) []Check {
+	var result []Check
+	result = append(result, checkDriver(opts))
+	for _, v := range append(precreateDirs(), svcLogPosDir, path.Dir(svcDrainDir)) {
+		result = append(result, checkDir(v))
+	}
+	result = append(result, checkStagingFiles())
+	result = append(result, checkConfigs()...)
+
+	volumes, err := volume.List(svcLogBaseDir)
+	if err != nil {
+		return append(result, failed("volumes", "list volumes in %s failed, %v", svcLogBaseDir, err))
+	}
+	result = append(result, checkTracked(volumes))
+	result = append(result, f.checkOrphans(volumes))
+
+	mounts, err := installer.MountTable(opts.HostRoot)
+	if err != nil {
+		return append(result, failed("mounts", "%v", err))
+	}
+	result = append(result, f.checkStaleMounts(mounts, opts.DriverName))
+	result = append(result, f.checkPropagation(mounts))
+	return result
+}
+
+func checkDriver(opts installer.Options) Check {
+	const name = "driver"
+	installed, err := installer.Installed(opts)
+	if err != nil {
+		return failed(name, "%v", err)
+	}
+	if installed.Modified {
+		return failed(name, "%s was modified after it was installed", installed.Path)
+	}
+	if opts.Version != "" && installed.Version != opts.Version {
+		return failed(name, "%s is version %s, this binary is %s", installed.Path, installed.Version, opts.Version)
+	}
+	return passed(name, "%s version %s", installed.Path, installed.Version)
+}
+
+func checkDir(dir string) Check {
+	name := "dir " + dir
+	info, err := os.Stat(dir)
+	if err != nil {
+		return failed(name, "%v", err)
+	}
+	if !info.IsDir() {
+		return failed(name, "%s is not a directory", dir)
+	}
+	if err := unix.Access(dir, unix.W_OK); err != nil {
+		return failed(name, "%s is not writable, %v", dir, err)
+	}
+	return passed(name, "exists and is writable")
+}
+
+// checkStagingFiles finds the config files a crashed mount left in the tmp dirs.
+func checkStagingFiles() Check {
+	const name = "staging files"
+	var stale []string
+	for _, dir := range []string{tmpClusterDir, tmpProjectDir} {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil && !os.IsNotExist(err) {
+			return failed(name, "read %s failed, %v", dir, err)
+		}
+		for _, v := range files {
+			if time.Since(v.ModTime()) > stagingGrace {
+				stale = append(stale, path.Join(dir, v.Name()))
+			}
+		}
+	}
+	if len(stale) != 0 {
+		return failed(name, "%d leftover files: %s", len(stale), strings.Join(stale, ", "))
+	}
+	return passed(name, "none left")
+}
+
+func checkConfigs() []Check {
+	var result []Check
+	for _, dir := range []string{svcClusterLogConfigDir, svcProjectLogConfigDir} {
+		files, err := filepath.Glob(path.Join(dir, "*.conf"))
+		if err != nil {
+			result = append(result, failed("configs "+dir, "%v", err))
+			continue
+		}
+		var broken []string
+		for _, v := range files {
+			if err := checkConfigFile(v); err != nil {
+				broken = append(broken, fmt.Sprintf("%s: %v", path.Base(v), err))
+			}
+		}
+		if len(broken) != 0 {
+			result = append(result, failed("configs "+dir, "%d of %d broken, %s", len(broken), len(files), strings.Join(broken, "; ")))
+			continue
+		}
+		result = append(result, passed("configs "+dir, "%d parsed", len(files)))
+	}
+	return result
+}
+
+// checkConfigFile requires every source to tail with a pos file in the fluentd log dir.
+func checkConfigFile(file string) error {
+	sources, err := parseConfigFile(file)
+	if err != nil {
+		return err
+	}
+	for i, v := range sources {
+		if err := checkSource(v); err != nil {
+			return fmt.Errorf("source %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// parseConfigFile parses the subset of the fluentd syntax the generator writes and returns the
+// top level parameters of every source.
+func parseConfigFile(file string) ([]map[string]string, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		stack   []string
+		sources []map[string]string
+	)
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "</"):
+			if !strings.HasSuffix(line, ">") {
+				return nil, fmt.Errorf("line %d: unterminated %s", n, line)
+			}
+			directive := line[2 : len(line)-1]
+			if len(stack) == 0 || stack[len(stack)-1] != directive {
+				return nil, fmt.Errorf("line %d: unexpected %s", n, line)
+			}
+			stack = stack[:len(stack)-1]
+		case strings.HasPrefix(line, "<"):
+			if !strings.HasSuffix(line, ">") {
+				return nil, fmt.Errorf("line %d: unterminated %s", n, line)
+			}
+			directive := strings.Fields(line[1 : len(line)-1])
+			if len(directive) == 0 {
+				return nil, fmt.Errorf("line %d: empty directive", n)
+			}
+			stack = append(stack, directive[0])
+			if len(stack) == 1 && directive[0] == "source" {
+				sources = append(sources, map[string]string{})
+			}
+		default:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("line %d: parameter outside of a directive", n)
+			}
+			parts := strings.SplitN(line, " ", 2)
+			if len(stack) == 1 && stack[0] == "source" {
+				params := sources[len(sources)-1]
+				params[parts[0]] = ""
+				if len(parts) == 2 {
+					params[parts[0]] = strings.TrimSpace(parts[1])
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(stack) != 0 {
+		return nil, fmt.Errorf("<%s> is not closed", stack[len(stack)-1])
+	}
+	return sources, nil
+}
+
+func checkSource(params map[string]string) error {
+	for _, v := range []string{"@type", "path", "pos_file"} {
+		if params[v] == "" {
+			return fmt.Errorf("source without %s", v)
+		}
+	}
+	if !strings.HasPrefix(params["pos_file"], fluentdPosDir) {
+		return fmt.Errorf("pos_file %s is outside of %s", params["pos_file"], fluentdPosDir)
+	}
+	return nil
+}
+
+// checkTracked finds volumes fluentd never picked up, usually because its pos dir is not svcLogPosDir.
+func checkTracked(volumes []volume.Volume) Check {
+	const name = "pos files"
+	idx, err := posfile.ParseDir(svcLogPosDir)
+	if err != nil {
+		return failed(name, "parse pos files in %s failed, %v", svcLogPosDir, err)
+	}
+
+	var untracked []string
+	for _, v := range volumes {
+		lag, err := volume.ComputeLag(v.Dir, idx)
+		if err != nil {
+			return failed(name, "compute lag of %s failed, %v", v.IdentifyName(), err)
+		}
+		for _, file := range lag.Files {
+			if !file.Tracked && time.Since(file.ModTime) > trackGrace {
+				untracked = append(untracked, v.IdentifyName())
+				break
+			}
+		}
+	}
+	if len(untracked) != 0 {
+		return failed(name, "fluentd tracks no pos file for %d volumes in %s, is it the pos dir of the fluentd container? %s",
+			len(untracked), svcLogPosDir, strings.Join(untracked, ", "))
+	}
+	return passed(name, "fluentd tracks all %d volumes", len(volumes))
+}
+
+func (f *FlexVolumeDriver) checkOrphans(volumes []volume.Volume) Check {
+	const name = "orphaned volumes"
+	podsDir := path.Join(f.Config.KubeletDir, "pods")
+	if _, err := os.Stat(podsDir); err != nil {
+		return failed(name, "kubelet pods dir %s not accessible, %v", podsDir, err)
+	}
+
+	var orphans []string
+	for _, v := range volumes {
+		if _, err := os.Stat(path.Join(podsDir, v.PodUID)); !os.IsNotExist(err) {
+			continue
+		}
+		if _, err := os.Stat(drainRequestPath(v.IdentifyName())); err == nil {
+			continue
+		}
+		orphans = append(orphans, v.IdentifyName())
+	}
+	if len(orphans) != 0 {
+		return failed(name, "%d volumes of deleted pods are not draining, is the daemon running? %s", len(orphans), strings.Join(orphans, ", "))
+	}
+	return passed(name, "none")
+}
+
+// checkStaleMounts finds driver mounts whose pod or host dir is gone.
+func (f *FlexVolumeDriver) checkStaleMounts(mounts []mountinfo.Mount, driverName string) Check {
+	const name = "stale mounts"
+	podsDir := path.Join(f.Config.KubeletDir, "pods")
+
+	var stale []string
+	for _, m := range mounts {
+		if !strings.Contains(m.MountPoint, "/volumes/"+driverName+"/") {
+			continue
+		}
+		if strings.HasSuffix(m.Root, deletedRootMark) {
+			stale = append(stale, m.MountPoint)
+			continue
+		}
+		podUID := path.Base(strings.SplitN(m.MountPoint, "/volumes/", 2)[0])
+		if _, err := os.Stat(path.Join(podsDir, podUID)); os.IsNotExist(err) {
+			stale = append(stale, m.MountPoint)
+		}
+	}
+	if len(stale) != 0 {
+		return failed(name, "%d mounts of deleted pods or dirs: %s", len(stale), strings.Join(stale, ", "))
+	}
+	return passed(name, "none")
+}
+
+// checkPropagation requires the kubelet dir to be on a shared mount, otherwise the bind mounts of the
+// driver do not reach a containerized kubelet and the pods see an empty dir.
+func (f *FlexVolumeDriver) checkPropagation(mounts []mountinfo.Mount) Check {
+	const name = "mount propagation"
+	kubeletDir := f.Config.KubeletDir
+
+	var covering *mountinfo.Mount
+	for i, m := range mounts {
+		if (kubeletDir == m.MountPoint || strings.HasPrefix(kubeletDir, strings.TrimSuffix(m.MountPoint, "/")+"/")) &&
+			(covering == nil || len(m.MountPoint) >= len(covering.MountPoint)) {
+			covering = &mounts[i]
+		}
+	}
+	if covering == nil {
+		return failed(name, "no mount covers %s", kubeletDir)
+	}
+	if !covering.Shared() {
+		return failed(name, "%s is on mount %s which is not shared, run mount --make-rshared %s", kubeletDir, covering.MountPoint, covering.MountPoint)
+	}
+	return passed(name, "%s is on shared mount %s", kubeletDir, covering.MountPoint)
+}
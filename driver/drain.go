@@ -0,0 +1,248 @@
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/rancher/log-aggregator/posfile"
+	"github.com/rancher/log-aggregator/volume"
+)
+
+const (
+	drainCmd    = "drain"
+	svcDrainDir = "/var/lib/rancher/log-aggregator/drain"
+	// corruptExt keeps an invalid drain request out of the queue for inspection
+	corruptExt = ".corrupt"
+)
+
+type drainRequest struct {
+	IdentifyName string    `json:"identifyName"`
+	Deadline     time.Time `json:"deadline"`
+}
+
+// Drainer removes the artifacts of unmounted volumes once fluentd has read
+// every file of the volume, or once the deadline of the request passed.
+type Drainer struct {
+	Logger   *logrus.Entry
+	Interval time.Duration
+}
+
+// Run blocks until the given volumes, or all queued volumes when none are given, are cleaned up.
+func (d *Drainer) Run(identifyNames []string) error {
+	for {
+		remaining, err := d.drainPending(identifyNames)
+		if err != nil {
+			return err
+		}
+		if remaining == 0 {
+			return nil
+		}
+		time.Sleep(d.Interval)
+	}
+}
+
+// DrainPending makes a single pass over the drain queue and returns the number of volumes still waiting.
+func (d *Drainer) DrainPending() (int, error) {
+	return d.drainPending(nil)
+}
+
+func (d *Drainer) drainPending(identifyNames []string) (int, error) {
+	requests, err := d.listDrainRequests()
+	if err != nil {
+		return 0, err
+	}
+
+	var remaining int
+	for _, req := range requests {
+		if len(identifyNames) != 0 && !isContain(req.IdentifyName, identifyNames) {
+			continue
+		}
+
+		drained, untailed, err := isDrained(path.Join(svcLogBaseDir, req.IdentifyName))
+		if err != nil {
+			d.Logger.Warnf("check drain status of %s failed, %v", req.IdentifyName, err)
+		}
+
+		if !drained && time.Now().Before(req.Deadline) {
+			remaining++
+			continue
+		}
+
+		if !drained {
+			d.Logger.Warnf("drain deadline of %s passed, unread logs will be dropped", req.IdentifyName)
+		}
+		if len(untailed) != 0 {
+			d.Logger.Warnf("files %v of %s match no tail glob and were never shipped, they will be dropped", untailed, req.IdentifyName)
+		}
+
+		if err = cleanup(req.IdentifyName); err != nil {
+			d.Logger.Errorf("clean up %s failed, %v", req.IdentifyName, err)
+			remaining++
+			continue
+		}
+
+		if err = cancelDrain(req.IdentifyName); err != nil {
+			return remaining, err
+		}
+		d.Logger.Infof("volume %s drained and cleaned up", req.IdentifyName)
+	}
+	return remaining, nil
+}
+
+func queueDrain(identifyName string, timeout time.Duration) error {
+	if err := os.MkdirAll(svcDrainDir, os.ModePerm); err != nil {
+		return fmt.Errorf("create drain dir %s failed, %v", svcDrainDir, err)
+	}
+
+	b, err := json.Marshal(drainRequest{
+		IdentifyName: identifyName,
+		Deadline:     time.Now().Add(timeout),
+	})
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(drainRequestPath(identifyName), b)
+}
+
+func cancelDrain(identifyName string) error {
+	if err := os.Remove(drainRequestPath(identifyName)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "remove drain request of %s failed", identifyName)
+	}
+	return nil
+}
+
+// listDrainRequests moves invalid requests aside, one of them must not hold up the other volumes.
+func (d *Drainer) listDrainRequests() ([]drainRequest, error) {
+	files, err := filepath.Glob(path.Join(svcDrainDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var requests []drainRequest
+	for _, v := range files {
+		b, err := ioutil.ReadFile(v)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		var req drainRequest
+		if err = json.Unmarshal(b, &req); err == nil && (req.IdentifyName == "" || path.Base(req.IdentifyName) != req.IdentifyName) {
+			err = fmt.Errorf("invalid identify name %q", req.IdentifyName)
+		}
+		if err != nil {
+			d.Logger.Errorf("skip drain request %s, moved it to %s, %v", v, v+corruptExt, err)
+			if err := os.Rename(v, v+corruptExt); err != nil {
+				d.Logger.Warnf("move drain request %s aside failed, %v", v, err)
+			}
+			continue
+		}
+		requests = append(requests, req)
+	}
+	return requests, nil
+}
+
+func drainRequestPath(identifyName string) string {
+	return path.Join(svcDrainDir, identifyName+".json")
+}
+
+// spawnDrainer starts a detached drainer, kubelet waits on our stdout so the child must not inherit it.
+func spawnDrainer(identifyName string, correlationID interface{}) error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(self, drainCmd, fmt.Sprintf("--correlation-id=%v", correlationID), identifyName)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+// isDrained also returns the unread files which no tailer tracks and whose name has no dot, so the
+// *.* glob of fluentd never reads them. They do not hold up the drain.
+func isDrained(volumeDir string) (bool, []string, error) {
+	idx, err := posfile.ParseDir(svcLogPosDir)
+	if err != nil {
+		return false, nil, err
+	}
+
+	lag, err := volume.ComputeLag(volumeDir, idx)
+	if err != nil {
+		return false, nil, err
+	}
+
+	drained := true
+	var untailed []string
+	for _, v := range lag.Files {
+		if v.UnreadBytes == 0 {
+			continue
+		}
+		if !v.Tracked && !strings.Contains(path.Base(v.Path), ".") {
+			untailed = append(untailed, v.Path)
+			continue
+		}
+		drained = false
+	}
+	return drained, untailed, nil
+}
+
+func cleanup(identifyName string) error {
+	configFiles, mountPoint, posFiles := cleanupPaths(identifyName)
+	if err := removeFiles(configFiles); err != nil {
+		return fmt.Errorf("remove custom config files %v failed, %v", configFiles, err)
+	}
+
+	if err := unmountTmpfs(path.Join(svcLogBaseDir, identifyName)); err != nil {
+		return err
+	}
+
+	if err := removeFiles(mountPoint); err != nil {
+		return fmt.Errorf("remove custom mount point %v failed, %v", mountPoint, err)
+	}
+
+	if err := removeFiles(posFiles); err != nil {
+		return fmt.Errorf("remove custom pos files %v failed, %v", posFiles, err)
+	}
+	return nil
+}
+
+func cleanupPaths(identifyName string) ([]string, []string, []string) {
+	return configFilePaths(identifyName), []string{path.Join(svcLogBaseDir, identifyName)}, posFilePaths(identifyName)
+}
+
+func posFilePaths(identifyName string) []string {
+	return append(customPosFilePaths(identifyName), nativePosFilePath(identifyName))
+}
+
+// customPosFilePaths are the pos files of the generated fluentd configs.
+func customPosFilePaths(identifyName string) []string {
+	return []string{
+		path.Join(svcLogPosDir, fmt.Sprintf("%s%s.pos", clusterPosFilePrefix, identifyName)),
+		path.Join(svcLogPosDir, fmt.Sprintf("%s%s.pos", projectPosFilePrefix, identifyName)),
+	}
+}
+
+func writeFileAtomic(file string, data []byte) error {
+	tmp := path.Join(path.Dir(file), "."+path.Base(file)+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return errors.Wrapf(err, "write %s failed", tmp)
+	}
+	return os.Rename(tmp, file)
+}
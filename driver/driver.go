@@ -10,13 +10,18 @@ import (
 	"os/exec"
 	"path"
 	"strings"
+	"time"
 
 	valid "github.com/asaskevich/govalidator"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 
+	"github.com/rancher/log-aggregator/config"
 	"github.com/rancher/log-aggregator/generator"
+	"github.com/rancher/log-aggregator/logging"
+	"github.com/rancher/log-aggregator/parser"
+	"github.com/rancher/log-aggregator/volume"
 )
 
 const (
@@ -36,7 +41,7 @@ const (
 )
 
 var (
-	predefineFormat = []string{"json", "apache2", "nginx", "rfc3164", "rfc5424", "none"}
+	predefineFormat = parser.Predefined
 	customiseFormat = "customise"
 )
 
@@ -56,10 +61,54 @@ type Options struct {
 	VolumeName    string `json:"volumeName,omitempty" valid:"required"`
 	PodName       string `json:"kubernetes.io/pod.name,omitempty" valid:"required"`
 	PodUID        string `json:"kubernetes.io/pod.uid,omitempty" valid:"required"`
+	// PodNamespace is set by the kubelet, unlike Namespace it is there when the options are invalid
+	PodNamespace string `json:"kubernetes.io/pod.namespace,omitempty"`
+
+	// parse section parameters, see validateParseOptions for the free form ones
+	TimeKey     string `json:"timeKey,omitempty"`
+	TimeFormat  string `json:"timeFormat,omitempty"`
+	Timezone    string `json:"timezone,omitempty" valid:"optional,matches(^([+-][0-9]{2}:?[0-9]{2}|[A-Za-z_]+(/[A-Za-z0-9_+-]+)*)$)"`
+	KeepTimeKey string `json:"keepTimeKey,omitempty" valid:"optional,in(true|false)"`
+	Types       string `json:"types,omitempty"`
+
+	// Mode is file, the default, syslog or forward where the daemon serves syslog or fluentd forward
+	// sockets in the host dir, or fifo where it reads the named pipes listed in Fifos
+	Mode  string `json:"mode,omitempty" valid:"optional,in(file|syslog|forward|fifo)"`
+	Fifos string `json:"fifos,omitempty"`
+
+	// Medium Memory puts the volume on a tmpfs of SizeLimit bytes, a size like 64Mi
+	Medium    string `json:"medium,omitempty" valid:"optional,in(Memory)"`
+	SizeLimit string `json:"sizeLimit,omitempty"`
+
+	// tail parameters
+	ReadFromHead    string `json:"readFromHead,omitempty" valid:"optional,in(true|false)"`
+	RotateWait      string `json:"rotateWait,omitempty" valid:"optional,matches(^[0-9]+[smhd]?$)"`
+	RefreshInterval string `json:"refreshInterval,omitempty" valid:"optional,matches(^[0-9]+[smhd]?$)"`
+	FromEncoding    string `json:"fromEncoding,omitempty" valid:"optional,matches(^[A-Za-z0-9_-]+$)"`
+	Encoding        string `json:"encoding,omitempty" valid:"optional,matches(^[A-Za-z0-9_-]+$)"`
+}
+
+// customised reports whether the volume gets its own fluentd source, the main fluentd config only
+// tails the predefined formats with default parameters. Fluentd never reads fifo volumes.
+func (o Options) customised() bool {
+	if o.Mode == ModeFifo {
+		return false
+	}
+	if !isContain(o.Format, predefineFormat) {
+		return true
+	}
+	params := []string{o.TimeKey, o.TimeFormat, o.Timezone, o.KeepTimeKey, o.Types, o.ReadFromHead, o.RotateWait, o.RefreshInterval, o.FromEncoding, o.Encoding}
+	for _, v := range params {
+		if v != "" {
+			return true
+		}
+	}
+	return false
 }
 
 type FlexVolumeDriver struct {
-	Logger *logrus.Logger
+	Logger *logrus.Entry
+	Config *config.Config
 }
 
 func (f *FlexVolumeDriver) Init() InitResponse {
@@ -107,55 +156,87 @@ func (f *FlexVolumeDriver) Init() InitResponse {
 }
 
 func (f *FlexVolumeDriver) Mount(args []string) CommonResponse {
-	var err error
-	defer func(logger *logrus.Logger) {
+	var (
+		err  error
+		opts Options
+	)
+	start := time.Now()
+	logger := f.Logger
+	defer func() {
+		logCall(logger, start, err)
+		recordCall(logger, mountVerb, start, err)
 		if err != nil {
-			logger.Error(err)
+			reportMountFailure(logger, opts, err)
 		}
-	}(f.Logger)
+	}()
 	// param check
-	f.Logger.Debugf("mount args: %v", args)
-	if err = checkArgsLen(args, 2); err != nil {
+	logger.Debugf("mount args: %v", args)
+	var containerPath string
+	containerPath, opts, err = parseMountArgs(args)
+	if opts.PodUID != "" {
+		logger = logger.WithFields(logrus.Fields{
+			logging.FieldPodUID:     opts.PodUID,
+			logging.FieldVolumeName: opts.VolumeName,
+		})
+	}
+	if err != nil {
 		return returnErrorResponse(err)
 	}
 
-	containerPath := args[0]
-	opts := Options{}
-	if err = json.Unmarshal([]byte(args[1]), &opts); err != nil {
+	//generate config
+	if err = precreateDir(); err != nil {
+		err = withReason(ReasonCreateDir, err)
 		return returnErrorResponse(err)
 	}
 
-	if _, err = valid.ValidateStruct(opts); err != nil {
+	identifyDir, hostDir := mountDirs(opts)
+	if err = cancelDrain(identifyDir); err != nil {
+		err = withReason(ReasonCreateDir, err)
 		return returnErrorResponse(err)
 	}
-	formatOption(&opts)
 
-	//generate config
-	if err := precreateDir(); err != nil {
+	if opts.customised() {
+		if err = generateCustomiseConfig(hostDir, opts); err != nil {
+			err = withReason(ReasonGenerateConfig, err)
+			return returnErrorResponse(err)
+		}
+	}
+
+	if err = os.MkdirAll(hostDir, os.ModePerm); err != nil {
+		err = withReason(ReasonCreateDir, fmt.Errorf("create hostPath failed, %v", err))
 		return returnErrorResponse(err)
 	}
 
-	fn := []string{opts.ClusterID, opts.ClusterName, opts.Namespace, opts.ProjectID, opts.ProjectName, opts.WorkloadName, opts.PodName, opts.ContainerName}
-	generateDir := strings.Join(fn, "_")
-	identifyDir := fmt.Sprintf("%s_%s", opts.PodUID, opts.VolumeName)
+	if opts.Medium == MediumMemory {
+		var mounted bool
+		if mounted, err = f.mountTmpfs(hostDir, opts); err != nil {
+			return returnErrorResponse(err)
+		}
+		// a tmpfs left behind by a failed mount would count against the memory budget
+		defer func() {
+			if err != nil && mounted {
+				if unmountErr := unMount(hostDir); unmountErr != nil {
+					logger.Warnf("unmount tmpfs %s after the failed mount failed, %v", hostDir, unmountErr)
+				}
+			}
+		}()
+	}
 
-	var hostDir string
-	if isContain(opts.Format, predefineFormat) {
-		hostDir = path.Join(svcLogBaseDir, identifyDir, opts.Format, generateDir)
-	} else {
-		hostDir = path.Join(svcLogBaseDir, identifyDir, customiseFormat, generateDir)
-		if err = generateCustomiseConfig(hostDir, opts); err != nil {
-			f.Logger.Error(err)
-			returnErrorResponse(err)
+	if opts.Mode == ModeFifo {
+		if err = createFifos(hostDir, fifoNames(opts.Fifos)); err != nil {
+			err = withReason(ReasonCreateDir, err)
+			return returnErrorResponse(err)
 		}
 	}
 
-	if err = os.MkdirAll(hostDir, os.ModePerm); err != nil {
-		return returnErrorResponse(fmt.Errorf("create hostPath failed, %v", err))
+	if err = writeMetadata(path.Join(svcLogBaseDir, identifyDir), opts); err != nil {
+		err = withReason(ReasonCreateDir, err)
+		return returnErrorResponse(err)
 	}
 
 	if err = bindMount(hostDir, containerPath); err != nil {
-		return returnErrorResponse(fmt.Errorf("bind mount failed, %v", err))
+		err = withReason(ReasonBindMount, fmt.Errorf("bind mount failed, %v", err))
+		return returnErrorResponse(err)
 	}
 
 	return CommonResponse{
@@ -164,48 +245,81 @@ func (f *FlexVolumeDriver) Mount(args []string) CommonResponse {
 	}
 }
 
+// parseMountArgs returns the options even when they are invalid, so the caller can still log the pod.
+func parseMountArgs(args []string) (string, Options, error) {
+	opts := Options{}
+	if err := checkArgsLen(args, 2); err != nil {
+		return "", opts, withReason(ReasonInvalidArgs, err)
+	}
+
+	if err := json.Unmarshal([]byte(args[1]), &opts); err != nil {
+		return "", opts, withReason(ReasonInvalidOptions, err)
+	}
+
+	if _, err := valid.ValidateStruct(opts); err != nil {
+		return "", opts, withReason(ReasonInvalidOptions, err)
+	}
+
+	if err := validateFormat(opts.Format); err != nil {
+		return "", opts, withReason(ReasonInvalidFormat, err)
+	}
+
+	if err := validateParseOptions(opts); err != nil {
+		return "", opts, withReason(ReasonInvalidOptions, err)
+	}
+
+	if err := validateMode(opts); err != nil {
+		return "", opts, withReason(ReasonInvalidOptions, err)
+	}
+
+	if err := validateMedium(opts); err != nil {
+		return "", opts, withReason(ReasonInvalidOptions, err)
+	}
+	return args[0], opts, nil
+}
+
 func (f *FlexVolumeDriver) Unmount(args []string) CommonResponse {
 	var err error
-	defer func(logger *logrus.Logger) {
-		if err != nil {
-			logger.Error(err)
-		}
-	}(f.Logger)
-
-	f.Logger.Debugf("ummount args: %v", args)
+	start := time.Now()
+	logger := f.Logger
+	defer func() {
+		logCall(logger, start, err)
+		recordCall(logger, unmountVerb, start, err)
+	}()
+
+	logger.Debugf("ummount args: %v", args)
 	if err = checkArgsLen(args, 1); err != nil {
+		err = withReason(ReasonInvalidArgs, err)
 		return returnErrorResponse(err)
 	}
 
 	containerPath := args[0]
 	if err = unMount(containerPath); err != nil {
-		return returnErrorResponse(fmt.Errorf("unmount container path %s failed, %v", containerPath, err))
-	}
-	strArray := strings.Split(containerPath, "/")
-	var podUID string
-	for i, v := range strArray { //example v: /var/lib/kubelet/pods/be6a7bc3-b278-11e8-973b-08002749a29c/volumes/cattle.io~log-aggregator/vol1
-		if v == "pods" {
-			podUID = strArray[i+1]
-			break
-		}
+		err = withReason(ReasonUnmount, fmt.Errorf("unmount container path %s failed, %v", containerPath, err))
+		return returnErrorResponse(err)
 	}
-	// clean up
-	volumeName := strArray[len(strArray)-1]
-	identifyName := fmt.Sprintf("%s_%s", podUID, volumeName)
-
-	configFiles := []string{fmt.Sprintf("%s/%s.conf", svcClusterLogConfigDir, identifyName), fmt.Sprintf("%s/%s.conf", svcProjectLogConfigDir, identifyName)}
-	if err = removeFiles(configFiles); err != nil {
-		returnErrorResponse(fmt.Errorf("remove custom config files %v failed", configFiles))
+	identifyName := identifyNameFromPath(containerPath)
+	if podUID, volumeName, parseErr := volume.ParseIdentifyName(identifyName); parseErr == nil {
+		logger = logger.WithFields(logrus.Fields{
+			logging.FieldPodUID:     podUID,
+			logging.FieldVolumeName: volumeName,
+		})
 	}
 
-	mountPoint := []string{path.Join(svcLogBaseDir, identifyName)}
-	if err = removeFiles(mountPoint); err != nil {
-		returnErrorResponse(fmt.Errorf("remove custom mount point %v failed", mountPoint))
+	// hand the volume to the drainer, so fluentd can ship what is left before we remove it
+	if err = queueDrain(identifyName, f.Config.DrainTimeout.Duration); err == nil {
+		err = spawnDrainer(identifyName, logger.Data[logging.FieldCorrelationID])
 	}
-
-	posFiles := []string{fmt.Sprintf("%s/%s%s.pos", svcLogPosDir, clusterPosFilePrefix, identifyName), fmt.Sprintf("%s/%s%s.pos", svcLogPosDir, projectPosFilePrefix, identifyName)}
-	if err = removeFiles(posFiles); err != nil {
-		returnErrorResponse(fmt.Errorf("remove custom pos files %v failed", posFiles))
+	if err != nil {
+		logger.Errorf("hand over %s to drainer failed, clean up immediately, %v", identifyName, err)
+		if err = cleanup(identifyName); err != nil {
+			err = withReason(ReasonCleanup, err)
+			return returnErrorResponse(err)
+		}
+		if err = cancelDrain(identifyName); err != nil {
+			err = withReason(ReasonCleanup, err)
+			return returnErrorResponse(err)
+		}
 	}
 
 	return CommonResponse{
@@ -214,10 +328,27 @@ func (f *FlexVolumeDriver) Unmount(args []string) CommonResponse {
 	}
 }
 
+func identifyNameFromPath(containerPath string) string {
+	strArray := strings.Split(containerPath, "/")
+	var podUID string
+	for i, v := range strArray { //example v: /var/lib/kubelet/pods/be6a7bc3-b278-11e8-973b-08002749a29c/volumes/cattle.io~log-aggregator/vol1
+		if v == "pods" && i+1 < len(strArray) {
+			podUID = strArray[i+1]
+			break
+		}
+	}
+	volumeName := strArray[len(strArray)-1]
+	return fmt.Sprintf("%s_%s", podUID, volumeName)
+}
+
+func bindMountCmd(hostPath string, containerPath string) []string {
+	c := append([]string{mountCmd}, mountCmdArg...)
+	return append(c, hostPath, containerPath)
+}
+
 func bindMount(hostPath string, containerPath string) error {
-	c := append(mountCmdArg, hostPath)
-	c = append(c, containerPath)
-	cmd := exec.Command(mountCmd, c...)
+	c := bindMountCmd(hostPath, containerPath)
+	cmd := exec.Command(c[0], c[1:]...)
 	if output, err := cmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("run bind mount command failed, hostPath: %s, containerPath: %s, error: %v, output: %s", hostPath, containerPath, err, string(output))
 	}
@@ -227,7 +358,7 @@ func bindMount(hostPath string, containerPath string) error {
 func unMount(containerPath string) error {
 	cmd := exec.Command(unmountCmd, containerPath)
 	if output, err := cmd.CombinedOutput(); err != nil && !(strings.Contains(string(output), "not mounted") || strings.Contains(string(output), "mountpoint not found")) {
-		return fmt.Errorf(string(output))
+		return errors.New(string(output))
 	}
 	return nil
 }
@@ -240,6 +371,18 @@ func checkArgsLen(args cli.Args, expectedNum int) error {
 	return nil
 }
 
+func logCall(logger *logrus.Entry, start time.Time, err error) {
+	entry := logger.WithField(logging.FieldDuration, time.Since(start).String())
+	if err != nil {
+		entry.WithFields(logrus.Fields{
+			logging.FieldOutcome: "failure",
+			logging.FieldReason:  reasonOf(err),
+		}).Error(err)
+		return
+	}
+	entry.WithField(logging.FieldOutcome, "success").Info("call finished")
+}
+
 func returnErrorResponse(err error) CommonResponse {
 	return CommonResponse{
 		Status:  StatusFailure,
@@ -271,7 +414,7 @@ func copyFileContent(fromPath, toPath string) error {
 	}
 	defer from.Close()
 
-	to, err := os.OpenFile(toPath, os.O_RDWR|os.O_CREATE, 0666)
+	to, err := os.OpenFile(toPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
 		return errors.Wrap(err, "fail to open current config file")
 	}
@@ -287,25 +430,15 @@ func copyFileContent(fromPath, toPath string) error {
 	return nil
 }
 
-func precreateDir() error {
-	if err := os.MkdirAll(tmpClusterDir, os.ModePerm); err != nil {
-		return fmt.Errorf("create tmp dir %s failed, %v", tmpClusterDir, err)
-	}
-
-	if err := os.MkdirAll(tmpProjectDir, os.ModePerm); err != nil {
-		return fmt.Errorf("create tmp dir %s failed, %v", tmpProjectDir, err)
-	}
-
-	if err := os.MkdirAll(svcProjectLogConfigDir, os.ModePerm); err != nil {
-		return fmt.Errorf("create config dir %s failed, %v", svcProjectLogConfigDir, err)
-	}
-
-	if err := os.MkdirAll(svcClusterLogConfigDir, os.ModePerm); err != nil {
-		return fmt.Errorf("create config dir %s failed, %v", svcClusterLogConfigDir, err)
-	}
+func precreateDirs() []string {
+	return []string{tmpClusterDir, tmpProjectDir, svcProjectLogConfigDir, svcClusterLogConfigDir, svcLogBaseDir}
+}
 
-	if err := os.MkdirAll(svcLogBaseDir, os.ModePerm); err != nil {
-		return fmt.Errorf("create log dir %s failed, %v", svcLogBaseDir, err)
+func precreateDir() error {
+	for _, v := range precreateDirs() {
+		if err := os.MkdirAll(v, os.ModePerm); err != nil {
+			return fmt.Errorf("create dir %s failed, %v", v, err)
+		}
 	}
 	return nil
 }
@@ -315,14 +448,16 @@ func removeFiles(files []string) error {
 		fileInfo, err := os.Stat(v)
 		if err != nil {
 			if os.IsNotExist(err) {
-				return nil
+				continue
 			}
 			return err
 		}
 
 		if fileInfo.IsDir() {
-			err := os.RemoveAll(v)
-			return errors.Wrapf(err, "remove dir %s failed", v)
+			if err := os.RemoveAll(v); err != nil {
+				return errors.Wrapf(err, "remove dir %s failed", v)
+			}
+			continue
 		}
 
 		if err := os.Remove(v); err != nil {
@@ -346,12 +481,7 @@ func generateCustomiseConfig(hostDir string, opts Options) error {
 	configFileName := fmt.Sprintf("%s_%s.conf", opts.PodUID, opts.VolumeName)
 	outputProjectPath := path.Join(svcProjectLogConfigDir, configFileName)
 	outputClusterPath := path.Join(svcClusterLogConfigDir, configFileName)
-	conf := map[string]interface{}{
-		"Format":         opts.Format,
-		"Path":           fmt.Sprintf("%s/*.*", hostDir),
-		"ClusterPosPath": fmt.Sprintf("/fluentd/log/%s%s_%s.pos", clusterPosFilePrefix, opts.PodUID, opts.VolumeName),
-		"ProjectPosPath": fmt.Sprintf("/fluentd/log/%s%s_%s.pos", projectPosFilePrefix, opts.PodUID, opts.VolumeName),
-	}
+	conf := customiseConfigValues(hostDir, opts)
 
 	tmpClusterConfigFile := path.Join(tmpClusterDir, configFileName)
 	if err = generator.GenerateConfigFile(tmpClusterConfigFile, generator.ClusterSourceTemplate, "cluster", conf); err != nil {
@@ -378,6 +508,48 @@ func generateCustomiseConfig(hostDir string, opts Options) error {
 	return removeFiles([]string{tmpClusterConfigFile, tmpProjectConfigFile})
 }
 
-func formatOption(opts *Options) {
-	opts.ProjectName = strings.Replace(opts.ProjectName, "_", "~", -1)
+func customiseConfigValues(hostDir string, opts Options) map[string]interface{} {
+	parseType, expression, messageFormat := parseSection(opts.Format)
+	return map[string]interface{}{
+		"Path":            fmt.Sprintf("%s/*.*", hostDir),
+		"ClusterPosPath":  fmt.Sprintf("/fluentd/log/%s%s_%s.pos", clusterPosFilePrefix, opts.PodUID, opts.VolumeName),
+		"ProjectPosPath":  fmt.Sprintf("/fluentd/log/%s%s_%s.pos", projectPosFilePrefix, opts.PodUID, opts.VolumeName),
+		"ReadFromHead":    opts.ReadFromHead,
+		"RotateWait":      opts.RotateWait,
+		"RefreshInterval": opts.RefreshInterval,
+		"FromEncoding":    opts.FromEncoding,
+		"Encoding":        opts.Encoding,
+		"ParseType":       parseType,
+		"Expression":      expression,
+		"MessageFormat":   messageFormat,
+		"TimeKey":         opts.TimeKey,
+		"TimeFormat":      opts.TimeFormat,
+		"Timezone":        opts.Timezone,
+		"KeepTimeKey":     opts.KeepTimeKey,
+		"Types":           opts.Types,
+	}
+}
+
+// parseSection translates the legacy format parameter into the parser type of a v1 parse section.
+func parseSection(format string) (string, string, string) {
+	switch {
+	case format == "rfc3164" || format == "rfc5424":
+		return "syslog", "", format
+	case parser.IsRegexp(format):
+		return "regexp", format, ""
+	default:
+		return format, "", ""
+	}
+}
+
+func writeMetadata(volumeDir string, opts Options) error {
+	b, err := metadataContent(opts)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path.Join(volumeDir, volume.MetadataFile), b)
+}
+
+func metadataContent(opts Options) ([]byte, error) {
+	return json.Marshal(opts)
 }
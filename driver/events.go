@@ -0,0 +1,63 @@
+package driver
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/rancher/log-aggregator/events"
+)
+
+const (
+	svcEventsDir = "/var/lib/rancher/log-aggregator/events"
+	maxSpooled   = 1000
+)
+
+const (
+	EventInvalidLogFormat        = "InvalidLogFormat"
+	EventInvalidLogVolumeOptions = "InvalidLogVolumeOptions"
+	EventLogVolumeMountFailed    = "LogVolumeMountFailed"
+	EventMemoryBudgetExceeded    = "LogVolumeMemoryBudgetExceeded"
+	EventDiskBudgetExceeded      = "LogVolumeDiskBudgetExceeded"
+)
+
+func EventSpool() *events.Spool {
+	return &events.Spool{Dir: svcEventsDir, MaxEvents: maxSpooled}
+}
+
+func eventReason(err error) string {
+	switch reasonOf(err) {
+	case ReasonInvalidFormat:
+		return EventInvalidLogFormat
+	case ReasonInvalidOptions:
+		return EventInvalidLogVolumeOptions
+	case ReasonMemoryBudget:
+		return EventMemoryBudgetExceeded
+	default:
+		return EventLogVolumeMountFailed
+	}
+}
+
+// reportMountFailure leaves a pod event for the daemon to post, the kubelet only shows a generic message.
+func reportMountFailure(logger *logrus.Entry, opts Options, err error) {
+	namespace := opts.PodNamespace
+	if namespace == "" {
+		namespace = opts.Namespace
+	}
+	if opts.PodName == "" || namespace == "" {
+		return
+	}
+	e := events.Event{
+		Namespace: namespace,
+		PodName:   opts.PodName,
+		PodUID:    opts.PodUID,
+		Type:      events.TypeWarning,
+		Reason:    eventReason(err),
+		Message:   fmt.Sprintf("mount log volume %s failed: %v", opts.VolumeName, err),
+		Time:      time.Now(),
+	}
+	if err := EventSpool().Write(e); err != nil {
+		logger.Warnf("spool event for pod %s/%s failed, %v", namespace, opts.PodName, err)
+	}
+}
@@ -0,0 +1,65 @@
+package driver
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func mountArgs(t *testing.T, override map[string]string) []string {
+	opts := map[string]string{
+		"clusterName":                 "local",
+		"clusterID":                   "c-1",
+		"projectName":                 "Default",
+		"projectID":                   "p-1",
+		"namespace":                   "default",
+		"workloadName":                "nginx",
+		"containerName":               "nginx",
+		"format":                      "json",
+		"volumeName":                  "vol1",
+		"kubernetes.io/pod.name":      "nginx-0",
+		"kubernetes.io/pod.namespace": "default",
+		"kubernetes.io/pod.uid":       "uid-1",
+	}
+	for k, v := range override {
+		if v == "" {
+			delete(opts, k)
+			continue
+		}
+		opts[k] = v
+	}
+	b, err := json.Marshal(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []string{"/var/lib/kubelet/pods/uid-1/volumes/cattle.io~log-aggregator/vol1", string(b)}
+}
+
+func TestMountFailureEventReason(t *testing.T) {
+	cases := []struct {
+		override map[string]string
+		reason   string
+	}{
+		{map[string]string{"format": "/unclosed(/"}, EventInvalidLogFormat},
+		{map[string]string{"namespace": ""}, EventInvalidLogVolumeOptions},
+		{map[string]string{"mode": "tcp"}, EventInvalidLogVolumeOptions},
+		{map[string]string{"medium": "Memory"}, EventInvalidLogVolumeOptions},
+	}
+	for _, v := range cases {
+		_, opts, err := parseMountArgs(mountArgs(t, v.override))
+		if err == nil {
+			t.Errorf("options with %v passed validation", v.override)
+			continue
+		}
+		if got := eventReason(err); got != v.reason {
+			t.Errorf("options with %v got event reason %s, expected %s, %v", v.override, got, v.reason, err)
+		}
+		// the event still reaches the pod when the user supplied namespace is missing
+		if opts.PodName == "" || opts.PodNamespace == "" {
+			t.Errorf("options with %v lost the pod, %+v", v.override, opts)
+		}
+	}
+
+	if _, _, err := parseMountArgs(mountArgs(t, nil)); err != nil {
+		t.Errorf("valid options failed, %v", err)
+	}
+}
@@ -0,0 +1,40 @@
+package driver
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/rancher/log-aggregator/volume"
+)
+
+// CollectGarbage hands the volumes of pods the kubelet no longer knows about to the drainer,
+// they are left behind when the kubelet never called unmount, for example after a node crash.
+func (f *FlexVolumeDriver) CollectGarbage() (int, error) {
+	podsDir := path.Join(f.Config.KubeletDir, "pods")
+	if _, err := os.Stat(podsDir); err != nil {
+		return 0, fmt.Errorf("kubelet pods dir %s not accessible, skip gc, %v", podsDir, err)
+	}
+
+	volumes, err := volume.List(svcLogBaseDir)
+	if err != nil {
+		return 0, err
+	}
+
+	var collected int
+	for _, v := range volumes {
+		if _, err := os.Stat(path.Join(podsDir, v.PodUID)); err == nil || !os.IsNotExist(err) {
+			continue
+		}
+		if _, err := os.Stat(drainRequestPath(v.IdentifyName())); err == nil {
+			continue
+		}
+
+		f.Logger.Infof("pod %s of volume %s is gone, hand it to the drainer", v.PodUID, v.IdentifyName())
+		if err := queueDrain(v.IdentifyName(), f.Config.DrainTimeout.Duration); err != nil {
+			return collected, err
+		}
+		collected++
+	}
+	return collected, nil
+}
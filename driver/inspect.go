@@ -0,0 +1,114 @@
+package driver
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/rancher/log-aggregator/mountinfo"
+	"github.com/rancher/log-aggregator/posfile"
+	"github.com/rancher/log-aggregator/volume"
+)
+
+type VolumeInfo struct {
+	volume.Volume
+	Mounted     bool         `json:"mounted"`
+	MountPoints []string     `json:"mountPoints,omitempty"`
+	Draining    bool         `json:"draining"`
+	ConfigFiles []string     `json:"configFiles,omitempty"`
+	PosFiles    []string     `json:"posFiles,omitempty"`
+	Usage       volume.Usage `json:"usage"`
+	Lag         volume.Lag   `json:"lag"`
+}
+
+func ListVolumes() ([]VolumeInfo, error) {
+	volumes, err := volume.List(svcLogBaseDir)
+	if err != nil {
+		return nil, fmt.Errorf("list volumes in %s failed, %v", svcLogBaseDir, err)
+	}
+	return describeVolumes(volumes)
+}
+
+func InspectVolume(podUID, volumeName string) (VolumeInfo, error) {
+	v, err := volume.Get(svcLogBaseDir, volume.IdentifyName(podUID, volumeName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return VolumeInfo{}, fmt.Errorf("volume %s of pod %s not found", volumeName, podUID)
+		}
+		return VolumeInfo{}, err
+	}
+
+	infos, err := describeVolumes([]volume.Volume{v})
+	if err != nil {
+		return VolumeInfo{}, err
+	}
+	return infos[0], nil
+}
+
+func describeVolumes(volumes []volume.Volume) ([]VolumeInfo, error) {
+	idx, err := posfile.ParseDir(svcLogPosDir)
+	if err != nil {
+		return nil, fmt.Errorf("parse pos files in %s failed, %v", svcLogPosDir, err)
+	}
+
+	mounts, err := mountinfo.ParseFile(mountinfo.SelfPath)
+	if err != nil {
+		return nil, fmt.Errorf("read mount table failed, %v", err)
+	}
+
+	var result []VolumeInfo
+	for _, v := range volumes {
+		if v.Metadata == nil {
+			v.Metadata = decodeHostDir(v.HostDir)
+		}
+
+		info := VolumeInfo{
+			Volume:      v,
+			MountPoints: volumeMountPoints(v, mounts),
+			ConfigFiles: existingFiles(configFilePaths(v.IdentifyName())),
+			PosFiles:    volumePosFiles(v, idx),
+		}
+		info.Mounted = len(info.MountPoints) != 0
+		if _, err := os.Stat(drainRequestPath(v.IdentifyName())); err == nil {
+			info.Draining = true
+		}
+
+		if info.Usage, err = volume.DiskUsage(v.Dir); err != nil {
+			return nil, fmt.Errorf("compute disk usage of %s failed, %v", v.IdentifyName(), err)
+		}
+		if info.Lag, err = volume.ComputeLag(v.Dir, idx); err != nil {
+			return nil, fmt.Errorf("compute lag of %s failed, %v", v.IdentifyName(), err)
+		}
+		result = append(result, info)
+	}
+	return result, nil
+}
+
+// volumeMountPoints finds the kubelet mount points, <kubeletDir>/pods/<podUID>/volumes/<driver>/<volumeName>.
+func volumeMountPoints(v volume.Volume, mounts []mountinfo.Mount) []string {
+	var result []string
+	for _, m := range mounts {
+		if strings.Contains(m.MountPoint, "/pods/"+v.PodUID+"/volumes/") && path.Base(m.MountPoint) == v.VolumeName {
+			result = append(result, m.MountPoint)
+		}
+	}
+	return result
+}
+
+func configFilePaths(identifyName string) []string {
+	return []string{
+		path.Join(svcClusterLogConfigDir, identifyName+".conf"),
+		path.Join(svcProjectLogConfigDir, identifyName+".conf"),
+	}
+}
+
+func existingFiles(files []string) []string {
+	var result []string
+	for _, v := range files {
+		if _, err := os.Stat(v); err == nil {
+			result = append(result, v)
+		}
+	}
+	return result
+}
@@ -0,0 +1,87 @@
+package driver
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rancher/log-aggregator/metrics"
+	"github.com/rancher/log-aggregator/posfile"
+	"github.com/rancher/log-aggregator/volume"
+)
+
+type VolumeLag struct {
+	volume.Volume
+	volume.Lag
+	PosFiles []string `json:"posFiles,omitempty"`
+}
+
+// ListLags reports how far the tailers are behind for every volume on the node.
+func ListLags() ([]VolumeLag, error) {
+	volumes, err := volume.List(svcLogBaseDir)
+	if err != nil {
+		return nil, fmt.Errorf("list volumes in %s failed, %v", svcLogBaseDir, err)
+	}
+
+	idx, err := posfile.ParseDir(svcLogPosDir)
+	if err != nil {
+		return nil, fmt.Errorf("parse pos files in %s failed, %v", svcLogPosDir, err)
+	}
+
+	var result []VolumeLag
+	for _, v := range volumes {
+		lag, err := volume.ComputeLag(v.Dir, idx)
+		if err != nil {
+			return nil, fmt.Errorf("compute lag of %s failed, %v", v.IdentifyName(), err)
+		}
+		result = append(result, VolumeLag{
+			Volume:   v,
+			Lag:      lag,
+			PosFiles: volumePosFiles(v, idx),
+		})
+	}
+	return result, nil
+}
+
+// volumePosFiles returns the pos files referencing the volume, plus the custom format ones named after it.
+func volumePosFiles(v volume.Volume, idx posfile.Index) []string {
+	files := idx.PosFiles(v.Dir)
+	for _, f := range existingFiles(posFilePaths(v.IdentifyName())) {
+		if !isContain(f, files) {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+func LagMetrics(lags []VolumeLag, now time.Time) []metrics.Family {
+	unreadBytes := metrics.Family{
+		Name: "log_aggregator_volume_unread_bytes",
+		Help: "Bytes written to the log volume not yet read by fluentd.",
+		Type: metrics.TypeGauge,
+	}
+	unreadIdle := metrics.Family{
+		Name: "log_aggregator_volume_unread_idle_seconds",
+		Help: "Seconds since the least recently written file of the log volume with unread data was written.",
+		Type: metrics.TypeGauge,
+	}
+	for _, v := range lags {
+		labels := VolumeLabels(v.Volume)
+		unreadBytes.Metrics = append(unreadBytes.Metrics, metrics.Metric{Labels: labels, Value: float64(v.UnreadBytes)})
+		unreadIdle.Metrics = append(unreadIdle.Metrics, metrics.Metric{Labels: labels, Value: v.UnreadIdle(now).Seconds()})
+	}
+	return []metrics.Family{unreadBytes, unreadIdle}
+}
+
+// VolumeLabels labels the volume metrics with the identity stored at mount time.
+func VolumeLabels(v volume.Volume) map[string]string {
+	return map[string]string{
+		"pod_uid":   v.PodUID,
+		"volume":    v.VolumeName,
+		"format":    v.Format,
+		"cluster":   v.Metadata["clusterName"],
+		"project":   v.Metadata["projectName"],
+		"namespace": v.Metadata["namespace"],
+		"workload":  v.Metadata["workloadName"],
+		"container": v.Metadata["containerName"],
+	}
+}
@@ -0,0 +1,137 @@
+package driver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/rancher/log-aggregator/identity"
+	"github.com/rancher/log-aggregator/volume"
+)
+
+// hostDirHashLen is used for the host dir name when the encoded identity exceeds NAME_MAX,
+// the full identity is in metadata.json either way.
+const hostDirHashLen = 16
+
+// migratedMetadataKey marks metadata MigrateVolumes decoded from a legacy host dir name, it holds the
+// name. Such metadata lacks the parse options and the real format of a custom format volume.
+const migratedMetadataKey = "migratedFrom"
+
+// legacyHostDirFields is the order of the identity fields in the host dir name, earlier versions
+// joined them unescaped and only replaced "_" in ProjectName with "~".
+var legacyHostDirFields = []string{"clusterID", "clusterName", "namespace", "projectID", "projectName", "workloadName", "kubernetes.io/pod.name", "containerName"}
+
+func identityOf(opts Options) identity.Identity {
+	return identity.Identity{
+		ClusterID:     opts.ClusterID,
+		ClusterName:   opts.ClusterName,
+		Namespace:     opts.Namespace,
+		ProjectID:     opts.ProjectID,
+		ProjectName:   opts.ProjectName,
+		WorkloadName:  opts.WorkloadName,
+		PodName:       opts.PodName,
+		ContainerName: opts.ContainerName,
+	}
+}
+
+// hostDirName is the reversible identity segment downstream filters decode, or a hash when that is too long.
+func hostDirName(opts Options) string {
+	segment := identity.Encode(identityOf(opts))
+	if len(segment) <= identity.MaxSegmentLength {
+		return segment
+	}
+
+	sum := sha256.Sum256([]byte(segment))
+	return hex.EncodeToString(sum[:])[:hostDirHashLen]
+}
+
+// formatDirName keeps fifo volumes out of the format dirs the main fluentd config tails, fluentd would
+// block on opening the pipes.
+func formatDirName(opts Options) string {
+	if opts.Mode == ModeFifo {
+		return ModeFifo
+	}
+	if opts.customised() {
+		return customiseFormat
+	}
+	return opts.Format
+}
+
+// mountDirs returns the identify dir name and the host dir, a host dir created by an earlier
+// version for the same pod volume is reused so a remount keeps writing where fluentd reads.
+func mountDirs(opts Options) (string, string) {
+	identifyDir := volume.IdentifyName(opts.PodUID, opts.VolumeName)
+	formatDir := path.Join(svcLogBaseDir, identifyDir, formatDirName(opts))
+	if existing, err := volume.FirstSubDir(formatDir); err == nil && existing != "" {
+		return identifyDir, path.Join(formatDir, existing)
+	}
+	return identifyDir, path.Join(formatDir, hostDirName(opts))
+}
+
+// decodeHostDir recovers the metadata of a volume without metadata.json from its host dir name. Every
+// version encoding names with identity.Encode writes metadata.json, so only the legacy rules apply.
+func decodeHostDir(hostDir string) map[string]string {
+	if hostDir == "" {
+		return nil
+	}
+
+	i, err := identity.DecodeLegacy(path.Base(hostDir))
+	if err != nil {
+		return nil
+	}
+	values := []string{i.ClusterID, i.ClusterName, i.Namespace, i.ProjectID, i.ProjectName, i.WorkloadName, i.PodName, i.ContainerName}
+
+	result := make(map[string]string, len(values)+1)
+	for n, k := range legacyHostDirFields {
+		result[k] = values[n]
+	}
+	result["format"] = path.Base(path.Dir(hostDir))
+	result[migratedMetadataKey] = path.Base(hostDir)
+	return result
+}
+
+// migratedMetadata reports whether the metadata can not reproduce the options of the mount, it was
+// migrated or names the customise dir instead of a format.
+func migratedMetadata(metadata map[string]string) bool {
+	return metadata[migratedMetadataKey] != "" || metadata["format"] == customiseFormat
+}
+
+// MigrateVolumes writes the metadata.json sidecar for volumes created before it existed, decoded from
+// their host dir name. The host dirs keep their names until the pods are gone, renaming them
+// would make fluentd read every file again.
+func MigrateVolumes(logger *logrus.Entry) (int, error) {
+	volumes, err := volume.List(svcLogBaseDir)
+	if err != nil {
+		return 0, err
+	}
+
+	var migrated int
+	for _, v := range volumes {
+		if v.Metadata != nil {
+			continue
+		}
+
+		metadata := decodeHostDir(v.HostDir)
+		if metadata == nil {
+			logger.Warnf("volume %s has neither metadata nor a decodable host dir name, skip migration", v.IdentifyName())
+			continue
+		}
+		metadata["kubernetes.io/pod.uid"] = v.PodUID
+		metadata["volumeName"] = v.VolumeName
+
+		b, err := json.Marshal(metadata)
+		if err != nil {
+			return migrated, err
+		}
+		if err := writeFileAtomic(path.Join(v.Dir, volume.MetadataFile), b); err != nil {
+			return migrated, fmt.Errorf("write metadata of %s failed, %v", v.IdentifyName(), err)
+		}
+		logger.Infof("migrated volume %s, metadata decoded from %s", v.IdentifyName(), path.Base(v.HostDir))
+		migrated++
+	}
+	return migrated, nil
+}
@@ -0,0 +1,82 @@
+package driver
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/rancher/log-aggregator/volume"
+)
+
+const (
+	ModeFile    = "file"
+	ModeSyslog  = "syslog"
+	ModeForward = "forward"
+	ModeFifo    = "fifo"
+
+	// SyslogFile receives the messages of a syslog mode volume, fluentd and the tailer read it like any other file
+	SyslogFile = "syslog.log"
+	// ForwardFile receives the events of a forward mode volume like SyslogFile
+	ForwardFile = "forward.log"
+	// DefaultFifo is the pipe of a fifo mode volume without the fifos option, without a dot like all pipes
+	// so no *.* tail glob opens it
+	DefaultFifo = "log"
+)
+
+var fifosRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+(,[A-Za-z0-9_-]+)*$`)
+
+// VolumeMode returns the mode the volume was mounted with, volumes of earlier versions are file volumes.
+func VolumeMode(v volume.Volume) string {
+	if mode := v.Metadata["mode"]; mode != "" {
+		return mode
+	}
+	return ModeFile
+}
+
+func SyslogFilePath(v volume.Volume) string {
+	return path.Join(v.HostDir, SyslogFile)
+}
+
+func ForwardFilePath(v volume.Volume) string {
+	return path.Join(v.HostDir, ForwardFile)
+}
+
+// FifoNames returns the pipes of a fifo mode volume.
+func FifoNames(v volume.Volume) []string {
+	return fifoNames(v.Metadata["fifos"])
+}
+
+func fifoNames(fifos string) []string {
+	if fifos == "" {
+		return []string{DefaultFifo}
+	}
+	return strings.Split(fifos, ",")
+}
+
+// createFifos creates the missing pipes writable for every user of the volume, a remount keeps the
+// existing ones and what is still in their buffers.
+func createFifos(dir string, names []string) error {
+	for _, name := range names {
+		p := path.Join(dir, name)
+		if isFifo(p) {
+			continue
+		}
+		if err := unix.Mkfifo(p, 0666); err != nil {
+			return fmt.Errorf("create fifo %s failed, %v", p, err)
+		}
+		// mkfifo applies the umask
+		if err := os.Chmod(p, 0666); err != nil {
+			return fmt.Errorf("chmod fifo %s failed, %v", p, err)
+		}
+	}
+	return nil
+}
+
+func isFifo(p string) bool {
+	info, err := os.Lstat(p)
+	return err == nil && info.Mode()&os.ModeNamedPipe != 0
+}
@@ -0,0 +1,124 @@
+package driver
+
+import (
+	"os"
+	"path"
+	"time"
+
+	"github.com/rancher/log-aggregator/config"
+	"github.com/rancher/log-aggregator/volume"
+)
+
+type PlannedFile struct {
+	Path    string `json:"path"`
+	Content string `json:"content"`
+}
+
+type PlannedDrain struct {
+	Request  string    `json:"request"`
+	Deadline time.Time `json:"deadline"`
+}
+
+// Plan lists what a mount or unmount call would do with the same args, computing it changes nothing.
+type Plan struct {
+	Verb          string        `json:"verb"`
+	ContainerPath string        `json:"containerPath"`
+	Options       *Options      `json:"options,omitempty"`
+	HostDir       string        `json:"hostDir,omitempty"`
+	CreateDirs    []string      `json:"createDirs,omitempty"`
+	CreateFifos   []string      `json:"createFifos,omitempty"`
+	WriteFiles    []PlannedFile `json:"writeFiles,omitempty"`
+	PosFiles      []string      `json:"posFiles,omitempty"`
+	Commands      [][]string    `json:"commands,omitempty"`
+	CancelDrain   string        `json:"cancelDrain,omitempty"`
+	Drain         *PlannedDrain `json:"drain,omitempty"`
+	RemoveFiles   []string      `json:"removeFiles,omitempty"`
+}
+
+func (f *FlexVolumeDriver) PlanMount(args []string) (*Plan, error) {
+	containerPath, opts, err := parseMountArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	identifyDir, hostDir := mountDirs(opts)
+	plan := &Plan{
+		Verb:          mountVerb,
+		ContainerPath: containerPath,
+		Options:       &opts,
+		HostDir:       hostDir,
+		CreateDirs:    missingDirs(append(precreateDirs(), hostDir)),
+		Commands:      [][]string{bindMountCmd(hostDir, containerPath)},
+	}
+	if opts.Medium == MediumMemory {
+		if mounted, err := tmpfsMounted(hostDir); err != nil || !mounted {
+			size, _ := config.ParseSize(opts.SizeLimit)
+			plan.Commands = append([][]string{tmpfsMountCmd(hostDir, int64(size))}, plan.Commands...)
+		}
+	}
+	if opts.Mode == ModeFifo {
+		for _, name := range fifoNames(opts.Fifos) {
+			if p := path.Join(hostDir, name); !isFifo(p) {
+				plan.CreateFifos = append(plan.CreateFifos, p)
+			}
+		}
+	}
+	if _, err := os.Stat(drainRequestPath(identifyDir)); err == nil {
+		plan.CancelDrain = drainRequestPath(identifyDir)
+	}
+
+	if opts.customised() {
+		clusterConfig, projectConfig, err := renderConfigs(hostDir, opts)
+		if err != nil {
+			return nil, withReason(ReasonGenerateConfig, err)
+		}
+
+		configFiles := configFilePaths(identifyDir)
+		plan.WriteFiles = append(plan.WriteFiles,
+			PlannedFile{Path: configFiles[0], Content: string(clusterConfig)},
+			PlannedFile{Path: configFiles[1], Content: string(projectConfig)},
+		)
+		plan.PosFiles = customPosFilePaths(identifyDir)
+	}
+
+	metadata, err := metadataContent(opts)
+	if err != nil {
+		return nil, err
+	}
+	plan.WriteFiles = append(plan.WriteFiles, PlannedFile{
+		Path:    path.Join(svcLogBaseDir, identifyDir, volume.MetadataFile),
+		Content: string(metadata),
+	})
+	return plan, nil
+}
+
+func (f *FlexVolumeDriver) PlanUnmount(args []string) (*Plan, error) {
+	if err := checkArgsLen(args, 1); err != nil {
+		return nil, withReason(ReasonInvalidArgs, err)
+	}
+
+	containerPath := args[0]
+	identifyName := identifyNameFromPath(containerPath)
+	configFiles, mountPoint, posFiles := cleanupPaths(identifyName)
+	return &Plan{
+		Verb:          unmountVerb,
+		ContainerPath: containerPath,
+		Commands:      [][]string{{unmountCmd, containerPath}},
+		Drain: &PlannedDrain{
+			Request:  drainRequestPath(identifyName),
+			Deadline: time.Now().Add(f.Config.DrainTimeout.Duration),
+		},
+		PosFiles:    posFiles,
+		RemoveFiles: existingFiles(append(append(configFiles, mountPoint...), posFiles...)),
+	}, nil
+}
+
+func missingDirs(dirs []string) []string {
+	var result []string
+	for _, v := range dirs {
+		if _, err := os.Stat(v); os.IsNotExist(err) {
+			result = append(result, v)
+		}
+	}
+	return result
+}
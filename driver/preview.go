@@ -0,0 +1,111 @@
+package driver
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/rancher/log-aggregator/parser"
+)
+
+// previewPlaceholder fills the identity fields preview does not ask for, mount requires them.
+const previewPlaceholder = "preview"
+
+type PreviewLine struct {
+	Number int                    `json:"number"`
+	Text   string                 `json:"text"`
+	Time   *time.Time             `json:"time,omitempty"`
+	Record map[string]interface{} `json:"record,omitempty"`
+	Error  string                 `json:"error,omitempty"`
+}
+
+type Preview struct {
+	Options *Options `json:"options"`
+	// ClusterConfig and ProjectConfig are what mount would publish, empty for the predefined formats
+	// the main fluentd config tails
+	ClusterConfig string `json:"clusterConfig,omitempty"`
+	ProjectConfig string `json:"projectConfig,omitempty"`
+	// ParseError is set when the format is a fluentd parser type the parser package does not implement
+	ParseError string        `json:"parseError,omitempty"`
+	Lines      []PreviewLine `json:"lines,omitempty"`
+}
+
+// Failed counts the lines that did not parse.
+func (p *Preview) Failed() int {
+	var n int
+	for _, v := range p.Lines {
+		if v.Error != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// PreviewFormat validates the format and parse options the way mount does, then parses every line of
+// samples. The identity fields of opts may be left empty.
+func PreviewFormat(opts Options, samples io.Reader) (*Preview, error) {
+	for _, v := range []*string{&opts.ClusterName, &opts.ClusterID, &opts.ProjectName, &opts.ProjectID, &opts.Namespace,
+		&opts.WorkloadName, &opts.ContainerName, &opts.VolumeName, &opts.PodName, &opts.PodUID} {
+		if *v == "" {
+			*v = previewPlaceholder
+		}
+	}
+	args, err := json.Marshal(opts)
+	if err != nil {
+		return nil, err
+	}
+	if _, opts, err = parseMountArgs([]string{previewPlaceholder, string(args)}); err != nil {
+		return nil, err
+	}
+
+	preview := &Preview{Options: &opts}
+	if opts.customised() {
+		_, hostDir := mountDirs(opts)
+		clusterConfig, projectConfig, err := renderConfigs(hostDir, opts)
+		if err != nil {
+			return nil, withReason(ReasonGenerateConfig, err)
+		}
+		preview.ClusterConfig, preview.ProjectConfig = string(clusterConfig), string(projectConfig)
+	}
+
+	p, err := NewParser(opts)
+	if err != nil {
+		preview.ParseError = err.Error()
+		return preview, nil
+	}
+
+	scanner := bufio.NewScanner(samples)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for n := 1; scanner.Scan(); n++ {
+		line := PreviewLine{Number: n, Text: strings.TrimSuffix(scanner.Text(), "\r")}
+		record, err := p.Parse(line.Text)
+		if err != nil {
+			line.Error = err.Error()
+		} else {
+			line.Time, line.Record = &record.Time, record.Fields
+		}
+		preview.Lines = append(preview.Lines, line)
+	}
+	return preview, scanner.Err()
+}
+
+// NewParser returns the parser of a volume, configured like the parse section mount generates.
+func NewParser(opts Options) (parser.Parser, error) {
+	loc, err := parser.ParseTimezone(opts.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	types, err := parser.ParseTypes(opts.Types)
+	if err != nil {
+		return nil, err
+	}
+	return parser.New(opts.Format, parser.Options{
+		TimeKey:     opts.TimeKey,
+		TimeFormat:  opts.TimeFormat,
+		Location:    loc,
+		KeepTimeKey: opts.KeepTimeKey == "true",
+		Types:       types,
+	})
+}
@@ -0,0 +1,120 @@
+package driver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/rancher/log-aggregator/generator"
+	"github.com/rancher/log-aggregator/mountinfo"
+	"github.com/rancher/log-aggregator/volume"
+)
+
+type Regenerated struct {
+	Volume string `json:"volume"`
+	// Changed are the configs whose content differs from the published one
+	Changed []string `json:"changed,omitempty"`
+	// Skipped explains why a mounted volume was left alone
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// Regenerate renders the configs of all mounted volumes from their metadata again and publishes the
+// changed ones, unless dryRun is set. Configs of a driver upgrade otherwise only reach new mounts.
+func (f *FlexVolumeDriver) Regenerate(dryRun bool) ([]Regenerated, error) {
+	volumes, err := volume.List(svcLogBaseDir)
+	if err != nil {
+		return nil, err
+	}
+	mounts, err := mountinfo.ParseFile(mountinfo.SelfPath)
+	if err != nil {
+		return nil, fmt.Errorf("read mount table failed, %v", err)
+	}
+
+	var result []Regenerated
+	for _, v := range volumes {
+		if len(volumeMountPoints(v, mounts)) == 0 {
+			continue
+		}
+		r, err := f.regenerateVolume(v, dryRun)
+		if err != nil {
+			return result, fmt.Errorf("regenerate configs of %s failed, %v", v.IdentifyName(), err)
+		}
+		result = append(result, r)
+	}
+	return result, nil
+}
+
+func (f *FlexVolumeDriver) regenerateVolume(v volume.Volume, dryRun bool) (Regenerated, error) {
+	r := Regenerated{Volume: v.IdentifyName()}
+	if v.Metadata == nil {
+		r.Skipped = "no metadata.json, it predates stored options"
+		return r, nil
+	}
+	if v.HostDir == "" {
+		r.Skipped = "no host dir"
+		return r, nil
+	}
+	if migratedMetadata(v.Metadata) {
+		r.Skipped = "metadata migrated from the host dir name, it lacks the format options"
+		f.Logger.Warnf("skip regenerating the configs of %s, its metadata was migrated from the host dir name and lacks the format options", v.IdentifyName())
+		return r, nil
+	}
+
+	opts, err := optionsFromMetadata(v.Metadata)
+	if err != nil {
+		return r, err
+	}
+	if opts.Mode == ModeFifo {
+		r.Skipped = "fifo mode, read by the daemon"
+		return r, nil
+	}
+	if !opts.customised() {
+		r.Skipped = "predefined format, tailed by the main fluentd config"
+		return r, nil
+	}
+
+	clusterConfig, projectConfig, err := renderConfigs(v.HostDir, opts)
+	if err != nil {
+		return r, err
+	}
+
+	configFiles := configFilePaths(v.IdentifyName())
+	for i, content := range [][]byte{clusterConfig, projectConfig} {
+		published, err := ioutil.ReadFile(configFiles[i])
+		if err == nil && bytes.Equal(published, content) {
+			continue
+		}
+		r.Changed = append(r.Changed, configFiles[i])
+		if dryRun {
+			continue
+		}
+		if err := writeFileAtomic(configFiles[i], content); err != nil {
+			return r, err
+		}
+		f.Logger.Infof("regenerated %s with template v%d", configFiles[i], generator.TemplateVersion)
+	}
+	return r, nil
+}
+
+func renderConfigs(hostDir string, opts Options) ([]byte, []byte, error) {
+	conf := customiseConfigValues(hostDir, opts)
+	clusterConfig, err := generator.RenderConfig(generator.ClusterSourceTemplate, "cluster", conf)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generate cluster config file failed, %v", err)
+	}
+	projectConfig, err := generator.RenderConfig(generator.ProjectSourceTemplate, "project", conf)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generate project config file failed, %v", err)
+	}
+	return clusterConfig, projectConfig, nil
+}
+
+func optionsFromMetadata(metadata map[string]string) (Options, error) {
+	var opts Options
+	b, err := json.Marshal(metadata)
+	if err != nil {
+		return opts, err
+	}
+	return opts, json.Unmarshal(b, &opts)
+}
@@ -0,0 +1,60 @@
+package driver
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/rancher/log-aggregator/volume"
+)
+
+func testDriver() *FlexVolumeDriver {
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	return &FlexVolumeDriver{Logger: logrus.NewEntry(logger)}
+}
+
+// migratedVolume is a custom format volume of an earlier version after MigrateVolumes.
+func migratedVolume(t *testing.T) volume.Volume {
+	identifyName := volume.IdentifyName("uid-migrated", "vol1")
+	dir := path.Join(svcLogBaseDir, identifyName)
+	hostDir := path.Join(dir, customiseFormat, "c-1_local_default_p-1_my~project_nginx_nginx-0_nginx")
+	metadata := decodeHostDir(hostDir)
+	if metadata == nil {
+		t.Fatalf("legacy host dir %s was not decoded", hostDir)
+	}
+	return volume.Volume{Dir: dir, PodUID: "uid-migrated", VolumeName: "vol1", Format: customiseFormat, HostDir: hostDir, Metadata: metadata}
+}
+
+func TestRegenerateSkipsMigratedVolumes(t *testing.T) {
+	v := migratedVolume(t)
+	configFiles := configFilePaths(v.IdentifyName())
+	for _, file := range configFiles {
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Skipf("config %s of the test volume exists, %v", file, err)
+		}
+	}
+
+	r, err := testDriver().regenerateVolume(v, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Skipped == "" || len(r.Changed) != 0 {
+		t.Errorf("migrated volume was regenerated, %+v", r)
+	}
+	for _, file := range configFiles {
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			os.Remove(file)
+			t.Errorf("config %s was written for a migrated volume", file)
+		}
+	}
+
+	// metadata migrated by earlier versions has no marker but names the customise dir as format
+	delete(v.Metadata, migratedMetadataKey)
+	if r, err := testDriver().regenerateVolume(v, true); err != nil || r.Skipped == "" {
+		t.Errorf("unmarked migrated volume was not skipped, %+v, %v", r, err)
+	}
+}
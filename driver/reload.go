@@ -0,0 +1,69 @@
+package driver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const procDir = "/proc"
+
+// ReloadFluentd sends SIGUSR2 to the fluentd supervisors, they reread the config and restart their
+// workers gracefully. The daemon sees the fluentd processes of the node as it runs with hostPID.
+func ReloadFluentd() ([]int, error) {
+	pids, err := fluentdSupervisors()
+	if err != nil {
+		return nil, err
+	}
+	if len(pids) == 0 {
+		return nil, fmt.Errorf("no fluentd process found in %s", procDir)
+	}
+
+	for _, pid := range pids {
+		if err := syscall.Kill(pid, syscall.SIGUSR2); err != nil {
+			return nil, fmt.Errorf("signal fluentd %d failed, %v", pid, err)
+		}
+	}
+	return pids, nil
+}
+
+func fluentdSupervisors() ([]int, error) {
+	cmdlines, err := filepath.Glob(path.Join(procDir, "*", "cmdline"))
+	if err != nil {
+		return nil, err
+	}
+
+	var result []int
+	for _, v := range cmdlines {
+		pid, err := strconv.Atoi(path.Base(path.Dir(v)))
+		if err != nil {
+			continue
+		}
+		b, err := ioutil.ReadFile(v)
+		if err != nil || len(b) == 0 {
+			continue
+		}
+		if isFluentdSupervisor(strings.Split(strings.TrimRight(string(b), "\x00"), "\x00")) {
+			result = append(result, pid)
+		}
+	}
+	return result, nil
+}
+
+// isFluentdSupervisor matches fluentd run directly or through ruby, the workers carry --under-supervisor.
+func isFluentdSupervisor(args []string) bool {
+	var fluentd bool
+	for i, v := range args {
+		if v == "--under-supervisor" {
+			return false
+		}
+		if i < 2 && path.Base(v) == "fluentd" {
+			fluentd = true
+		}
+	}
+	return fluentd
+}
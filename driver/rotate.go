@@ -0,0 +1,94 @@
+package driver
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/rancher/log-aggregator/posfile"
+	"github.com/rancher/log-aggregator/volume"
+)
+
+// rotatedDirName is hidden, so neither the tail globs nor the lag computation pick up rotated files.
+const rotatedDirName = ".rotated"
+
+// RotateFiles copies and truncates the files above the rotate size which fluentd already read entirely,
+// fluentd notices the truncation and starts over at offset zero.
+func (f *FlexVolumeDriver) RotateFiles() error {
+	if f.Config.RotateSize <= 0 {
+		return nil
+	}
+
+	volumes, err := volume.List(svcLogBaseDir)
+	if err != nil {
+		return err
+	}
+
+	idx, err := posfile.ParseDir(svcLogPosDir)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range volumes {
+		if v.HostDir == "" {
+			continue
+		}
+
+		lag, err := volume.ComputeLag(v.HostDir, idx)
+		if err != nil {
+			return err
+		}
+
+		for _, file := range lag.Files {
+			if file.Size < int64(f.Config.RotateSize) || !file.Tracked || file.UnreadBytes != 0 {
+				continue
+			}
+			if err := rotateFile(file.Path, f.Config.RotateKeep); err != nil {
+				return err
+			}
+			f.Logger.Infof("rotated %s at %d bytes", file.Path, file.Size)
+		}
+	}
+	return nil
+}
+
+func rotateFile(file string, keep int) error {
+	if keep > 0 {
+		dir := path.Join(path.Dir(file), rotatedDirName)
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return errors.Wrapf(err, "create rotate dir %s failed", dir)
+		}
+
+		rotated := path.Join(dir, fmt.Sprintf("%s.%s", path.Base(file), time.Now().UTC().Format("20060102T150405")))
+		if err := copyFileContent(file, rotated); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Truncate(file, 0); err != nil {
+		return errors.Wrapf(err, "truncate %s failed", file)
+	}
+	return pruneRotated(file, keep)
+}
+
+func pruneRotated(file string, keep int) error {
+	rotated, err := filepath.Glob(path.Join(path.Dir(file), rotatedDirName, path.Base(file)+".*"))
+	if err != nil {
+		return err
+	}
+
+	// the timestamp suffix sorts chronologically
+	sort.Strings(rotated)
+	for len(rotated) > keep {
+		if err := os.Remove(rotated[0]); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "remove rotated file %s failed", rotated[0])
+		}
+		rotated = rotated[1:]
+	}
+	return nil
+}
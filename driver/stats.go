@@ -0,0 +1,181 @@
+package driver
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/rancher/log-aggregator/metrics"
+)
+
+const (
+	mountVerb   = "mount"
+	unmountVerb = "unmount"
+
+	svcStatsFile = "/var/lib/rancher/log-aggregator/stats.json"
+)
+
+const (
+	ReasonInvalidArgs    = "InvalidArgs"
+	ReasonInvalidOptions = "InvalidOptions"
+	ReasonInvalidFormat  = "InvalidFormat"
+	ReasonCreateDir      = "CreateDirFailed"
+	ReasonGenerateConfig = "GenerateConfigFailed"
+	ReasonBindMount      = "BindMountFailed"
+	ReasonUnmount        = "UnmountFailed"
+	ReasonCleanup        = "CleanupFailed"
+	ReasonMountTmpfs     = "MountTmpfsFailed"
+	ReasonMemoryBudget   = "MemoryBudgetExceeded"
+	ReasonUnknown        = "Unknown"
+)
+
+var durationBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type reasonError struct {
+	reason string
+	err    error
+}
+
+func (e *reasonError) Error() string {
+	return e.err.Error()
+}
+
+func withReason(reason string, err error) error {
+	return &reasonError{reason: reason, err: err}
+}
+
+func reasonOf(err error) string {
+	if e, ok := err.(*reasonError); ok {
+		return e.reason
+	}
+	return ReasonUnknown
+}
+
+// Stats accumulates the driver calls across the short lived kubelet invocations, the daemon exposes it.
+type Stats struct {
+	Calls     map[string]uint64             `json:"calls"`
+	Failures  map[string]map[string]uint64  `json:"failures"`
+	Durations map[string]*metrics.Histogram `json:"durations"`
+}
+
+func newStats() *Stats {
+	return &Stats{
+		Calls:     map[string]uint64{},
+		Failures:  map[string]map[string]uint64{},
+		Durations: map[string]*metrics.Histogram{},
+	}
+}
+
+func (s *Stats) observe(verb string, duration time.Duration, err error) {
+	s.Calls[verb]++
+	if err != nil {
+		if s.Failures[verb] == nil {
+			s.Failures[verb] = map[string]uint64{}
+		}
+		s.Failures[verb][reasonOf(err)]++
+	}
+	if s.Durations[verb] == nil {
+		s.Durations[verb] = metrics.NewHistogram(durationBuckets)
+	}
+	s.Durations[verb].Observe(duration.Seconds())
+}
+
+func (s *Stats) Metrics() []metrics.Family {
+	calls := metrics.Family{
+		Name: "log_aggregator_driver_calls_total",
+		Help: "Driver calls made by the kubelet.",
+		Type: metrics.TypeCounter,
+	}
+	failures := metrics.Family{
+		Name: "log_aggregator_driver_failures_total",
+		Help: "Failed driver calls by reason.",
+		Type: metrics.TypeCounter,
+	}
+	durations := metrics.Family{
+		Name: "log_aggregator_driver_call_duration_seconds",
+		Help: "Duration of the driver calls.",
+		Type: metrics.TypeHistogram,
+	}
+
+	for verb, v := range s.Calls {
+		calls.Metrics = append(calls.Metrics, metrics.Metric{Labels: map[string]string{"verb": verb}, Value: float64(v)})
+	}
+	for verb, reasons := range s.Failures {
+		for reason, v := range reasons {
+			failures.Metrics = append(failures.Metrics, metrics.Metric{Labels: map[string]string{"verb": verb, "reason": reason}, Value: float64(v)})
+		}
+	}
+	for verb, v := range s.Durations {
+		durations.Metrics = append(durations.Metrics, metrics.Metric{Labels: map[string]string{"verb": verb}, Histogram: v})
+	}
+	return []metrics.Family{calls, failures, durations}
+}
+
+func LoadStats() (*Stats, error) {
+	stats := newStats()
+	b, err := ioutil.ReadFile(svcStatsFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return stats, nil
+		}
+		return nil, err
+	}
+	if err = json.Unmarshal(b, stats); err != nil {
+		return nil, errors.Wrapf(err, "parse stats file %s failed", svcStatsFile)
+	}
+	return stats, nil
+}
+
+// recordCall adds one call to the stats file, concurrent invocations are serialized with flock.
+func recordCall(logger *logrus.Entry, verb string, start time.Time, callErr error) {
+	if err := updateStats(func(s *Stats) {
+		s.observe(verb, time.Since(start), callErr)
+	}); err != nil {
+		logger.Warnf("record %s call failed, %v", verb, err)
+	}
+}
+
+func updateStats(update func(*Stats)) error {
+	if err := os.MkdirAll(path.Dir(svcStatsFile), os.ModePerm); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(svcStatsFile, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		return errors.Wrapf(err, "lock stats file %s failed", svcStatsFile)
+	}
+	defer syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+
+	b, err := ioutil.ReadAll(file)
+	if err != nil {
+		return err
+	}
+
+	stats := newStats()
+	if len(b) != 0 {
+		if err = json.Unmarshal(b, stats); err != nil {
+			stats = newStats()
+		}
+	}
+	update(stats)
+
+	if b, err = json.Marshal(stats); err != nil {
+		return err
+	}
+	if err = file.Truncate(0); err != nil {
+		return err
+	}
+	_, err = file.WriteAt(b, 0)
+	return err
+}
@@ -0,0 +1,51 @@
+package driver
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/rancher/log-aggregator/volume"
+)
+
+// nativePosFilePrefix names the checkpoints of the in process tailer, they sit next to the fluentd
+// pos files so lag, drain and rotation account for both.
+const nativePosFilePrefix = "native_"
+
+type TailSource struct {
+	Volume  volume.Volume
+	PosFile string
+}
+
+// TailSources returns every volume with a host dir, a volume is followed from the mount that creates
+// its host dir until the drainer removes it after the unmount.
+func TailSources() ([]TailSource, error) {
+	volumes, err := volume.List(svcLogBaseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []TailSource
+	for _, v := range volumes {
+		if v.HostDir == "" {
+			continue
+		}
+		if v.Metadata == nil {
+			v.Metadata = decodeHostDir(v.HostDir)
+		}
+		result = append(result, TailSource{Volume: v, PosFile: nativePosFilePath(v.IdentifyName())})
+	}
+	return result, nil
+}
+
+func nativePosFilePath(identifyName string) string {
+	return path.Join(svcLogPosDir, fmt.Sprintf("%s%s.pos", nativePosFilePrefix, identifyName))
+}
+
+// RecordFields describes where a tailed line came from, the volume labels plus the ids they leave out.
+func RecordFields(v volume.Volume) map[string]string {
+	fields := VolumeLabels(v)
+	fields["cluster_id"] = v.Metadata["clusterID"]
+	fields["project_id"] = v.Metadata["projectID"]
+	fields["pod_name"] = v.Metadata["kubernetes.io/pod.name"]
+	return fields
+}
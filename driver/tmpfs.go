@@ -0,0 +1,147 @@
+package driver
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+
+	"github.com/rancher/log-aggregator/config"
+	"github.com/rancher/log-aggregator/mountinfo"
+)
+
+const (
+	// MediumMemory puts the host dir on a tmpfs of the volume sizeLimit, like emptyDir medium Memory
+	MediumMemory = "Memory"
+
+	svcTmpfsLockFile = "/var/lib/rancher/log-aggregator/tmpfs.lock"
+)
+
+// validateMedium requires the size of a memory volume, the tmpfs is its hard limit.
+func validateMedium(opts Options) error {
+	if opts.Medium != MediumMemory {
+		if opts.SizeLimit != "" {
+			return fmt.Errorf("sizeLimit requires medium %s", MediumMemory)
+		}
+		return nil
+	}
+	if opts.SizeLimit == "" {
+		return fmt.Errorf("medium %s requires sizeLimit", MediumMemory)
+	}
+	size, err := config.ParseSize(opts.SizeLimit)
+	if err != nil {
+		return err
+	}
+	if size == 0 {
+		return fmt.Errorf("sizeLimit must not be 0")
+	}
+	return nil
+}
+
+func tmpfsMountCmd(hostDir string, size int64) []string {
+	return []string{mountCmd, "-t", "tmpfs", "-o", fmt.Sprintf("size=%d,mode=0755", size), "tmpfs", hostDir}
+}
+
+// mountTmpfs mounts the tmpfs at the host dir unless a remount finds it there, it returns whether it
+// mounted one. Mounts are serialized with flock, so concurrent mounts can not exceed the memory budget
+// together. A memory budget of 0 is unbounded.
+func (f *FlexVolumeDriver) mountTmpfs(hostDir string, opts Options) (bool, error) {
+	size, err := config.ParseSize(opts.SizeLimit)
+	if err != nil {
+		return false, withReason(ReasonInvalidOptions, err)
+	}
+
+	if err := os.MkdirAll(path.Dir(svcTmpfsLockFile), os.ModePerm); err != nil {
+		return false, withReason(ReasonMountTmpfs, err)
+	}
+	lock, err := os.OpenFile(svcTmpfsLockFile, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return false, withReason(ReasonMountTmpfs, err)
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return false, withReason(ReasonMountTmpfs, errors.Wrapf(err, "lock %s failed", svcTmpfsLockFile))
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	mounts, err := tmpfsMounts(svcLogBaseDir)
+	if err != nil {
+		return false, withReason(ReasonMountTmpfs, err)
+	}
+	var used int64
+	for _, m := range mounts {
+		if m.MountPoint == hostDir {
+			return false, nil
+		}
+		n, err := tmpfsSize(m.MountPoint)
+		if err != nil {
+			return false, withReason(ReasonMountTmpfs, err)
+		}
+		used += n
+	}
+
+	if budget := int64(f.Config.MemoryBudget); budget > 0 && used+int64(size) > budget {
+		return false, withReason(ReasonMemoryBudget, fmt.Errorf("sizeLimit %s exceeds the memory budget of the node, %d of %d bytes are used by other memory volumes", opts.SizeLimit, used, budget))
+	}
+
+	c := tmpfsMountCmd(hostDir, int64(size))
+	if output, err := exec.Command(c[0], c[1:]...).CombinedOutput(); err != nil {
+		return false, withReason(ReasonMountTmpfs, fmt.Errorf("mount tmpfs at %s failed, %v, output: %s", hostDir, err, string(output)))
+	}
+	return true, nil
+}
+
+// tmpfsMounts returns the tmpfs mounts below dir.
+func tmpfsMounts(dir string) ([]mountinfo.Mount, error) {
+	mounts, err := mountinfo.ParseFile(mountinfo.SelfPath)
+	if err != nil {
+		return nil, fmt.Errorf("read mount table failed, %v", err)
+	}
+
+	var result []mountinfo.Mount
+	for _, m := range mounts {
+		if m.FSType == "tmpfs" && strings.HasPrefix(m.MountPoint, dir+"/") {
+			result = append(result, m)
+		}
+	}
+	return result, nil
+}
+
+func tmpfsMounted(hostDir string) (bool, error) {
+	mounts, err := tmpfsMounts(path.Dir(hostDir))
+	if err != nil {
+		return false, err
+	}
+	for _, m := range mounts {
+		if m.MountPoint == hostDir {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func tmpfsSize(mountPoint string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(mountPoint, &st); err != nil {
+		return 0, errors.Wrapf(err, "statfs %s failed", mountPoint)
+	}
+	return int64(st.Blocks) * int64(st.Bsize), nil
+}
+
+// unmountTmpfs unmounts the tmpfs of a memory volume before its dir is removed, the innermost first.
+func unmountTmpfs(volumeDir string) error {
+	mounts, err := tmpfsMounts(volumeDir)
+	if err != nil {
+		return err
+	}
+	for i := len(mounts) - 1; i >= 0; i-- {
+		if err := unMount(mounts[i].MountPoint); err != nil {
+			return fmt.Errorf("unmount tmpfs %s failed, %v", mounts[i].MountPoint, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package driver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rancher/log-aggregator/parser"
+)
+
+// validateFormat rejects formats that would break out of the generated fluentd source, a /.../ format
+// is a ruby regex and must compile once its named groups are spelled the go way.
+func validateFormat(format string) error {
+	if hasControl(format) {
+		return fmt.Errorf("format %q contains control characters", format)
+	}
+	if parser.IsRegexp(format) {
+		if _, err := parser.CompileRegexp(format); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasControl catches newlines in particular, they would start a new fluentd parameter.
+func hasControl(s string) bool {
+	return strings.IndexFunc(s, func(r rune) bool { return r < 0x20 || r == 0x7f }) >= 0
+}
+
+// validateParseOptions checks the parameters a struct tag cannot, all of them end up in the fluentd config.
+func validateParseOptions(opts Options) error {
+	for name, v := range map[string]string{"timeKey": opts.TimeKey, "timeFormat": opts.TimeFormat, "types": opts.Types} {
+		if hasControl(v) {
+			return fmt.Errorf("%s %q contains control characters", name, v)
+		}
+	}
+	if strings.ContainsAny(opts.TimeKey, " #") {
+		return fmt.Errorf("timeKey %q contains spaces or #", opts.TimeKey)
+	}
+	if opts.FromEncoding != "" && opts.Encoding == "" {
+		return fmt.Errorf("fromEncoding requires encoding")
+	}
+
+	_, err := parser.ParseTypes(opts.Types)
+	return err
+}
+
+// validateMode keeps the format in line with what the daemon writes for the mode.
+func validateMode(opts Options) error {
+	if (opts.Mode == ModeSyslog || opts.Mode == ModeForward) && opts.Format != parser.FormatJSON {
+		return fmt.Errorf("mode %s writes what it receives as json lines, format must be json", opts.Mode)
+	}
+	if opts.Fifos != "" {
+		if opts.Mode != ModeFifo {
+			return fmt.Errorf("fifos requires mode fifo")
+		}
+		if !fifosRegexp.MatchString(opts.Fifos) {
+			return fmt.Errorf("fifos must be a comma separated list of names of letters, digits, _ and -")
+		}
+	}
+	return nil
+}
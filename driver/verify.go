@@ -0,0 +1,246 @@
+package driver
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/rancher/log-aggregator/mountinfo"
+	"github.com/rancher/log-aggregator/volume"
+)
+
+const (
+	IssueBrokenConfig    = "BrokenConfig"
+	IssueMissingHostDir  = "MissingHostDir"
+	IssueMissingConfig   = "MissingConfig"
+	IssueOrphanedVolume  = "OrphanedVolume"
+	IssueOrphanedPosFile = "OrphanedPosFile"
+	IssueStaleMount      = "StaleMount"
+)
+
+type Issue struct {
+	Kind    string `json:"kind"`
+	Subject string `json:"subject"`
+	Message string `json:"message"`
+	Fix     string `json:"fix"`
+	// Repairable fixes are safe to apply without an operator, see Verify
+	Repairable  bool   `json:"repairable"`
+	Repaired    bool   `json:"repaired,omitempty"`
+	RepairError string `json:"repairError,omitempty"`
+
+	repair func() error
+}
+
+// ChangesConfig reports whether the repair of the issue changed what fluentd has to read.
+func (i Issue) ChangesConfig() bool {
+	return i.Repaired && (i.Kind == IssueBrokenConfig || i.Kind == IssueMissingHostDir || i.Kind == IssueMissingConfig)
+}
+
+type verifier struct {
+	f          *FlexVolumeDriver
+	list       []volume.Volume
+	volumes    map[string]volume.Volume
+	mounts     []mountinfo.Mount
+	podsDir    string
+	configured map[string]bool
+	issues     []Issue
+}
+
+// Verify cross checks the generated configs, host dirs, mount table, kubelet pods and pos files. With repair
+// the safe fixes are applied: regenerating configs from metadata.json, removing configs and pos files of
+// volumes that are gone and draining volumes of deleted pods.
+func (f *FlexVolumeDriver) Verify(repair bool) ([]Issue, error) {
+	volumes, err := volume.List(svcLogBaseDir)
+	if err != nil {
+		return nil, err
+	}
+	mounts, err := mountinfo.ParseFile(mountinfo.SelfPath)
+	if err != nil {
+		return nil, fmt.Errorf("read mount table failed, %v", err)
+	}
+
+	v := &verifier{
+		f:          f,
+		list:       volumes,
+		volumes:    make(map[string]volume.Volume, len(volumes)),
+		mounts:     mounts,
+		podsDir:    path.Join(f.Config.KubeletDir, "pods"),
+		configured: map[string]bool{},
+	}
+	for _, vol := range volumes {
+		v.volumes[vol.IdentifyName()] = vol
+	}
+
+	if err := v.verifyConfigs(); err != nil {
+		return nil, err
+	}
+	v.verifyVolumes()
+	if err := v.verifyPosFiles(); err != nil {
+		return nil, err
+	}
+	v.verifyMounts()
+
+	if repair {
+		for i := range v.issues {
+			if v.issues[i].repair == nil {
+				continue
+			}
+			if err := v.issues[i].repair(); err != nil {
+				v.issues[i].RepairError = err.Error()
+				continue
+			}
+			v.issues[i].Repaired = true
+		}
+	}
+	return v.issues, nil
+}
+
+func (v *verifier) add(issue Issue, repair func() error) {
+	issue.Repairable = repair != nil
+	issue.repair = repair
+	v.issues = append(v.issues, issue)
+}
+
+func (v *verifier) verifyConfigs() error {
+	for _, dir := range []string{svcClusterLogConfigDir, svcProjectLogConfigDir} {
+		files, err := filepath.Glob(path.Join(dir, "*.conf"))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			v.verifyConfig(file)
+		}
+	}
+	return nil
+}
+
+func (v *verifier) verifyConfig(file string) {
+	name := strings.TrimSuffix(path.Base(file), ".conf")
+	v.configured[name] = true
+	vol, exists := v.volumes[name]
+
+	sources, err := parseConfigFile(file)
+	if err != nil {
+		if exists {
+			fix, repair := v.regenerateFix(vol)
+			v.add(Issue{Kind: IssueBrokenConfig, Subject: file, Message: err.Error(), Fix: fix}, repair)
+			return
+		}
+		v.add(Issue{Kind: IssueBrokenConfig, Subject: file, Message: err.Error(), Fix: "remove " + file + ", its volume is gone"},
+			removeConfigs(name))
+		return
+	}
+
+	for _, source := range sources {
+		hostDir := path.Dir(source["path"])
+		if _, err := os.Stat(hostDir); !os.IsNotExist(err) {
+			continue
+		}
+		message := fmt.Sprintf("tails %s which does not exist", hostDir)
+		if exists {
+			fix, repair := v.regenerateFix(vol)
+			v.add(Issue{Kind: IssueMissingHostDir, Subject: file, Message: message, Fix: fix}, repair)
+			return
+		}
+		v.add(Issue{Kind: IssueMissingHostDir, Subject: file, Message: message, Fix: "remove the configs and pos files of " + name},
+			removeConfigs(name))
+		return
+	}
+}
+
+func (v *verifier) verifyVolumes() {
+	for _, vol := range v.list {
+		name := vol.IdentifyName()
+		draining := false
+		if _, err := os.Stat(drainRequestPath(name)); err == nil {
+			draining = true
+		}
+
+		if _, err := os.Stat(v.podsDir); err == nil && !draining {
+			if _, err := os.Stat(path.Join(v.podsDir, vol.PodUID)); os.IsNotExist(err) {
+				v.add(Issue{
+					Kind:    IssueOrphanedVolume,
+					Subject: vol.Dir,
+					Message: fmt.Sprintf("pod %s is gone but the volume is not draining", vol.PodUID),
+					Fix:     "hand the volume to the drainer",
+				}, func() error {
+					return queueDrain(name, v.f.Config.DrainTimeout.Duration)
+				})
+				continue
+			}
+		}
+
+		configFiles := configFilePaths(name)
+		if vol.Format == customiseFormat && len(existingFiles(configFiles)) != len(configFiles) && len(volumeMountPoints(vol, v.mounts)) != 0 {
+			fix, repair := v.regenerateFix(vol)
+			v.add(Issue{
+				Kind:    IssueMissingConfig,
+				Subject: vol.Dir,
+				Message: "mounted with a custom format but its fluentd configs are missing",
+				Fix:     fix,
+			}, repair)
+		}
+	}
+}
+
+func (v *verifier) verifyPosFiles() error {
+	for _, prefix := range []string{clusterPosFilePrefix, projectPosFilePrefix, nativePosFilePrefix} {
+		files, err := filepath.Glob(path.Join(svcLogPosDir, prefix+"*.pos"))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			name := strings.TrimSuffix(strings.TrimPrefix(path.Base(file), prefix), ".pos")
+			if _, exists := v.volumes[name]; exists || v.configured[name] {
+				continue
+			}
+			file := file
+			v.add(Issue{
+				Kind:    IssueOrphanedPosFile,
+				Subject: file,
+				Message: fmt.Sprintf("neither a config nor a volume %s exists", name),
+				Fix:     "remove " + file,
+			}, func() error {
+				return removeFiles([]string{file})
+			})
+		}
+	}
+	return nil
+}
+
+// verifyMounts finds bind mounts whose host dir was removed while the pod still uses it, only
+// recreating the pod gets it a working volume again.
+func (v *verifier) verifyMounts() {
+	for _, m := range v.mounts {
+		if !strings.HasSuffix(m.Root, deletedRootMark) || !strings.Contains(m.Root, "/"+path.Base(svcLogBaseDir)+"/") {
+			continue
+		}
+		v.add(Issue{
+			Kind:    IssueStaleMount,
+			Subject: m.MountPoint,
+			Message: fmt.Sprintf("host dir %s was removed while mounted", strings.TrimSuffix(m.Root, deletedRootMark)),
+			Fix:     "delete the pod so the kubelet unmounts the volume and mounts a new one",
+		}, nil)
+	}
+}
+
+func (v *verifier) regenerateFix(vol volume.Volume) (string, func() error) {
+	if vol.Metadata == nil || vol.HostDir == "" {
+		return "remount the volume, it has no metadata.json or host dir to regenerate the config from", nil
+	}
+	if migratedMetadata(vol.Metadata) {
+		return "remount the volume, its metadata was migrated from the host dir name and lacks the format options", nil
+	}
+	return "run log-aggregator regenerate", func() error {
+		_, err := v.f.regenerateVolume(vol, false)
+		return err
+	}
+}
+
+func removeConfigs(identifyName string) func() error {
+	return func() error {
+		return removeFiles(append(configFilePaths(identifyName), posFilePaths(identifyName)...))
+	}
+}
@@ -0,0 +1,16 @@
+package driver
+
+import "testing"
+
+func TestMigratedVolumeIsNotRepairable(t *testing.T) {
+	v := &verifier{f: testDriver()}
+	vol := migratedVolume(t)
+	if fix, repair := v.regenerateFix(vol); repair != nil {
+		t.Errorf("migrated volume is repaired by %q", fix)
+	}
+
+	delete(vol.Metadata, migratedMetadataKey)
+	if fix, repair := v.regenerateFix(vol); repair != nil {
+		t.Errorf("unmarked migrated volume is repaired by %q", fix)
+	}
+}
@@ -0,0 +1,148 @@
+package events
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	requestTimeout    = 10 * time.Second
+)
+
+// APISink creates core v1 events through the API server with the credentials of the pod it runs in.
+type APISink struct {
+	Host      string
+	Token     string
+	Component string
+	NodeName  string
+	Client    *http.Client
+}
+
+func InClusterSink(component, nodeName string) (*APISink, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a pod, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+
+	token, err := ioutil.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, fmt.Errorf("read service account token failed, %v", err)
+	}
+	ca, err := ioutil.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("read service account ca failed, %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificate found in service account ca")
+	}
+
+	return &APISink{
+		Host:      "https://" + net.JoinHostPort(host, port),
+		Token:     strings.TrimSpace(string(token)),
+		Component: component,
+		NodeName:  nodeName,
+		Client: &http.Client{
+			Timeout: requestTimeout,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			},
+		},
+	}, nil
+}
+
+type objectMeta struct {
+	GenerateName string `json:"generateName,omitempty"`
+	Namespace    string `json:"namespace,omitempty"`
+}
+
+type objectReference struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace"`
+	Name       string `json:"name"`
+	UID        string `json:"uid,omitempty"`
+}
+
+type eventSource struct {
+	Component string `json:"component,omitempty"`
+	Host      string `json:"host,omitempty"`
+}
+
+type apiEvent struct {
+	APIVersion     string          `json:"apiVersion"`
+	Kind           string          `json:"kind"`
+	Metadata       objectMeta      `json:"metadata"`
+	InvolvedObject objectReference `json:"involvedObject"`
+	Reason         string          `json:"reason"`
+	Message        string          `json:"message"`
+	Type           string          `json:"type"`
+	Count          int             `json:"count"`
+	FirstTimestamp time.Time       `json:"firstTimestamp"`
+	LastTimestamp  time.Time       `json:"lastTimestamp"`
+	Source         eventSource     `json:"source"`
+}
+
+func (s *APISink) Post(e Event) error {
+	count := e.Count
+	if count < 1 {
+		count = 1
+	}
+	b, err := json.Marshal(apiEvent{
+		APIVersion: "v1",
+		Kind:       "Event",
+		Metadata: objectMeta{
+			GenerateName: e.PodName + ".",
+			Namespace:    e.Namespace,
+		},
+		InvolvedObject: objectReference{
+			APIVersion: "v1",
+			Kind:       "Pod",
+			Namespace:  e.Namespace,
+			Name:       e.PodName,
+			UID:        e.PodUID,
+		},
+		Reason:         e.Reason,
+		Message:        e.Message,
+		Type:           e.Type,
+		Count:          count,
+		FirstTimestamp: e.Time,
+		LastTimestamp:  e.Time,
+		Source: eventSource{
+			Component: s.Component,
+			Host:      s.NodeName,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/api/v1/namespaces/%s/events", s.Host, e.Namespace)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+s.Token)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("post event for pod %s/%s failed, %v", e.Namespace, e.PodName, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("post event for pod %s/%s failed, %s: %s", e.Namespace, e.PodName, resp.Status, string(body))
+	}
+	return nil
+}
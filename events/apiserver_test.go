@@ -0,0 +1,60 @@
+package events
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAPISinkPost(t *testing.T) {
+	var (
+		gotPath, gotAuth string
+		got              apiEvent
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotAuth = r.URL.Path, r.Header.Get("Authorization")
+		b, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(b, &got); err != nil {
+			t.Errorf("invalid event body %s, %v", string(b), err)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	sink := &APISink{Host: server.URL, Token: "token", Component: "log-aggregator", NodeName: "node-1", Client: server.Client()}
+	e := testEvent("a", time.Unix(1500000000, 0).UTC())
+	if err := sink.Post(e); err != nil {
+		t.Fatal(err)
+	}
+
+	if gotPath != "/api/v1/namespaces/ns/events" {
+		t.Errorf("posted to %s", gotPath)
+	}
+	if gotAuth != "Bearer token" {
+		t.Errorf("authorization header %q", gotAuth)
+	}
+	if got.InvolvedObject.Kind != "Pod" || got.InvolvedObject.Name != "a" || got.InvolvedObject.UID != "uid-a" || got.InvolvedObject.Namespace != "ns" {
+		t.Errorf("involved object %+v", got.InvolvedObject)
+	}
+	if got.Reason != e.Reason || got.Type != TypeWarning || got.Message != e.Message || got.Count != 1 {
+		t.Errorf("event %+v does not match %+v", got, e)
+	}
+	if got.Source.Component != "log-aggregator" || got.Source.Host != "node-1" || !got.FirstTimestamp.Equal(e.Time) {
+		t.Errorf("source %+v, first timestamp %s", got.Source, got.FirstTimestamp)
+	}
+}
+
+func TestAPISinkPostRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	sink := &APISink{Host: server.URL, Client: server.Client()}
+	if err := sink.Post(testEvent("a", time.Now())); err == nil {
+		t.Errorf("a rejected post returned no error")
+	}
+}
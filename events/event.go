@@ -0,0 +1,121 @@
+package events
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	TypeNormal  = "Normal"
+	TypeWarning = "Warning"
+)
+
+type Event struct {
+	Namespace string    `json:"namespace"`
+	PodName   string    `json:"podName"`
+	PodUID    string    `json:"podUID"`
+	Type      string    `json:"type"`
+	Reason    string    `json:"reason"`
+	Message   string    `json:"message"`
+	Time      time.Time `json:"time"`
+	// Count is above one when the rate limiter folded repeated events into this one
+	Count int `json:"count,omitempty"`
+}
+
+func (e Event) key() string {
+	return fmt.Sprintf("%s/%s/%s", e.PodUID, e.Type, e.Reason)
+}
+
+// Spool hands events from the short lived driver calls, which have no API credentials, to the daemon.
+type Spool struct {
+	Dir string
+	// MaxEvents bounds the spool while no daemon is draining it, the oldest events are dropped first
+	MaxEvents int
+}
+
+type SpooledEvent struct {
+	Event
+	File string `json:"-"`
+}
+
+func (s *Spool) Write(e Event) error {
+	if err := os.MkdirAll(s.Dir, os.ModePerm); err != nil {
+		return errors.Wrapf(err, "create event spool %s failed", s.Dir)
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	name := fmt.Sprintf("%d-%s.json", e.Time.UnixNano(), hex.EncodeToString(suffix))
+	// the temp file stays out of the *.json globs of a daemon reading the spool meanwhile
+	tmp := path.Join(s.Dir, name+".tmp")
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		os.Remove(tmp)
+		return errors.Wrapf(err, "write event %s failed", tmp)
+	}
+	if err := os.Rename(tmp, path.Join(s.Dir, name)); err != nil {
+		return err
+	}
+	return s.trim()
+}
+
+// List returns the spooled events oldest first, unreadable files are removed.
+func (s *Spool) List() ([]SpooledEvent, error) {
+	files, err := filepath.Glob(path.Join(s.Dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	var result []SpooledEvent
+	for _, v := range files {
+		b, err := ioutil.ReadFile(v)
+		if err != nil {
+			continue
+		}
+		e := SpooledEvent{File: v}
+		if err := json.Unmarshal(b, &e.Event); err != nil {
+			os.Remove(v)
+			continue
+		}
+		result = append(result, e)
+	}
+	return result, nil
+}
+
+func (s *Spool) Remove(e SpooledEvent) error {
+	if err := os.Remove(e.File); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *Spool) trim() error {
+	if s.MaxEvents <= 0 {
+		return nil
+	}
+	files, err := filepath.Glob(path.Join(s.Dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	for len(files) > s.MaxEvents {
+		os.Remove(files[0])
+		files = files[1:]
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package events
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func tempSpool(t *testing.T, max int) (*Spool, func()) {
+	dir, err := ioutil.TempDir("", "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Spool{Dir: path.Join(dir, "spool"), MaxEvents: max}, func() { os.RemoveAll(dir) }
+}
+
+func testEvent(pod string, at time.Time) Event {
+	return Event{
+		Namespace: "ns",
+		PodName:   pod,
+		PodUID:    "uid-" + pod,
+		Type:      TypeWarning,
+		Reason:    "InvalidLogFormat",
+		Message:   "mount log volume vol failed",
+		Time:      at,
+	}
+}
+
+func TestSpoolWriteList(t *testing.T) {
+	s, cleanup := tempSpool(t, 0)
+	defer cleanup()
+
+	base := time.Unix(1500000000, 0).UTC()
+	for i, pod := range []string{"b", "a", "c"} {
+		if err := s.Write(testEvent(pod, base.Add(time.Duration(i)*time.Second))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	spooled, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spooled) != 3 {
+		t.Fatalf("expected 3 spooled events, got %d", len(spooled))
+	}
+	for i, pod := range []string{"b", "a", "c"} {
+		if spooled[i].PodName != pod || !spooled[i].Time.Equal(base.Add(time.Duration(i)*time.Second)) {
+			t.Errorf("event %d is %+v, expected pod %s in write order", i, spooled[i].Event, pod)
+		}
+	}
+
+	if err := s.Remove(spooled[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Remove(spooled[0]); err != nil {
+		t.Errorf("removing an event twice failed, %v", err)
+	}
+	if spooled, _ = s.List(); len(spooled) != 2 {
+		t.Errorf("expected 2 events after remove, got %d", len(spooled))
+	}
+}
+
+func TestSpoolTrim(t *testing.T) {
+	s, cleanup := tempSpool(t, 2)
+	defer cleanup()
+
+	base := time.Unix(1500000000, 0)
+	for i, pod := range []string{"a", "b", "c", "d"} {
+		if err := s.Write(testEvent(pod, base.Add(time.Duration(i)*time.Second))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	spooled, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spooled) != 2 || spooled[0].PodName != "c" || spooled[1].PodName != "d" {
+		t.Errorf("expected the 2 newest events c and d, got %+v", spooled)
+	}
+}
+
+func TestSpoolListSkipsPartialFiles(t *testing.T) {
+	s, cleanup := tempSpool(t, 0)
+	defer cleanup()
+
+	if err := s.Write(testEvent("a", time.Unix(1500000000, 0))); err != nil {
+		t.Fatal(err)
+	}
+	// a driver call still writing its event, the daemon must neither read nor remove it
+	partial := path.Join(s.Dir, "1500000001000000000-00000000.json.tmp")
+	if err := ioutil.WriteFile(partial, []byte(`{"namespace":"n`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	corrupt := path.Join(s.Dir, "1500000002000000000-00000000.json")
+	if err := ioutil.WriteFile(corrupt, []byte(`{"namespace":"n`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	spooled, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spooled) != 1 || spooled[0].PodName != "a" {
+		t.Errorf("expected only the complete event, got %+v", spooled)
+	}
+	if _, err := os.Stat(partial); err != nil {
+		t.Errorf("the temp file of a write in progress was touched, %v", err)
+	}
+	if _, err := os.Stat(corrupt); !os.IsNotExist(err) {
+		t.Errorf("the corrupt event was not removed, %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Sink posts an event to the API server, tests can use a fake one.
+type Sink interface {
+	Post(Event) error
+}
+
+// Recorder rate limits events before they reach the sink. An event is dropped when the same pod saw
+// the same reason within Interval, the next one that passes carries the number of dropped ones in Count.
+// Across all pods at most Burst events pass at once, refilled at QPS.
+type Recorder struct {
+	Sink     Sink
+	Interval time.Duration
+	QPS      float64
+	Burst    int
+
+	lock       sync.Mutex
+	last       map[string]time.Time
+	suppressed map[string]int
+	tokens     float64
+	refilled   time.Time
+	now        func() time.Time
+}
+
+func NewRecorder(sink Sink, interval time.Duration, qps float64, burst int) *Recorder {
+	return &Recorder{
+		Sink:       sink,
+		Interval:   interval,
+		QPS:        qps,
+		Burst:      burst,
+		last:       map[string]time.Time{},
+		suppressed: map[string]int{},
+		tokens:     float64(burst),
+		now:        time.Now,
+	}
+}
+
+// Record reports whether the event was posted, a suppressed event is not an error. The interval and the
+// token only count once the post succeeded, a failed event is let through again when retried.
+func (r *Recorder) Record(e Event) (bool, error) {
+	count, ok := r.allow(e)
+	if !ok {
+		return false, nil
+	}
+	e.Count = count
+	if err := r.Sink.Post(e); err != nil {
+		return false, err
+	}
+	r.commit(e, count)
+	return true, nil
+}
+
+// allow returns the count of the event when it may be posted, without taking the token.
+func (r *Recorder) allow(e Event) (int, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := r.now()
+	r.forget(now)
+	key := e.key()
+	if last, ok := r.last[key]; ok && now.Sub(last) < r.Interval {
+		r.suppressed[key]++
+		return 0, false
+	}
+
+	if r.Burst > 0 {
+		if !r.refilled.IsZero() {
+			r.tokens += now.Sub(r.refilled).Seconds() * r.QPS
+			if r.tokens > float64(r.Burst) {
+				r.tokens = float64(r.Burst)
+			}
+		}
+		r.refilled = now
+		if r.tokens < 1 {
+			r.suppressed[key]++
+			return 0, false
+		}
+	}
+	return r.suppressed[key] + 1, true
+}
+
+// commit takes the token and starts the interval of a posted event, the suppressed events it counted
+// are reported.
+func (r *Recorder) commit(e Event, count int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	key := e.key()
+	if r.Burst > 0 {
+		r.tokens--
+	}
+	r.last[key] = r.now()
+	if r.suppressed[key] -= count - 1; r.suppressed[key] <= 0 {
+		delete(r.suppressed, key)
+	}
+}
+
+// forget drops the keys whose interval passed with nothing suppressed, pods come and go.
+func (r *Recorder) forget(now time.Time) {
+	for key, last := range r.last {
+		if now.Sub(last) >= r.Interval && r.suppressed[key] == 0 {
+			delete(r.last, key)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package events
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type fakeSink struct {
+	posted []Event
+	err    error
+}
+
+func (s *fakeSink) Post(e Event) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.posted = append(s.posted, e)
+	return nil
+}
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Add(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestRecorder(sink Sink, interval time.Duration, qps float64, burst int) (*Recorder, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(1500000000, 0)}
+	r := NewRecorder(sink, interval, qps, burst)
+	r.now = clock.Now
+	return r, clock
+}
+
+func TestRecorderFoldsRepeatedEvents(t *testing.T) {
+	sink := &fakeSink{}
+	r, clock := newTestRecorder(sink, time.Minute, 0, 0)
+
+	e := testEvent("a", clock.now)
+	for i := 0; i < 3; i++ {
+		posted, err := r.Record(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if posted != (i == 0) {
+			t.Errorf("record %d posted %v", i, posted)
+		}
+		clock.Add(10 * time.Second)
+	}
+
+	// another pod or reason is not folded into the first one
+	other := testEvent("b", clock.now)
+	if posted, _ := r.Record(other); !posted {
+		t.Errorf("event of another pod was suppressed")
+	}
+
+	clock.Add(time.Minute)
+	if posted, _ := r.Record(e); !posted {
+		t.Fatalf("event after the interval was suppressed")
+	}
+
+	if len(sink.posted) != 3 {
+		t.Fatalf("expected 3 posted events, got %d", len(sink.posted))
+	}
+	if sink.posted[0].Count != 1 || sink.posted[1].Count != 1 {
+		t.Errorf("first events carry counts %d and %d, expected 1", sink.posted[0].Count, sink.posted[1].Count)
+	}
+	if sink.posted[2].Count != 3 {
+		t.Errorf("event after the interval carries count %d, expected the 2 suppressed ones plus itself", sink.posted[2].Count)
+	}
+}
+
+func TestRecorderBurst(t *testing.T) {
+	sink := &fakeSink{}
+	r, clock := newTestRecorder(sink, time.Minute, 1, 2)
+
+	var posted int
+	for _, pod := range []string{"a", "b", "c", "d"} {
+		if ok, _ := r.Record(testEvent(pod, clock.now)); ok {
+			posted++
+		}
+	}
+	if posted != 2 {
+		t.Errorf("expected the burst of 2 events to pass, %d did", posted)
+	}
+
+	clock.Add(time.Second)
+	if ok, _ := r.Record(testEvent("c", clock.now)); !ok {
+		t.Errorf("event was suppressed after a token was refilled")
+	}
+	if ok, _ := r.Record(testEvent("d", clock.now)); ok {
+		t.Errorf("event passed with no token left")
+	}
+}
+
+func TestRecorderSinkError(t *testing.T) {
+	sink := &fakeSink{err: errors.New("api server down")}
+	r, clock := newTestRecorder(sink, time.Minute, 0, 0)
+
+	if posted, err := r.Record(testEvent("a", clock.now)); posted || err == nil {
+		t.Errorf("record returned %v, %v, expected the sink error", posted, err)
+	}
+}
+
+func TestRecorderRetriesFailedPost(t *testing.T) {
+	sink := &fakeSink{err: errors.New("api server down")}
+	r, clock := newTestRecorder(sink, time.Minute, 1, 1)
+
+	e := testEvent("a", clock.now)
+	if posted, err := r.Record(e); posted || err == nil {
+		t.Fatalf("record returned %v, %v, expected the sink error", posted, err)
+	}
+	if posted, _ := r.Record(e); posted {
+		t.Fatalf("record posted while the sink was down")
+	}
+
+	// the failed post neither started the interval nor took the token
+	sink.err = nil
+	clock.Add(time.Second)
+	posted, err := r.Record(e)
+	if err != nil || !posted {
+		t.Fatalf("retry of a failed event returned %v, %v", posted, err)
+	}
+	if len(sink.posted) != 1 || sink.posted[0].Count != 1 {
+		t.Errorf("expected one posted event with count 1, got %+v", sink.posted)
+	}
+	if posted, _ := r.Record(e); posted {
+		t.Errorf("event right after a posted one was not folded")
+	}
+}
@@ -0,0 +1,153 @@
+// Package fifo reads the named pipes of fifo mode volumes into a bounded memory buffer, so the
+// applications writing to them never wait on a slow output and nothing touches the node disk.
+package fifo
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// DropOldest makes room for new lines by dropping the oldest buffered ones
+	DropOldest = "drop-oldest"
+	// DropNewest drops the lines arriving while the buffer is full
+	DropNewest = "drop-newest"
+
+	// MaxLineSize bounds a line, a longer one is split
+	MaxLineSize = 64 * 1024
+)
+
+type Line struct {
+	Path string
+	Text []byte
+	Time time.Time
+}
+
+// Reader reads every pipe in its own goroutine until it is closed. A pipe is opened for reading and
+// writing, so it never reports EOF when a writer goes away and writers never wait for a reader to open it.
+type Reader struct {
+	Dir    string
+	Limit  int
+	Policy string
+
+	files   []*os.File
+	wg      sync.WaitGroup
+	lock    sync.Mutex
+	lines   []Line
+	size    int
+	dropped uint64
+}
+
+func Open(dir string, names []string, limit int, policy string) (*Reader, error) {
+	if policy != DropOldest && policy != DropNewest {
+		return nil, fmt.Errorf("unsupported drop policy %s", policy)
+	}
+
+	r := &Reader{Dir: dir, Limit: limit, Policy: policy}
+	for _, name := range names {
+		p := path.Join(dir, name)
+		f, err := openFifo(p)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		r.files = append(r.files, f)
+		r.wg.Add(1)
+		go r.read(f, p)
+	}
+	return r, nil
+}
+
+func openFifo(p string) (*os.File, error) {
+	info, err := os.Lstat(p)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode()&os.ModeNamedPipe == 0 {
+		return nil, fmt.Errorf("%s is no fifo", p)
+	}
+	return os.OpenFile(p, os.O_RDWR|syscall.O_NONBLOCK, 0)
+}
+
+func (r *Reader) read(f *os.File, p string) {
+	defer r.wg.Done()
+	br := bufio.NewReaderSize(f, MaxLineSize)
+	for {
+		line, err := br.ReadSlice('\n')
+		if err != nil && err != bufio.ErrBufferFull {
+			return
+		}
+		if n := len(line); n != 0 && line[n-1] == '\n' {
+			line = line[:n-1]
+		}
+		if len(line) != 0 {
+			r.push(Line{Path: p, Text: append([]byte(nil), line...), Time: time.Now()})
+		}
+	}
+}
+
+func (r *Reader) push(line Line) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.lines = append(r.lines, line)
+	r.size += len(line.Text)
+	r.trim()
+}
+
+// trim drops lines by the policy until the buffer fits into Limit.
+func (r *Reader) trim() {
+	for r.size > r.Limit && len(r.lines) != 0 {
+		i := len(r.lines) - 1
+		if r.Policy == DropOldest {
+			i = 0
+		}
+		r.size -= len(r.lines[i].Text)
+		r.lines = append(r.lines[:i], r.lines[i+1:]...)
+		r.dropped++
+	}
+}
+
+// Take removes and returns the buffered lines.
+func (r *Reader) Take() []Line {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	lines := r.lines
+	r.lines, r.size = nil, 0
+	return lines
+}
+
+// Requeue puts back taken lines the output did not accept, ahead of the lines read since. They count
+// against Limit like new lines.
+func (r *Reader) Requeue(lines []Line) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, v := range lines {
+		r.size += len(v.Text)
+	}
+	r.lines = append(append([]Line(nil), lines...), r.lines...)
+	r.trim()
+}
+
+// Stats returns the buffered bytes and the lines dropped since Open.
+func (r *Reader) Stats() (int, uint64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.size, r.dropped
+}
+
+// Close stops reading, lines written to the pipes afterwards stay in the pipe buffer for the next Reader.
+func (r *Reader) Close() error {
+	var firstErr error
+	for _, f := range r.files {
+		if err := f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	r.wg.Wait()
+	return firstErr
+}
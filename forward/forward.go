@@ -0,0 +1,261 @@
+// Package forward receives events over the fluentd forward protocol on a unix socket inside a log volume,
+// for applications using the fluent-logger libraries.
+package forward
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rancher/log-aggregator/msgpack"
+	"github.com/rancher/log-aggregator/socket"
+)
+
+const (
+	// Socket is where fluent-logger clients connect, the unix socket of their forward sender. It has
+	// no dot, so the tail glob of the host dir skips it
+	Socket = "fluent-sock"
+
+	maxLength = 8 << 20
+)
+
+type Event struct {
+	Tag    string
+	Time   time.Time
+	Record map[string]interface{}
+}
+
+// Handler gets the events of one message, the message is only acknowledged after it returns.
+type Handler func([]Event)
+
+type Server struct {
+	Dir     string
+	Handler Handler
+
+	listener *net.UnixListener
+	wg       sync.WaitGroup
+	lock     sync.Mutex
+	conns    map[net.Conn]bool
+	closed   bool
+}
+
+func Listen(dir string, handler Handler) (*Server, error) {
+	l, err := socket.Listen(dir, Socket)
+	if err != nil {
+		return nil, fmt.Errorf("listen on forward socket in %s failed, %v", dir, err)
+	}
+
+	s := &Server{Dir: dir, Handler: handler, listener: l, conns: map[net.Conn]bool{}}
+	s.wg.Add(1)
+	go s.accept()
+	return s, nil
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	var backoff socket.Backoff
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return
+			}
+			backoff.Wait()
+			continue
+		}
+		backoff.Reset()
+
+		s.lock.Lock()
+		if s.closed {
+			s.lock.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = true
+		s.wg.Add(1)
+		s.lock.Unlock()
+		go s.serve(conn)
+	}
+}
+
+// serve reads messages until the client disconnects or sends something that is not the protocol.
+func (s *Server) serve(conn net.Conn) {
+	defer func() {
+		s.lock.Lock()
+		delete(s.conns, conn)
+		s.lock.Unlock()
+		conn.Close()
+		s.wg.Done()
+	}()
+
+	dec := msgpack.NewDecoder(bufio.NewReader(conn))
+	dec.MaxLength = maxLength
+	for {
+		v, err := dec.Decode()
+		if err != nil {
+			return
+		}
+		events, option, err := decodeMessage(v)
+		if err != nil {
+			return
+		}
+		if len(events) != 0 {
+			s.Handler(events)
+		}
+
+		if chunk, ok := option["chunk"]; ok {
+			ack, err := msgpack.Marshal(map[string]interface{}{"ack": chunk})
+			if err != nil {
+				return
+			}
+			if _, err := conn.Write(ack); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// decodeMessage reads the Message, Forward, PackedForward and CompressedPackedForward modes. Entries
+// whose record is no map with string keys are dropped.
+func decodeMessage(v interface{}) ([]Event, map[string]interface{}, error) {
+	message, ok := v.([]interface{})
+	if !ok || len(message) < 2 {
+		return nil, nil, fmt.Errorf("message is no array of tag and entries")
+	}
+	tag, ok := message[0].(string)
+	if !ok {
+		return nil, nil, fmt.Errorf("tag is no string")
+	}
+	option, _ := message[len(message)-1].(map[string]interface{})
+
+	switch entries := message[1].(type) {
+	case []interface{}:
+		var events []Event
+		for _, e := range entries {
+			if event, ok := decodeEntry(tag, e); ok {
+				events = append(events, event)
+			}
+		}
+		return events, option, nil
+	case []byte, string:
+		events, err := decodePacked(tag, bytesOf(entries), option["compressed"] == "gzip")
+		return events, option, err
+	default:
+		if len(message) < 3 {
+			return nil, nil, fmt.Errorf("message mode without a record")
+		}
+		var events []Event
+		if event, ok := decodeEntry(tag, []interface{}{message[1], message[2]}); ok {
+			events = append(events, event)
+		}
+		return events, option, nil
+	}
+}
+
+func decodePacked(tag string, data []byte, compressed bool) ([]Event, error) {
+	if compressed {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if data, err = ioutil.ReadAll(io.LimitReader(zr, maxLength+1)); err != nil {
+			return nil, err
+		}
+		if len(data) > maxLength {
+			return nil, fmt.Errorf("compressed entries exceed %d bytes", maxLength)
+		}
+	}
+
+	var events []Event
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.MaxLength = maxLength
+	for {
+		e, err := dec.Decode()
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if event, ok := decodeEntry(tag, e); ok {
+			events = append(events, event)
+		}
+	}
+}
+
+func decodeEntry(tag string, v interface{}) (Event, bool) {
+	entry, ok := v.([]interface{})
+	if !ok || len(entry) != 2 {
+		return Event{}, false
+	}
+	t, err := msgpack.Time(entry[0])
+	if err != nil {
+		return Event{}, false
+	}
+	record, ok := entry[1].(map[string]interface{})
+	if !ok {
+		return Event{}, false
+	}
+	return Event{Tag: tag, Time: t, Record: plain(record).(map[string]interface{})}, true
+}
+
+// plain turns binaries into strings and times into RFC3339, so a record encodes as JSON like it was sent.
+func plain(v interface{}) interface{} {
+	switch t := v.(type) {
+	case []byte:
+		return string(t)
+	case msgpack.Ext:
+		if tm, err := msgpack.Time(t); err == nil {
+			return tm.UTC().Format(time.RFC3339Nano)
+		}
+		return nil
+	case []interface{}:
+		for i, e := range t {
+			t[i] = plain(e)
+		}
+	case map[string]interface{}:
+		for k, e := range t {
+			t[k] = plain(e)
+		}
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			m[fmt.Sprint(k)] = plain(e)
+		}
+		return m
+	}
+	return v
+}
+
+func bytesOf(v interface{}) []byte {
+	if s, ok := v.(string); ok {
+		return []byte(s)
+	}
+	return v.([]byte)
+}
+
+func (s *Server) isClosed() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.closed
+}
+
+// Close stops receiving, removes the socket and waits for the handlers to return.
+func (s *Server) Close() error {
+	s.lock.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.lock.Unlock()
+
+	s.listener.Close()
+	s.wg.Wait()
+	return socket.Remove(s.Dir, Socket)
+}
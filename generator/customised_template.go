@@ -1,19 +1,34 @@
 package generator
 
+// sourceParams are the tail parameters and the v1 parse section shared by both sources,
+// unset parameters are left out so fluentd applies its own defaults.
+var sourceParams = `{{if .ReadFromHead}}
+read_from_head {{.ReadFromHead}}{{end}}{{if .RotateWait}}
+rotate_wait {{.RotateWait}}{{end}}{{if .RefreshInterval}}
+refresh_interval {{.RefreshInterval}}{{end}}{{if .FromEncoding}}
+from_encoding {{.FromEncoding}}{{end}}{{if .Encoding}}
+encoding {{.Encoding}}{{end}}
+<parse>
+  @type {{.ParseType}}{{if .Expression}}
+  expression {{.Expression}}{{end}}{{if .MessageFormat}}
+  message_format {{.MessageFormat}}{{end}}{{if .TimeKey}}
+  time_key {{.TimeKey}}{{end}}{{if .TimeFormat}}
+  time_format {{.TimeFormat}}{{end}}{{if .Timezone}}
+  timezone {{.Timezone}}{{end}}{{if .KeepTimeKey}}
+  keep_time_key {{.KeepTimeKey}}{{end}}{{if .Types}}
+  types {{.Types}}{{end}}
+</parse>
+</source>
+`
+
 var ClusterSourceTemplate = `<source>
 @type tail
 path {{.Path}}
 pos_file {{.ClusterPosPath}}
-tag tmp-cluster-custom.*
-format {{.Format}}
-</source>
-`
+tag tmp-cluster-custom.*` + sourceParams
 
 var ProjectSourceTemplate = `<source>
 @type tail
 path {{.Path}}
 pos_file {{.ProjectPosPath}}
-tag tmp-project-custom.*
-format {{.Format}}
-</source>
-`
+tag tmp-project-custom.*` + sourceParams
@@ -1,21 +1,77 @@
 package generator
 
 import (
-	"os"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
 	"text/template"
 )
 
+// TemplateVersion is bumped with every change of the templates, regenerate rewrites the configs of older ones.
+const TemplateVersion = 2
+
+const stampPrefix = "# generated by log-aggregator"
+
+type Stamp struct {
+	TemplateVersion int
+	InputsHash      string
+}
+
 func GenerateConfigFile(outputPath, templateM, tempalteName string, conf map[string]interface{}) error {
-	tp, err := template.New(tempalteName).Parse(templateM)
+	content, err := RenderConfig(templateM, tempalteName, conf)
 	if err != nil {
 		return err
 	}
+	return ioutil.WriteFile(outputPath, content, 0666)
+}
 
-	output, err := os.Create(outputPath)
+// RenderConfig stamps the output with the template version and a hash of conf, so a config can be
+// traced back to the driver and the inputs that wrote it.
+func RenderConfig(templateM, tempalteName string, conf map[string]interface{}) ([]byte, error) {
+	tp, err := template.New(tempalteName).Parse(templateM)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	hash, err := InputsHash(conf)
+	if err != nil {
+		return nil, err
 	}
-	defer output.Close()
 
-	return tp.Execute(output, conf)
+	var output bytes.Buffer
+	fmt.Fprintf(&output, "%s, template v%d, inputs %s\n", stampPrefix, TemplateVersion, hash)
+	if err = tp.Execute(&output, conf); err != nil {
+		return nil, err
+	}
+	return output.Bytes(), nil
+}
+
+func InputsHash(conf map[string]interface{}) (string, error) {
+	b, err := json.Marshal(conf)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])[:16], nil
+}
+
+// ParseStamp reads the stamp from the first line of a config, configs of earlier versions have none.
+func ParseStamp(content []byte) (Stamp, bool) {
+	line := string(content)
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	if !strings.HasPrefix(line, stampPrefix) {
+		return Stamp{}, false
+	}
+
+	var s Stamp
+	if _, err := fmt.Sscanf(strings.TrimPrefix(line, stampPrefix), ", template v%d, inputs %s", &s.TemplateVersion, &s.InputsHash); err != nil {
+		return Stamp{}, false
+	}
+	return s, true
 }
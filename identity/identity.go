@@ -0,0 +1,129 @@
+// Package identity encodes the workload identity of a log volume into the name of its host dir and back.
+//
+// The segment keeps the historic layout of eight "_" separated fields,
+// <clusterID>_<clusterName>_<namespace>_<projectID>_<projectName>_<workloadName>_<podName>_<containerName>,
+// but every byte outside [A-Za-z0-9.-], and a leading ".", is written as "~" followed by two hex digits.
+// "_" therefore only ever separates fields, and Decode(Encode(i)) == i holds for any input.
+package identity
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MaxSegmentLength is NAME_MAX, longer segments can't be used as a dir name.
+const MaxSegmentLength = 255
+
+const (
+	separator = '_'
+	escape    = '~'
+	numFields = 8
+)
+
+type Identity struct {
+	ClusterID     string `json:"clusterID"`
+	ClusterName   string `json:"clusterName"`
+	Namespace     string `json:"namespace"`
+	ProjectID     string `json:"projectID"`
+	ProjectName   string `json:"projectName"`
+	WorkloadName  string `json:"workloadName"`
+	PodName       string `json:"podName"`
+	ContainerName string `json:"containerName"`
+}
+
+func (i *Identity) fields() []*string {
+	return []*string{&i.ClusterID, &i.ClusterName, &i.Namespace, &i.ProjectID, &i.ProjectName, &i.WorkloadName, &i.PodName, &i.ContainerName}
+}
+
+// Encode returns the path segment of the identity, check it against MaxSegmentLength before using it as a name.
+func Encode(i Identity) string {
+	var buf bytes.Buffer
+	for n, f := range i.fields() {
+		if n > 0 {
+			buf.WriteByte(separator)
+		}
+		buf.WriteString(EncodeField(*f))
+	}
+	return buf.String()
+}
+
+// Decode parses a segment produced by Encode.
+func Decode(segment string) (Identity, error) {
+	parts := strings.Split(segment, string(separator))
+	if len(parts) != numFields {
+		return Identity{}, fmt.Errorf("invalid identity segment %q, expected %d fields, got %d", segment, numFields, len(parts))
+	}
+
+	var i Identity
+	fields := i.fields()
+	for n, p := range parts {
+		v, err := DecodeField(p)
+		if err != nil {
+			return Identity{}, fmt.Errorf("invalid identity segment %q, %v", segment, err)
+		}
+		*fields[n] = v
+	}
+	return i, nil
+}
+
+// DecodeLegacy parses a segment of the versions before Encode, which joined the fields unescaped and
+// only replaced "_" in ProjectName with "~". Escapes are not processed, "~" in such a name is never one.
+func DecodeLegacy(segment string) (Identity, error) {
+	parts := strings.Split(segment, string(separator))
+	if len(parts) != numFields {
+		return Identity{}, fmt.Errorf("invalid legacy identity segment %q, expected %d fields, got %d", segment, numFields, len(parts))
+	}
+
+	var i Identity
+	fields := i.fields()
+	for n, p := range parts {
+		*fields[n] = p
+	}
+	i.ProjectName = strings.Replace(i.ProjectName, string(escape), string(separator), -1)
+	return i, nil
+}
+
+// EncodeField escapes a single field, the result never contains "_" or "/".
+func EncodeField(s string) string {
+	var buf bytes.Buffer
+	for n := 0; n < len(s); n++ {
+		c := s[n]
+		if isSafe(c) && !(n == 0 && c == '.') {
+			buf.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&buf, "%c%02X", escape, c)
+	}
+	return buf.String()
+}
+
+func DecodeField(s string) (string, error) {
+	if strings.IndexByte(s, escape) < 0 {
+		return s, nil
+	}
+
+	var buf bytes.Buffer
+	for n := 0; n < len(s); n++ {
+		c := s[n]
+		if c != escape {
+			buf.WriteByte(c)
+			continue
+		}
+		if n+2 >= len(s) {
+			return "", fmt.Errorf("truncated escape at offset %d", n)
+		}
+		v, err := strconv.ParseUint(s[n+1:n+3], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("invalid escape %q at offset %d", s[n:n+3], n)
+		}
+		buf.WriteByte(byte(v))
+		n += 2
+	}
+	return buf.String(), nil
+}
+
+func isSafe(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '.' || c == '-'
+}
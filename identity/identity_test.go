@@ -0,0 +1,95 @@
+package identity
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+func TestRoundTrip(t *testing.T) {
+	cases := []Identity{
+		{"c-1", "local", "default", "p-1", "Default", "nginx", "nginx-0", "nginx"},
+		{"c-1", "my_cluster", "ns", "p-1", "my_project", "w", "pod", "ct"},
+		{"", "", "", "", "", "", "", ""},
+		{".hidden", "a/b", "~7E", "x~", "~", "_", "__", "ü 中文 \x00\xff"},
+		{"..", ".", "-", "a.b", "tab\tnew\nline", "%41", "~zz", "~4"},
+	}
+	for _, v := range cases {
+		segment := Encode(v)
+		got, err := Decode(segment)
+		if err != nil {
+			t.Errorf("decode %q of %+v failed, %v", segment, v, err)
+			continue
+		}
+		if got != v {
+			t.Errorf("round trip of %+v through %q returned %+v", v, segment, got)
+		}
+	}
+}
+
+func TestRoundTripArbitrary(t *testing.T) {
+	roundTrip := func(clusterID, clusterName, namespace, projectID, projectName, workloadName, podName, containerName string) bool {
+		i := Identity{clusterID, clusterName, namespace, projectID, projectName, workloadName, podName, containerName}
+		got, err := Decode(Encode(i))
+		return err == nil && got == i
+	}
+	if err := quick.Check(roundTrip, &quick.Config{MaxCount: 2000, Rand: rand.New(rand.NewSource(1))}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEncodeFieldIsPathSafe(t *testing.T) {
+	safe := func(s string) bool {
+		v := EncodeField(s)
+		return !strings.ContainsAny(v, "_/") && !strings.HasPrefix(v, ".") && len(strings.Split(Encode(Identity{ProjectName: s}), "_")) == numFields
+	}
+	if err := quick.Check(safe, &quick.Config{MaxCount: 2000, Rand: rand.New(rand.NewSource(2))}); err != nil {
+		t.Error(err)
+	}
+	for _, v := range []string{".", "..", ".x"} {
+		if got := EncodeField(v); strings.HasPrefix(got, ".") {
+			t.Errorf("EncodeField(%q) = %q starts with a dot", v, got)
+		}
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	for _, v := range []string{
+		"a_b_c",
+		"a_b_c_d_e_f_g_h_i",
+		"a_b_c_d_e_f_g_~",
+		"a_b_c_d_e_f_g_~4",
+		"a_b_c_d_e_f_g_~zz",
+	} {
+		if i, err := Decode(v); err == nil {
+			t.Errorf("decode %q returned %+v, expected an error", v, i)
+		}
+	}
+}
+
+func TestDecodeLegacy(t *testing.T) {
+	cases := []struct {
+		segment string
+		want    Identity
+	}{
+		{"c-1_local_default_p-1_Default_nginx_nginx-0_nginx", Identity{"c-1", "local", "default", "p-1", "Default", "nginx", "nginx-0", "nginx"}},
+		// "~" followed by two hex digits is a replaced "_", never an escape
+		{"c-1_local_default_p-1_my~ab_nginx_nginx-0_nginx", Identity{"c-1", "local", "default", "p-1", "my_ab", "nginx", "nginx-0", "nginx"}},
+		{"c-1_local_default_p-1_~~x~_w_p_c", Identity{"c-1", "local", "default", "p-1", "__x_", "w", "p", "c"}},
+	}
+	for _, v := range cases {
+		got, err := DecodeLegacy(v.segment)
+		if err != nil {
+			t.Errorf("decode legacy %q failed, %v", v.segment, err)
+			continue
+		}
+		if got != v.want {
+			t.Errorf("decode legacy %q returned %+v, expected %+v", v.segment, got, v.want)
+		}
+	}
+
+	if i, err := DecodeLegacy("c-1_local_default"); err == nil {
+		t.Errorf("decode legacy of 3 fields returned %+v, expected an error", i)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+
+	"github.com/urfave/cli"
+
+	"github.com/rancher/log-aggregator/installer"
+)
+
+// locateFlags find the installed driver, installFlags change it.
+var locateFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "host-root",
+		Value: "/",
+		Usage: "where the host filesystem is mounted",
+	},
+	cli.StringFlag{
+		Name:  "plugin-dir",
+		Usage: "kubelet flexvolume plugin dir on the host, detected when empty",
+	},
+	cli.StringFlag{
+		Name:  "driver-name",
+		Value: installer.DefaultDriverName,
+		Usage: "vendor~driver dir name inside the plugin dir",
+	},
+}
+
+var installFlags = append(locateFlags, cli.BoolFlag{
+	Name:  "force",
+	Usage: "downgrade a newer driver on install, remove the driver with mounted volumes on uninstall",
+})
+
+func installOptions(c *cli.Context) installer.Options {
+	return installer.Options{
+		HostRoot:   c.String("host-root"),
+		PluginDir:  c.String("plugin-dir"),
+		DriverName: c.String("driver-name"),
+		Version:    VERSION,
+		Force:      c.Bool("force"),
+	}
+}
+
+func installSelf(c *cli.Context) (string, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return installer.Install(self, installOptions(c))
+}
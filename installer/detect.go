@@ -0,0 +1,117 @@
+package installer
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	LayoutFlag    = "kubelet-flag"
+	LayoutRKE     = "rke"
+	LayoutK3s     = "k3s"
+	LayoutGKE     = "gke"
+	LayoutKubeadm = "kubeadm"
+	LayoutDefault = "default"
+)
+
+const (
+	defaultPluginDir = "/usr/libexec/kubernetes/kubelet-plugins/volume/exec"
+	rkePluginDir     = "/var/lib/kubelet/volumeplugins"
+	gkePluginDir     = "/home/kubernetes/flexvolume"
+	volumePluginFlag = "volume-plugin-dir"
+)
+
+type Layout struct {
+	Name string
+	// PluginDir is the flexvolume plugin dir as seen by the host, not prefixed by the host root
+	PluginDir string
+}
+
+// layoutMarkers are checked in order, the first existing marker below the host root decides the layout.
+var layoutMarkers = []struct {
+	layout  Layout
+	markers []string
+}{
+	{Layout{LayoutRKE, rkePluginDir}, []string{rkePluginDir, "/etc/kubernetes/ssl/kube-node.pem"}},
+	{Layout{LayoutGKE, gkePluginDir}, []string{gkePluginDir, "/home/kubernetes/bin/kubelet"}},
+	{Layout{LayoutK3s, defaultPluginDir}, []string{"/var/lib/rancher/k3s"}},
+	{Layout{LayoutKubeadm, defaultPluginDir}, []string{"/etc/kubernetes/kubelet.conf"}},
+}
+
+// Detect finds the flexvolume plugin dir of the kubelet, the running kubelet flags win over the node layout.
+func Detect(hostRoot string) Layout {
+	if dir := pluginDirFromProcesses(hostRoot); dir != "" {
+		return Layout{Name: LayoutFlag, PluginDir: dir}
+	}
+
+	for _, v := range layoutMarkers {
+		for _, m := range v.markers {
+			if _, err := os.Stat(path.Join(hostRoot, m)); err == nil {
+				return v.layout
+			}
+		}
+	}
+	return Layout{Name: LayoutDefault, PluginDir: defaultPluginDir}
+}
+
+func pluginDirFromProcesses(hostRoot string) string {
+	cmdlines, err := filepath.Glob(path.Join(hostRoot, "proc", "*", "cmdline"))
+	if err != nil {
+		return ""
+	}
+
+	for _, v := range cmdlines {
+		b, err := ioutil.ReadFile(v)
+		if err != nil || len(b) == 0 {
+			continue
+		}
+		args := strings.Split(strings.TrimRight(string(b), "\x00"), "\x00")
+		if !isKubeletProcess(args) {
+			continue
+		}
+		if dir := volumePluginDirArg(args); dir != "" {
+			return dir
+		}
+	}
+	return ""
+}
+
+func isKubeletProcess(args []string) bool {
+	name := path.Base(args[0])
+	if name == "kubelet" || name == "k3s" {
+		return true
+	}
+	// hyperkube kubelet ...
+	return len(args) > 1 && args[1] == "kubelet"
+}
+
+// volumePluginDirArg handles --volume-plugin-dir[=| ]dir and the k3s --kubelet-arg[=| ]volume-plugin-dir=dir forms.
+func volumePluginDirArg(args []string) string {
+	for i, v := range args {
+		next := ""
+		if i+1 < len(args) {
+			next = args[i+1]
+		}
+
+		switch {
+		case strings.HasPrefix(v, "--"+volumePluginFlag+"="):
+			return strings.TrimPrefix(v, "--"+volumePluginFlag+"=")
+		case v == "--"+volumePluginFlag:
+			return next
+		case strings.HasPrefix(v, "--kubelet-arg="):
+			v = strings.TrimPrefix(v, "--kubelet-arg=")
+		case v == "--kubelet-arg":
+			v = next
+		default:
+			continue
+		}
+
+		if strings.HasPrefix(v, volumePluginFlag+"=") {
+			return strings.TrimPrefix(v, volumePluginFlag+"=")
+		}
+	}
+	return ""
+}
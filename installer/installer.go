@@ -0,0 +1,257 @@
+package installer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/rancher/log-aggregator/mountinfo"
+)
+
+const (
+	DefaultDriverName = "cattle.io~log-aggregator"
+	binaryName        = "log-aggregator"
+	versionFileName   = ".version"
+)
+
+type Options struct {
+	// HostRoot is where the host filesystem is mounted, "/" when running on the host
+	HostRoot string
+	// PluginDir overrides the detected flexvolume plugin dir, relative to the host
+	PluginDir  string
+	DriverName string
+	Version    string
+	Force      bool
+}
+
+type installedVersion struct {
+	Version  string `json:"version"`
+	Checksum string `json:"sha256"`
+}
+
+func (o Options) driverDir() (string, error) {
+	pluginDir := o.PluginDir
+	if pluginDir == "" {
+		pluginDir = Detect(o.HostRoot).PluginDir
+	}
+	if o.DriverName == "" || strings.Contains(o.DriverName, "/") {
+		return "", fmt.Errorf("invalid driver name %q", o.DriverName)
+	}
+	return path.Join(o.HostRoot, pluginDir, o.DriverName), nil
+}
+
+// Install copies src into <pluginDir>/<driverName>/log-aggregator, the kubelet only ever sees
+// a complete and verified binary since it is renamed into place. The version is recorded before the
+// rename, an install interrupted in between leaves a version whose checksum does not match the binary.
+func Install(src string, opts Options) (string, error) {
+	dir, err := opts.driverDir()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", errors.Wrapf(err, "create driver dir %s failed", dir)
+	}
+
+	dst := path.Join(dir, binaryName)
+	if installed, err := verifiedVersion(dir); err == nil && !opts.Force && isDowngrade(installed, opts.Version) {
+		return "", fmt.Errorf("refuse to downgrade %s from %s to %s, use --force to override", dst, installed, opts.Version)
+	}
+
+	checksum, err := fileChecksum(src)
+	if err != nil {
+		return "", err
+	}
+
+	tmp := path.Join(dir, "."+binaryName)
+	if err := copyExecutable(src, tmp); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	copied, err := fileChecksum(tmp)
+	if err != nil || copied != checksum {
+		os.Remove(tmp)
+		return "", fmt.Errorf("checksum of %s does not match %s after copy, %v", tmp, src, err)
+	}
+
+	if err := writeInstalledVersion(dir, installedVersion{Version: opts.Version, Checksum: checksum}); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return "", errors.Wrapf(err, "move driver to %s failed", dst)
+	}
+	return dst, nil
+}
+
+// Uninstall removes the driver dir, the kubelet could not unmount the remaining volumes without it.
+func Uninstall(opts Options) (string, error) {
+	dir, err := opts.driverDir()
+	if err != nil {
+		return "", err
+	}
+
+	if !opts.Force {
+		mounted, err := MountedVolumes(opts.HostRoot, opts.DriverName)
+		if err != nil {
+			return "", err
+		}
+		if len(mounted) != 0 {
+			return "", fmt.Errorf("refuse to uninstall, %d volumes still mounted, first one %s, use --force to override", len(mounted), mounted[0])
+		}
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return "", errors.Wrapf(err, "remove driver dir %s failed", dir)
+	}
+	return dir, nil
+}
+
+// Installation is the driver found in the plugin dir.
+type Installation struct {
+	Path    string
+	Version string
+	// Modified is set when the binary no longer matches the checksum recorded on install
+	Modified bool
+}
+
+func Installed(opts Options) (Installation, error) {
+	dir, err := opts.driverDir()
+	if err != nil {
+		return Installation{}, err
+	}
+
+	result := Installation{Path: path.Join(dir, binaryName)}
+	info, err := os.Stat(result.Path)
+	if err != nil {
+		return result, err
+	}
+	if info.Mode()&0111 == 0 {
+		return result, fmt.Errorf("driver %s is not executable", result.Path)
+	}
+
+	installed, err := readInstalledVersion(dir)
+	if err != nil {
+		return result, errors.Wrapf(err, "read version of %s failed", result.Path)
+	}
+	result.Version = installed.Version
+
+	checksum, err := fileChecksum(result.Path)
+	if err != nil {
+		return result, err
+	}
+	result.Modified = checksum != installed.Checksum
+	return result, nil
+}
+
+// MountTable returns the mounts of the host init process, or of this process when running on the host.
+func MountTable(hostRoot string) ([]mountinfo.Mount, error) {
+	mountInfoPath := path.Join(hostRoot, "proc/1/mountinfo")
+	if hostRoot == "" || hostRoot == "/" {
+		mountInfoPath = mountinfo.SelfPath
+	}
+
+	mounts, err := mountinfo.ParseFile(mountInfoPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read mount table %s failed", mountInfoPath)
+	}
+	return mounts, nil
+}
+
+// MountedVolumes returns the kubelet mount points of the driver, found in the mount table of the host init process.
+func MountedVolumes(hostRoot, driverName string) ([]string, error) {
+	mounts, err := MountTable(hostRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, m := range mounts {
+		if strings.Contains(m.MountPoint, "/volumes/"+driverName+"/") {
+			result = append(result, m.MountPoint)
+		}
+	}
+	return result, nil
+}
+
+// verifiedVersion returns the recorded version only while the binary still has the recorded checksum.
+func verifiedVersion(dir string) (string, error) {
+	installed, err := readInstalledVersion(dir)
+	if err != nil {
+		return "", err
+	}
+	checksum, err := fileChecksum(path.Join(dir, binaryName))
+	if err != nil {
+		return "", err
+	}
+	if checksum != installed.Checksum {
+		return "", fmt.Errorf("driver in %s does not match the recorded version %s", dir, installed.Version)
+	}
+	return installed.Version, nil
+}
+
+func writeInstalledVersion(dir string, v installedVersion) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := path.Join(dir, versionFileName+".tmp")
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		os.Remove(tmp)
+		return errors.Wrap(err, "write version file failed")
+	}
+	return errors.Wrap(os.Rename(tmp, path.Join(dir, versionFileName)), "write version file failed")
+}
+
+func readInstalledVersion(dir string) (installedVersion, error) {
+	var v installedVersion
+	b, err := ioutil.ReadFile(path.Join(dir, versionFileName))
+	if err != nil {
+		return v, err
+	}
+	err = json.Unmarshal(b, &v)
+	return v, err
+}
+
+func fileChecksum(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", errors.Wrapf(err, "read %s failed", file)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func copyExecutable(src, dst string) error {
+	from, err := os.Open(src)
+	if err != nil {
+		return errors.Wrapf(err, "open driver binary %s failed", src)
+	}
+	defer from.Close()
+
+	to, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+	if err != nil {
+		return errors.Wrapf(err, "create %s failed", dst)
+	}
+	defer to.Close()
+
+	if _, err = io.Copy(to, from); err != nil {
+		return errors.Wrapf(err, "copy driver binary to %s failed", dst)
+	}
+	return errors.Wrapf(to.Sync(), "sync %s failed", dst)
+}
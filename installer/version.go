@@ -0,0 +1,93 @@
+package installer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type version struct {
+	major, minor, patch int
+	pre                 string
+}
+
+// parseVersion accepts v1.2.3 and v1.2.3-rc.1, anything else like a git sha is not comparable.
+func parseVersion(s string) (version, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	var v version
+	// build metadata does not take part in the order
+	if i := strings.Index(s, "+"); i >= 0 {
+		s = s[:i]
+	}
+	if i := strings.Index(s, "-"); i >= 0 {
+		v.pre = s[i+1:]
+		s = s[:i]
+	}
+
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return v, fmt.Errorf("invalid version %s", s)
+	}
+	nums := make([]int, 3)
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return v, fmt.Errorf("invalid version %s", s)
+		}
+		nums[i] = n
+	}
+	v.major, v.minor, v.patch = nums[0], nums[1], nums[2]
+	return v, nil
+}
+
+func (v version) less(o version) bool {
+	if v.major != o.major {
+		return v.major < o.major
+	}
+	if v.minor != o.minor {
+		return v.minor < o.minor
+	}
+	if v.patch != o.patch {
+		return v.patch < o.patch
+	}
+	// a release is newer than its pre-releases
+	if v.pre == "" || o.pre == "" {
+		return v.pre != "" && o.pre == ""
+	}
+	return preReleaseLess(v.pre, o.pre)
+}
+
+// preReleaseLess compares the dot separated identifiers like semver does, numeric ones as numbers and
+// below alphanumeric ones, so rc.9 is older than rc.10.
+func preReleaseLess(a, b string) bool {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] == bs[i] {
+			continue
+		}
+		an, aErr := strconv.ParseUint(as[i], 10, 64)
+		bn, bErr := strconv.ParseUint(bs[i], 10, 64)
+		switch {
+		case aErr == nil && bErr == nil:
+			return an < bn
+		case aErr == nil || bErr == nil:
+			return aErr == nil
+		default:
+			return as[i] < bs[i]
+		}
+	}
+	return len(as) < len(bs)
+}
+
+// isDowngrade is only true when both versions are comparable and the installed one is newer.
+func isDowngrade(installed, candidate string) bool {
+	iv, err := parseVersion(installed)
+	if err != nil {
+		return false
+	}
+	cv, err := parseVersion(candidate)
+	if err != nil {
+		return false
+	}
+	return cv.less(iv)
+}
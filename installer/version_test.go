@@ -0,0 +1,46 @@
+package installer
+
+import "testing"
+
+func TestVersionOrder(t *testing.T) {
+	// every version is older than the next one
+	ordered := []string{
+		"v0.9.9",
+		"v1.0.0-alpha",
+		"v1.0.0-alpha.1",
+		"v1.0.0-alpha.beta",
+		"v1.0.0-beta",
+		"v1.0.0-beta.2",
+		"v1.0.0-beta.11",
+		"v1.0.0-rc.1",
+		"v1.0.0-rc.9",
+		"v1.0.0-rc.10",
+		"v1.0.0",
+		"v1.0.10",
+		"v1.2.0",
+	}
+	for i := 0; i+1 < len(ordered); i++ {
+		older, newer := ordered[i], ordered[i+1]
+		if !isDowngrade(newer, older) {
+			t.Errorf("installing %s over %s is not a downgrade", older, newer)
+		}
+		if isDowngrade(older, newer) {
+			t.Errorf("installing %s over %s is a downgrade", newer, older)
+		}
+	}
+}
+
+func TestVersionNotComparable(t *testing.T) {
+	cases := [][2]string{
+		{"v1.0.0", "v1.0.0"},
+		{"v1.0.0+build.2", "v1.0.0+build.1"},
+		{"v1.2.0", "3f2a9c1"},
+		{"dev", "v0.1.0"},
+		{"v1.2", "v1.1.0"},
+	}
+	for _, v := range cases {
+		if isDowngrade(v[0], v[1]) {
+			t.Errorf("installing %s over %s is a downgrade", v[1], v[0])
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rancher/log-aggregator/driver"
+	"github.com/rancher/log-aggregator/metrics"
+)
+
+func printLags(lags []driver.VolumeLag, output string) error {
+	now := time.Now()
+	switch output {
+	case "json":
+		for i := range lags {
+			lags[i].Files = nil
+		}
+		return printJSON(lags)
+	case "prometheus":
+		return metrics.Write(os.Stdout, driver.LagMetrics(lags, now))
+	case "table", "":
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "POD UID\tVOLUME\tFORMAT\tFILES\tUNREAD FILES\tUNREAD BYTES\tUNREAD IDLE")
+		for _, v := range lags {
+			idle := "-"
+			if !v.UnreadIdleSince.IsZero() {
+				idle = v.UnreadIdle(now).Truncate(time.Second).String()
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\t%s\n", v.PodUID, v.VolumeName, v.Format, len(v.Files), v.UnreadFiles, v.UnreadBytes, idle)
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unsupported output %s", output)
+	}
+}
@@ -0,0 +1,83 @@
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+const (
+	FieldCorrelationID = "cid"
+	FieldVerb          = "verb"
+	FieldPodUID        = "podUID"
+	FieldVolumeName    = "volumeName"
+	FieldDuration      = "duration"
+	FieldOutcome       = "outcome"
+	FieldReason        = "reason"
+)
+
+type Options struct {
+	File       string
+	Level      string
+	Format     string
+	MaxSize    int64
+	MaxBackups int
+}
+
+// New builds the driver logger. The kubelet parses everything the driver prints as its response, so
+// when the log file can't be opened New falls back to a file in the temp dir instead of stderr, and returns the error.
+func New(opts Options) (*logrus.Logger, error) {
+	logger := logrus.New()
+	logger.Out = os.Stderr
+
+	var optsErr error
+	level, err := logrus.ParseLevel(opts.Level)
+	if err != nil {
+		level = logrus.DebugLevel
+		optsErr = fmt.Errorf("invalid log level %s, use debug", opts.Level)
+	}
+	logger.Level = level
+
+	switch opts.Format {
+	case FormatJSON:
+		logger.Formatter = &logrus.JSONFormatter{}
+	case FormatText, "":
+		logger.Formatter = &logrus.TextFormatter{DisableColors: true}
+	default:
+		optsErr = fmt.Errorf("unsupported log format %s, use text", opts.Format)
+		logger.Formatter = &logrus.TextFormatter{DisableColors: true}
+	}
+
+	if opts.File == "" {
+		return logger, optsErr
+	}
+	file, err := OpenRotatingFile(opts.File, opts.MaxSize, opts.MaxBackups)
+	if err != nil {
+		logger.Out = ioutil.Discard
+		if fallback, fallbackErr := OpenRotatingFile(path.Join(os.TempDir(), path.Base(opts.File)), opts.MaxSize, opts.MaxBackups); fallbackErr == nil {
+			logger.Out = fallback
+		}
+		return logger, err
+	}
+	logger.Out = file
+	return logger, optsErr
+}
+
+// NewCorrelationID identifies the lines of one driver invocation, including the drainer it spawns.
+func NewCorrelationID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", os.Getpid())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,134 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// RotatingFile is an append only file rotated to file.1 ... file.N once it grows beyond maxSize.
+// The driver runs as many short lived processes at once, so the rotation itself is guarded by flock
+// and every process reopens the file when another one rotated it.
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	lock sync.Mutex
+	file *os.File
+}
+
+func OpenRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	r := &RotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	// the driver output is the response the kubelet reads, so a failed rotation is not reported
+	// anywhere and the line goes to the file still open
+	if r.maxSize > 0 {
+		r.rotateIfNeeded(int64(len(p)))
+	}
+	return r.file.Write(p)
+}
+
+func (r *RotatingFile) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.file.Close()
+}
+
+// open replaces the open file with the one at path, the previous one stays in use when that fails.
+func (r *RotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "open log file %s failed", r.path)
+	}
+	if r.file != nil {
+		r.file.Close()
+	}
+	r.file = file
+	return nil
+}
+
+func (r *RotatingFile) rotateIfNeeded(incoming int64) error {
+	if err := r.reopenIfRotated(); err != nil {
+		return err
+	}
+
+	info, err := r.file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size()+incoming <= r.maxSize {
+		return nil
+	}
+
+	if err := syscall.Flock(int(r.file.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	// another process may have rotated while we waited for the lock
+	rotated, err := r.isRotated()
+	if err == nil && !rotated {
+		err = r.shift()
+	}
+	syscall.Flock(int(r.file.Fd()), syscall.LOCK_UN)
+	if err != nil {
+		return err
+	}
+	return r.open()
+}
+
+func (r *RotatingFile) shift() error {
+	if r.maxBackups <= 0 {
+		return os.Truncate(r.path, 0)
+	}
+
+	os.Remove(fmt.Sprintf("%s.%d", r.path, r.maxBackups))
+	for i := r.maxBackups - 1; i > 0; i-- {
+		from := fmt.Sprintf("%s.%d", r.path, i)
+		if _, err := os.Stat(from); err == nil {
+			if err := os.Rename(from, fmt.Sprintf("%s.%d", r.path, i+1)); err != nil {
+				return err
+			}
+		}
+	}
+	return os.Rename(r.path, r.path+".1")
+}
+
+func (r *RotatingFile) reopenIfRotated() error {
+	rotated, err := r.isRotated()
+	if err != nil || !rotated {
+		return err
+	}
+	return r.open()
+}
+
+// isRotated reports whether the path no longer points to the open file.
+func (r *RotatingFile) isRotated() (bool, error) {
+	opened, err := r.file.Stat()
+	if err != nil {
+		return false, err
+	}
+	current, err := os.Stat(r.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	return !os.SameFile(opened, current), nil
+}
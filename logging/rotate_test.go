@@ -0,0 +1,73 @@
+package logging
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "driver.log")
+
+	r, err := OpenRotatingFile(file, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n"} {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, want := range map[string]string{"driver.log": "four\n", "driver.log.1": "three\n", "driver.log.2": "one\ntwo\n"} {
+		got, err := ioutil.ReadFile(path.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s holds %q, expected %q", name, got, want)
+		}
+	}
+}
+
+func TestRotatingFileKeepsWritingWhenReopenFails(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "driver.log")
+
+	r, err := OpenRotatingFile(file, 1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// another process rotated the file and something that can not be opened took its place
+	if err := os.Rename(file, path.Join(dir, "moved.log")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(file, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := r.Write([]byte("line\n")); err != nil {
+			t.Fatalf("write %d failed, %v", i, err)
+		}
+	}
+	got, err := ioutil.ReadFile(path.Join(dir, "moved.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "line\nline\n" {
+		t.Errorf("the open file holds %q", got)
+	}
+}
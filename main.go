@@ -1,73 +1,393 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/rancher/log-aggregator/config"
+	"github.com/rancher/log-aggregator/daemon"
 	"github.com/rancher/log-aggregator/driver"
+	"github.com/rancher/log-aggregator/installer"
+	"github.com/rancher/log-aggregator/logging"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 )
 
 var VERSION = "v0.0.0-dev"
-var logFileName = "/var/log/rancher-flexvolume.log"
 
-func setLog(file *os.File) *logrus.Logger {
-	log := logrus.New()
-	log.Out = file
-	log.Level = logrus.DebugLevel
-	return log
+func setLog(conf config.LogConfig) (*logrus.Logger, error) {
+	return logging.New(logging.Options{
+		File:       conf.File,
+		Level:      conf.Level,
+		Format:     conf.Format,
+		MaxSize:    int64(conf.MaxSize),
+		MaxBackups: conf.MaxBackups,
+	})
 }
 
 func main() {
-	file, err := os.OpenFile(logFileName, os.O_CREATE|os.O_APPEND|os.O_RDWR, os.FileMode(0644))
+	conf, confErr := config.Load(config.DefaultPath)
+	logger, err := setLog(conf.Log)
 	if err != nil {
-		logrus.Errorf("unable to open file %s: %s", logFileName, err)
+		logger.Errorf("set up driver log failed, %v", err)
+	}
+	if confErr != nil {
+		logger.Warnf("load config failed, use defaults, %v", confErr)
 	}
-
-	defer file.Close()
-	logger := setLog(file)
 
 	app := cli.NewApp()
 	app.Name = "log-aggregator"
 	app.Version = VERSION
 	app.Usage = "local-flexvolme driver to mount log to workload logging path"
 
-	app.Commands = getCommand(logger)
-	app.Run(os.Args)
+	app.Commands = getCommand(logger, conf)
+	if err := app.Run(os.Args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
 
-func getCommand(logger *logrus.Logger) []cli.Command {
-	flexVolumeDriver := driver.FlexVolumeDriver{
-		Logger: logger,
+// newDriver tags every line of one kubelet invocation with a correlation id and the verb.
+func newDriver(logger *logrus.Logger, conf *config.Config, verb string) *driver.FlexVolumeDriver {
+	return &driver.FlexVolumeDriver{
+		Logger: logger.WithFields(logrus.Fields{
+			logging.FieldCorrelationID: logging.NewCorrelationID(),
+			logging.FieldVerb:          verb,
+		}),
+		Config: conf,
 	}
+}
+
+func getCommand(logger *logrus.Logger, conf *config.Config) []cli.Command {
 	return []cli.Command{
 		{
 			Name:  "init",
 			Usage: "init func",
 			Action: func(c *cli.Context) error {
-				logger.Info("init function call")
+				flexVolumeDriver := newDriver(logger, conf, "init")
+				flexVolumeDriver.Logger.Info("init function call")
 				return printResponse(flexVolumeDriver.Init())
 			},
 		},
 		{
 			Name:  "mount",
 			Usage: "mount func",
+			Flags: []cli.Flag{dryRunFlag},
 			Action: func(c *cli.Context) error {
-				return printResponse(flexVolumeDriver.Mount(c.Args()))
+				if c.Bool("dry-run") {
+					return printPlan(newDriver(logger, conf, "mount").PlanMount(c.Args()))
+				}
+				return printResponse(newDriver(logger, conf, "mount").Mount(c.Args()))
 			},
 		},
 		{
 			Name:  "unmount",
 			Usage: "unmount func",
+			Flags: []cli.Flag{dryRunFlag},
+			Action: func(c *cli.Context) error {
+				if c.Bool("dry-run") {
+					return printPlan(newDriver(logger, conf, "unmount").PlanUnmount(c.Args()))
+				}
+				return printResponse(newDriver(logger, conf, "unmount").Unmount(c.Args()))
+			},
+		},
+		{
+			Name:  "plan",
+			Usage: "print what mount or unmount would do with the given kubelet args, without changing anything",
+			Subcommands: []cli.Command{
+				{
+					Name:      "mount",
+					ArgsUsage: "<mount dir> <json options>",
+					Action: func(c *cli.Context) error {
+						return printPlan(newDriver(logger, conf, "mount").PlanMount(c.Args()))
+					},
+				},
+				{
+					Name:      "unmount",
+					ArgsUsage: "<mount dir>",
+					Action: func(c *cli.Context) error {
+						return printPlan(newDriver(logger, conf, "unmount").PlanUnmount(c.Args()))
+					},
+				},
+			},
+		},
+		{
+			Name:      "drain",
+			Usage:     "wait until fluentd read the unmounted volumes, then clean them up",
+			ArgsUsage: "[podUID_volumeName...]",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "correlation-id",
+					Usage: "correlation id of the unmount call handing over the volumes",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				entry := logger.WithField(logging.FieldVerb, "drain")
+				if cid := c.String("correlation-id"); cid != "" {
+					entry = entry.WithField(logging.FieldCorrelationID, cid)
+				}
+				drainer := driver.Drainer{
+					Logger:   entry,
+					Interval: conf.DrainInterval.Duration,
+				}
+				return drainer.Run(c.Args())
+			},
+		},
+		{
+			Name:  "lag",
+			Usage: "show how far fluentd is behind for every log volume",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "output, o",
+					Value: "table",
+					Usage: "output format, one of table, json, prometheus",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				lags, err := driver.ListLags()
+				if err != nil {
+					return err
+				}
+				return printLags(lags, c.String("output"))
+			},
+		},
+		{
+			Name:  "list",
+			Usage: "list the log volumes on this node",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "output, o",
+					Value: "table",
+					Usage: "output format, one of table, json",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				volumes, err := driver.ListVolumes()
+				if err != nil {
+					return err
+				}
+				return printVolumes(volumes, c.String("output"))
+			},
+		},
+		{
+			Name:      "inspect",
+			Usage:     "show everything the driver knows about one log volume",
+			ArgsUsage: "<podUID>/<volumeName>",
+			Action: func(c *cli.Context) error {
+				if c.NArg() != 1 {
+					return fmt.Errorf("inspect expects exactly one <podUID>/<volumeName> argument")
+				}
+				podUID, volumeName, err := parseVolumeRef(c.Args().First())
+				if err != nil {
+					return err
+				}
+				info, err := driver.InspectVolume(podUID, volumeName)
+				if err != nil {
+					return err
+				}
+				return printJSON(info)
+			},
+		},
+		{
+			Name:  "migrate",
+			Usage: "write the metadata sidecar for volumes created by earlier versions",
+			Action: func(c *cli.Context) error {
+				logger.Out = os.Stderr
+				migrated, err := driver.MigrateVolumes(logrus.NewEntry(logger))
+				if err != nil {
+					return err
+				}
+				logger.Infof("migrated %d volumes", migrated)
+				return nil
+			},
+		},
+		{
+			Name:  "regenerate",
+			Usage: "render the configs of all mounted volumes again, publish the changed ones and reload fluentd once",
+			Flags: []cli.Flag{
+				dryRunFlag,
+				cli.BoolFlag{
+					Name:  "no-reload",
+					Usage: "publish the changed configs without reloading fluentd",
+				},
+			},
 			Action: func(c *cli.Context) error {
-				return printResponse(flexVolumeDriver.Unmount(c.Args()))
+				logger.Out = os.Stderr
+				regenerated, err := newDriver(logger, conf, "regenerate").Regenerate(c.Bool("dry-run"))
+				if err != nil {
+					return err
+				}
+				if err := printJSON(regenerated); err != nil {
+					return err
+				}
+
+				var changed int
+				for _, v := range regenerated {
+					changed += len(v.Changed)
+				}
+				if changed == 0 || c.Bool("dry-run") || c.Bool("no-reload") {
+					return nil
+				}
+				pids, err := driver.ReloadFluentd()
+				if err != nil {
+					return fmt.Errorf("%d configs changed but fluentd was not reloaded, %v", changed, err)
+				}
+				logger.Infof("%d configs changed, reloaded fluentd %v", changed, pids)
+				return nil
+			},
+		},
+		{
+			Name:  "verify",
+			Usage: "cross check generated configs, host dirs, mounts, kubelet pods and pos files",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "repair",
+					Usage: "apply the safe fixes and reload fluentd when configs changed",
+				},
+				cli.StringFlag{
+					Name:  "output, o",
+					Value: "table",
+					Usage: "output format, one of table, json",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				logger.Out = os.Stderr
+				issues, err := newDriver(logger, conf, "verify").Verify(c.Bool("repair"))
+				if err != nil {
+					return err
+				}
+				if err := printIssues(issues, c.String("output")); err != nil {
+					return err
+				}
+
+				var remaining, changed int
+				for _, v := range issues {
+					if !v.Repaired {
+						remaining++
+					}
+					if v.ChangesConfig() {
+						changed++
+					}
+				}
+				if changed != 0 {
+					pids, err := driver.ReloadFluentd()
+					if err != nil {
+						return fmt.Errorf("repaired %d configs but fluentd was not reloaded, %v", changed, err)
+					}
+					logger.Infof("repaired %d configs, reloaded fluentd %v", changed, pids)
+				}
+				if remaining != 0 {
+					return fmt.Errorf("%d of %d issues remain", remaining, len(issues))
+				}
+				return nil
+			},
+		},
+		{
+			Name:      "preview",
+			Usage:     "validate a format like mount does, show its config and parse sample lines with it",
+			ArgsUsage: "<file|->",
+			Flags:     previewFlags,
+			Action: func(c *cli.Context) error {
+				logger.Out = os.Stderr
+				return runPreview(c)
+			},
+		},
+		{
+			Name:  "doctor",
+			Usage: "check the node for driver, directory and mount problems",
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "output, o",
+					Value: "table",
+					Usage: "output format, one of table, json",
+				},
+			}, locateFlags...),
+			Action: func(c *cli.Context) error {
+				checks := newDriver(logger, conf, "doctor").Diagnose(installOptions(c))
+				return printChecks(checks, c.String("output"))
+			},
+		},
+		{
+			Name:  "install",
+			Usage: "install the driver into the kubelet flexvolume plugin dir",
+			Flags: installFlags,
+			Action: func(c *cli.Context) error {
+				logger.Out = os.Stderr
+				layout := installer.Detect(c.String("host-root"))
+				logger.Infof("detected %s layout with plugin dir %s", layout.Name, layout.PluginDir)
+				dst, err := installSelf(c)
+				if err != nil {
+					return err
+				}
+				logger.Infof("driver %s installed to %s", VERSION, dst)
+				return nil
+			},
+		},
+		{
+			Name:  "uninstall",
+			Usage: "remove the driver from the kubelet flexvolume plugin dir",
+			Flags: installFlags,
+			Action: func(c *cli.Context) error {
+				logger.Out = os.Stderr
+				dir, err := installer.Uninstall(installOptions(c))
+				if err != nil {
+					return err
+				}
+				logger.Infof("driver removed from %s", dir)
+				return nil
+			},
+		},
+		{
+			Name:  "daemon",
+			Usage: "install the driver, then run the node level housekeeping until SIGTERM",
+			Flags: append([]cli.Flag{
+				cli.BoolFlag{
+					Name:  "skip-install",
+					Usage: "run without installing the driver",
+				},
+			}, installFlags...),
+			Action: func(c *cli.Context) error {
+				logger.Out = os.Stderr
+				if !c.Bool("skip-install") {
+					dst, err := installSelf(c)
+					if err != nil {
+						return err
+					}
+					logger.Infof("driver %s installed to %s", VERSION, dst)
+				}
+
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				signals := make(chan os.Signal, 1)
+				signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+				go func() {
+					sig := <-signals
+					logger.Infof("received %s, shutting down", sig)
+					cancel()
+				}()
+
+				return daemon.New(logger, conf).Run(ctx)
 			},
 		},
 	}
 }
 
+var dryRunFlag = cli.BoolFlag{
+	Name:  "dry-run",
+	Usage: "print the plan as JSON instead of changing anything",
+}
+
+func printPlan(plan *driver.Plan, err error) error {
+	if err != nil {
+		return err
+	}
+	return printJSON(plan)
+}
+
 func printResponse(resp interface{}) error {
 	output, err := json.Marshal(resp)
 	if err != nil {
@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	TypeGauge     = "gauge"
+	TypeCounter   = "counter"
+	TypeHistogram = "histogram"
+)
+
+type Metric struct {
+	Labels map[string]string
+	Value  float64
+	// Histogram is rendered instead of Value for families of TypeHistogram
+	Histogram *Histogram
+}
+
+type Family struct {
+	Name    string
+	Help    string
+	Type    string
+	Metrics []Metric
+}
+
+// Write renders the families in the Prometheus text exposition format.
+func Write(w io.Writer, families []Family) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		fmt.Fprintf(bw, "# HELP %s %s\n", f.Name, escapeHelp(f.Help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", f.Name, f.Type)
+		for _, m := range f.Metrics {
+			if m.Histogram != nil {
+				writeHistogram(bw, f.Name, m)
+				continue
+			}
+			fmt.Fprintf(bw, "%s%s %s\n", f.Name, formatLabels(m.Labels), formatFloat(m.Value))
+		}
+	}
+	return bw.Flush()
+}
+
+func writeHistogram(w io.Writer, name string, m Metric) {
+	var cumulative uint64
+	for i, upper := range m.Histogram.Buckets {
+		if i < len(m.Histogram.Counts) {
+			cumulative += m.Histogram.Counts[i]
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, formatLabels(withLabel(m.Labels, "le", formatFloat(upper))), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket%s %d\n", name, formatLabels(withLabel(m.Labels, "le", "+Inf")), m.Histogram.Count)
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, formatLabels(m.Labels), formatFloat(m.Histogram.Sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, formatLabels(m.Labels), m.Histogram.Count)
+}
+
+func withLabel(labels map[string]string, key, value string) map[string]string {
+	result := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		result[k] = v
+	}
+	result[key] = value
+	return result
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", k, escapeLabel(labels[k])))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var (
+	labelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpReplacer  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string {
+	return labelReplacer.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+// Histogram keeps non cumulative bucket counts so it can be stored and merged as plain JSON.
+type Histogram struct {
+	Buckets []float64 `json:"buckets"`
+	Counts  []uint64  `json:"counts"`
+	Sum     float64   `json:"sum"`
+	Count   uint64    `json:"count"`
+}
+
+func NewHistogram(buckets []float64) *Histogram {
+	return &Histogram{
+		Buckets: buckets,
+		Counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (h *Histogram) Observe(v float64) {
+	for i, upper := range h.Buckets {
+		if v <= upper {
+			h.Counts[i]++
+			break
+		}
+	}
+	h.Sum += v
+	h.Count++
+}
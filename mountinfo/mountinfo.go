@@ -0,0 +1,115 @@
+package mountinfo
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const SelfPath = "/proc/self/mountinfo"
+
+type Mount struct {
+	ID         int
+	ParentID   int
+	Root       string
+	MountPoint string
+	Options    string
+	// Optional holds the propagation fields like shared:1 or master:2
+	Optional []string
+	FSType   string
+	Source   string
+}
+
+func (m Mount) Shared() bool {
+	return hasOptionalPrefix(m.Optional, "shared:")
+}
+
+func (m Mount) Slave() bool {
+	return hasOptionalPrefix(m.Optional, "master:")
+}
+
+func hasOptionalPrefix(fields []string, prefix string) bool {
+	for _, v := range fields {
+		if strings.HasPrefix(v, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse reads the format of proc(5) mountinfo.
+func Parse(r io.Reader) ([]Mount, error) {
+	var mounts []Mount
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		text := scanner.Text()
+		if text == "" {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		sep := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep < 0 || len(fields) < sep+3 {
+			return nil, fmt.Errorf("invalid mountinfo line %q", text)
+		}
+
+		id, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid mount id in %q", text)
+		}
+		parentID, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid parent id in %q", text)
+		}
+
+		mounts = append(mounts, Mount{
+			ID:         id,
+			ParentID:   parentID,
+			Root:       unescape(fields[3]),
+			MountPoint: unescape(fields[4]),
+			Options:    fields[5],
+			Optional:   fields[6:sep],
+			FSType:     fields[sep+1],
+			Source:     unescape(fields[sep+2]),
+		})
+	}
+	return mounts, scanner.Err()
+}
+
+func ParseFile(file string) ([]Mount, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// unescape reverses the octal escaping of space, tab, newline and backslash.
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b = append(b, byte(v))
+				i += 3
+				continue
+			}
+		}
+		b = append(b, s[i])
+	}
+	return string(b)
+}
@@ -0,0 +1,427 @@
+// Package msgpack implements the part of MessagePack the fluentd forward protocol uses.
+package msgpack
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// EventTimeType is the extension type of the fluentd EventTime, seconds and nanoseconds as two uint32.
+const EventTimeType = 0
+
+type Ext struct {
+	Type int8
+	Data []byte
+}
+
+func EventTime(t time.Time) Ext {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint32(data, uint32(t.Unix()))
+	binary.BigEndian.PutUint32(data[4:], uint32(t.Nanosecond()))
+	return Ext{Type: EventTimeType, Data: data}
+}
+
+// Time decodes an EventTime or the integer seconds older fluentd versions send.
+func Time(v interface{}) (time.Time, error) {
+	switch t := v.(type) {
+	case Ext:
+		if t.Type != EventTimeType || len(t.Data) != 8 {
+			return time.Time{}, fmt.Errorf("ext type %d of %d bytes is no event time", t.Type, len(t.Data))
+		}
+		return time.Unix(int64(binary.BigEndian.Uint32(t.Data)), int64(binary.BigEndian.Uint32(t.Data[4:]))), nil
+	case int64:
+		return time.Unix(t, 0), nil
+	case uint64:
+		return time.Unix(int64(t), 0), nil
+	default:
+		return time.Time{}, fmt.Errorf("%T is no event time", v)
+	}
+}
+
+// Append encodes v to b. Maps are written with sorted keys so equal values encode equally.
+func Append(b []byte, v interface{}) ([]byte, error) {
+	switch t := v.(type) {
+	case nil:
+		return append(b, 0xc0), nil
+	case bool:
+		if t {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+	case int:
+		return appendInt(b, int64(t)), nil
+	case int64:
+		return appendInt(b, t), nil
+	case uint64:
+		if t <= math.MaxInt64 {
+			return appendInt(b, int64(t)), nil
+		}
+		return append(append(b, 0xcf), uint64Bytes(t)...), nil
+	case float64:
+		return append(append(b, 0xcb), uint64Bytes(math.Float64bits(t))...), nil
+	case string:
+		return appendString(b, t), nil
+	case []byte:
+		return appendBin(b, t), nil
+	case Ext:
+		return appendExt(b, t), nil
+	case []interface{}:
+		b = appendArrayHeader(b, len(t))
+		for _, e := range t {
+			var err error
+			if b, err = Append(b, e); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]interface{}:
+		b = appendMapHeader(b, len(t))
+		for _, k := range sortedKeys(t) {
+			var err error
+			b = appendString(b, k)
+			if b, err = Append(b, t[k]); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]string:
+		m := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			m[k] = v
+		}
+		return Append(b, m)
+	default:
+		return nil, fmt.Errorf("msgpack: unsupported type %T", v)
+	}
+}
+
+func Marshal(v interface{}) ([]byte, error) {
+	return Append(nil, v)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func uint64Bytes(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+func appendInt(b []byte, v int64) []byte {
+	switch {
+	case v >= 0 && v <= 0x7f:
+		return append(b, byte(v))
+	case v < 0 && v >= -32:
+		return append(b, byte(v))
+	case v >= math.MinInt8 && v <= math.MaxInt8:
+		return append(b, 0xd0, byte(v))
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		return append(b, 0xd1, byte(v>>8), byte(v))
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		return append(b, 0xd2, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	default:
+		return append(append(b, 0xd3), uint64Bytes(uint64(v))...)
+	}
+}
+
+func appendString(b []byte, s string) []byte {
+	n := len(s)
+	switch {
+	case n <= 31:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = append(b, 0xda, byte(n>>8), byte(n))
+	default:
+		b = append(b, 0xdb, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(b, s...)
+}
+
+func appendBin(b []byte, data []byte) []byte {
+	n := len(data)
+	switch {
+	case n <= math.MaxUint8:
+		b = append(b, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		b = append(b, 0xc5, byte(n>>8), byte(n))
+	default:
+		b = append(b, 0xc6, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(b, data...)
+}
+
+func appendExt(b []byte, e Ext) []byte {
+	n := len(e.Data)
+	switch n {
+	case 1:
+		b = append(b, 0xd4)
+	case 2:
+		b = append(b, 0xd5)
+	case 4:
+		b = append(b, 0xd6)
+	case 8:
+		b = append(b, 0xd7)
+	case 16:
+		b = append(b, 0xd8)
+	default:
+		switch {
+		case n <= math.MaxUint8:
+			b = append(b, 0xc7, byte(n))
+		case n <= math.MaxUint16:
+			b = append(b, 0xc8, byte(n>>8), byte(n))
+		default:
+			b = append(b, 0xc9, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+		}
+	}
+	return append(append(b, byte(e.Type)), e.Data...)
+}
+
+func appendArrayHeader(b []byte, n int) []byte {
+	switch {
+	case n <= 15:
+		return append(b, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		return append(b, 0xdc, byte(n>>8), byte(n))
+	default:
+		return append(b, 0xdd, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+}
+
+func appendMapHeader(b []byte, n int) []byte {
+	switch {
+	case n <= 15:
+		return append(b, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		return append(b, 0xde, byte(n>>8), byte(n))
+	default:
+		return append(b, 0xdf, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+}
+
+// Decoder reads values one at a time, integers decode to int64 or uint64 above MaxInt64, maps with
+// string keys to map[string]interface{} and others to map[interface{}]interface{}.
+type Decoder struct {
+	// MaxLength bounds strings, binaries and the element count of arrays and maps, for untrusted
+	// input, unlimited when 0
+	MaxLength int
+
+	r *bufio.Reader
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	if br, ok := r.(*bufio.Reader); ok {
+		return &Decoder{r: br}
+	}
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+func Unmarshal(b []byte) (interface{}, error) {
+	return NewDecoder(bytes.NewReader(b)).Decode()
+}
+
+func (d *Decoder) Decode() (interface{}, error) {
+	c, err := d.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return d.decodeMap(int(c & 0x0f))
+	case c&0xf0 == 0x90:
+		return d.decodeArray(int(c & 0x0f))
+	case c&0xe0 == 0xa0:
+		return d.readString(int(c & 0x1f))
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.readLength(c - 0xc4)
+		if err != nil {
+			return nil, err
+		}
+		return d.readBytes(n)
+	case 0xc7, 0xc8, 0xc9:
+		n, err := d.readLength(c - 0xc7)
+		if err != nil {
+			return nil, err
+		}
+		return d.readExt(n)
+	case 0xca:
+		b, err := d.readBytes(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case 0xcb:
+		b, err := d.readBytes(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		b, err := d.readBytes(1 << (c - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		v := readUint(b)
+		if v > math.MaxInt64 {
+			return v, nil
+		}
+		return int64(v), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		b, err := d.readBytes(size)
+		if err != nil {
+			return nil, err
+		}
+		v := readUint(b)
+		shift := uint(64 - 8*size)
+		return int64(v<<shift) >> shift, nil
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return d.readExt(1 << (c - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.readLength(c - 0xd9)
+		if err != nil {
+			return nil, err
+		}
+		return d.readString(n)
+	case 0xdc, 0xdd:
+		n, err := d.readLength(c - 0xdc + 1)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeArray(n)
+	case 0xde, 0xdf:
+		n, err := d.readLength(c - 0xde + 1)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeMap(n)
+	}
+	return nil, fmt.Errorf("msgpack: unsupported format 0x%x", c)
+}
+
+// readLength reads a length of 1, 2 or 4 bytes for size 0, 1 and 2.
+func (d *Decoder) readLength(size byte) (int, error) {
+	b, err := d.readBytes(1 << size)
+	if err != nil {
+		return 0, err
+	}
+	n := int(readUint(b))
+	if d.MaxLength > 0 && n > d.MaxLength {
+		return 0, fmt.Errorf("msgpack: length %d exceeds %d", n, d.MaxLength)
+	}
+	return n, nil
+}
+
+func readUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+func (d *Decoder) readBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (d *Decoder) readString(n int) (string, error) {
+	b, err := d.readBytes(n)
+	return string(b), err
+}
+
+func (d *Decoder) readExt(n int) (Ext, error) {
+	t, err := d.r.ReadByte()
+	if err != nil {
+		return Ext{}, err
+	}
+	data, err := d.readBytes(n)
+	return Ext{Type: int8(t), Data: data}, err
+}
+
+func (d *Decoder) decodeArray(n int) ([]interface{}, error) {
+	result := make([]interface{}, n)
+	for i := range result {
+		v, err := d.Decode()
+		if err != nil {
+			return nil, err
+		}
+		result[i] = v
+	}
+	return result, nil
+}
+
+func (d *Decoder) decodeMap(n int) (interface{}, error) {
+	keys := make([]interface{}, n)
+	values := make([]interface{}, n)
+	stringKeys := true
+	for i := 0; i < n; i++ {
+		k, err := d.Decode()
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.Decode()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := k.(string); !ok {
+			stringKeys = false
+		}
+		keys[i], values[i] = k, v
+	}
+
+	if stringKeys {
+		result := make(map[string]interface{}, n)
+		for i, k := range keys {
+			result[k.(string)] = values[i]
+		}
+		return result, nil
+	}
+	result := make(map[interface{}]interface{}, n)
+	for i, k := range keys {
+		if !isHashable(k) {
+			return nil, fmt.Errorf("msgpack: unsupported map key %T", k)
+		}
+		result[k] = values[i]
+	}
+	return result, nil
+}
+
+func isHashable(v interface{}) bool {
+	switch v.(type) {
+	case []interface{}, []byte, map[string]interface{}, map[interface{}]interface{}, Ext:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,152 @@
+package output
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/rancher/log-aggregator/msgpack"
+)
+
+const (
+	chunkExt = ".chunk"
+	// tmpExt keeps chunks being written out of the chunk glob until they are complete
+	tmpExt = ".tmp"
+
+	staleTmpAge = time.Hour
+)
+
+var ErrBufferFull = errors.New("output buffer is full")
+
+// Chunk is a batch of encoded records, the encoding is up to the output that put it.
+type Chunk struct {
+	ID    []byte
+	Tag   string
+	Count int
+	Data  []byte
+
+	file string
+}
+
+// Buffer keeps chunks on disk until the destination accepted them, so records survive restarts and
+// outages of the destination. Once Limit bytes are buffered Put fails and the tailer holds back.
+type Buffer struct {
+	Dir   string
+	Limit int64
+}
+
+func (b *Buffer) Put(tag string, count int, data []byte) error {
+	if err := os.MkdirAll(b.Dir, os.ModePerm); err != nil {
+		return errors.Wrapf(err, "create buffer dir %s failed", b.Dir)
+	}
+
+	if b.Limit > 0 {
+		size, err := b.Size()
+		if err != nil {
+			return err
+		}
+		if size+int64(len(data)) > b.Limit {
+			return ErrBufferFull
+		}
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	content, err := msgpack.Marshal([]interface{}{id, tag, count, data})
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%020d-%s%s", time.Now().UnixNano(), hex.EncodeToString(id), chunkExt)
+	tmp := path.Join(b.Dir, name+tmpExt)
+	if err := ioutil.WriteFile(tmp, content, 0600); err != nil {
+		os.Remove(tmp)
+		return errors.Wrapf(err, "write chunk %s failed", tmp)
+	}
+	return errors.Wrapf(os.Rename(tmp, path.Join(b.Dir, name)), "rename chunk %s failed", name)
+}
+
+// Chunks returns the files of the buffered chunks oldest first, Read loads them one at a time so a
+// flush never holds more than a batch in memory. Chunks a crash left half written are removed.
+func (b *Buffer) Chunks() ([]string, error) {
+	tmps, err := filepath.Glob(path.Join(b.Dir, "*"+chunkExt+tmpExt))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range tmps {
+		if info, err := os.Stat(file); err == nil && time.Since(info.ModTime()) > staleTmpAge {
+			os.Remove(file)
+		}
+	}
+
+	files, err := filepath.Glob(path.Join(b.Dir, "*"+chunkExt))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Read loads a chunk file Chunks returned, an unreadable chunk is removed.
+func (b *Buffer) Read(file string) (Chunk, error) {
+	c, err := readChunk(file)
+	if err != nil && !os.IsNotExist(err) {
+		os.Remove(file)
+	}
+	return c, err
+}
+
+func readChunk(file string) (Chunk, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return Chunk{}, err
+	}
+	v, err := msgpack.Unmarshal(content)
+	if err != nil {
+		return Chunk{}, err
+	}
+
+	fields, ok := v.([]interface{})
+	if !ok || len(fields) != 4 {
+		return Chunk{}, fmt.Errorf("invalid chunk %s", file)
+	}
+	id, okID := fields[0].([]byte)
+	tag, okTag := fields[1].(string)
+	count, okCount := fields[2].(int64)
+	data, okData := fields[3].([]byte)
+	if !okID || !okTag || !okCount || !okData {
+		return Chunk{}, fmt.Errorf("invalid chunk %s", file)
+	}
+	return Chunk{ID: id, Tag: tag, Count: int(count), Data: data, file: file}, nil
+}
+
+func (b *Buffer) Remove(c Chunk) error {
+	if err := os.Remove(c.file); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (b *Buffer) Size() (int64, error) {
+	files, err := filepath.Glob(path.Join(b.Dir, "*"+chunkExt))
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			size += info.Size()
+		}
+	}
+	return size, nil
+}
@@ -0,0 +1,225 @@
+package output
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func tempBuffer(t *testing.T, limit int64) (*Buffer, func()) {
+	dir, err := ioutil.TempDir("", "buffer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Buffer{Dir: path.Join(dir, "chunks"), Limit: limit}, func() { os.RemoveAll(dir) }
+}
+
+func readAll(t *testing.T, b *Buffer) []Chunk {
+	files, err := b.Chunks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result []Chunk
+	for _, v := range files {
+		c, err := b.Read(v)
+		if err != nil {
+			continue
+		}
+		result = append(result, c)
+	}
+	return result
+}
+
+func TestBufferPutRead(t *testing.T) {
+	b, cleanup := tempBuffer(t, 0)
+	defer cleanup()
+
+	for i, tag := range []string{"a", "b", "c"} {
+		if err := b.Put(tag, i+1, []byte(tag+tag)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	chunks := readAll(t, b)
+	if len(chunks) != 3 {
+		t.Fatalf("expected 3 chunks, got %d", len(chunks))
+	}
+	for i, tag := range []string{"a", "b", "c"} {
+		c := chunks[i]
+		if c.Tag != tag || c.Count != i+1 || string(c.Data) != tag+tag || len(c.ID) != 16 {
+			t.Errorf("chunk %d is %+v, expected tag %s in put order", i, c, tag)
+		}
+	}
+	if bytes.Equal(chunks[0].ID, chunks[1].ID) {
+		t.Errorf("chunks share the id %x", chunks[0].ID)
+	}
+
+	if err := b.Remove(chunks[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Remove(chunks[0]); err != nil {
+		t.Errorf("removing a chunk twice failed, %v", err)
+	}
+	if chunks = readAll(t, b); len(chunks) != 2 {
+		t.Errorf("expected 2 chunks after remove, got %d", len(chunks))
+	}
+}
+
+func TestBufferLimit(t *testing.T) {
+	b, cleanup := tempBuffer(t, 200)
+	defer cleanup()
+
+	if err := b.Put("a", 1, make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Put("a", 1, make([]byte, 100)); err != ErrBufferFull {
+		t.Errorf("put beyond the limit returned %v, expected ErrBufferFull", err)
+	}
+	size, err := b.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size == 0 || size > 200 {
+		t.Errorf("buffer size is %d", size)
+	}
+}
+
+func TestBufferSkipsChunksBeingWritten(t *testing.T) {
+	b, cleanup := tempBuffer(t, 0)
+	defer cleanup()
+
+	if err := b.Put("a", 1, []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	// a Put in progress on another goroutine, and one a crash left behind long ago
+	writing := path.Join(b.Dir, "00000000000000000001-00"+chunkExt+tmpExt)
+	stale := path.Join(b.Dir, "00000000000000000002-00"+chunkExt+tmpExt)
+	for _, v := range []string{writing, stale} {
+		if err := ioutil.WriteFile(v, []byte{0x94}, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-2 * staleTmpAge)
+	if err := os.Chtimes(stale, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	size, err := b.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := readAll(t, b)
+	if len(chunks) != 1 || chunks[0].Tag != "a" {
+		t.Errorf("expected only the complete chunk, got %+v", chunks)
+	}
+	if size != int64(len(mustReadFile(t, chunks[0].file))) {
+		t.Errorf("buffer size %d counts the chunks being written", size)
+	}
+	if _, err := os.Stat(writing); err != nil {
+		t.Errorf("the chunk being written was touched, %v", err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("the stale temp chunk was not removed, %v", err)
+	}
+}
+
+func TestBufferRemovesCorruptChunks(t *testing.T) {
+	b, cleanup := tempBuffer(t, 0)
+	defer cleanup()
+
+	if err := b.Put("a", 1, []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	corrupt := path.Join(b.Dir, "00000000000000000001-00"+chunkExt)
+	if err := ioutil.WriteFile(corrupt, []byte{0x94, 0xc4}, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if chunks := readAll(t, b); len(chunks) != 1 {
+		t.Errorf("expected the one valid chunk, got %+v", chunks)
+	}
+	if _, err := os.Stat(corrupt); !os.IsNotExist(err) {
+		t.Errorf("the corrupt chunk was not removed, %v", err)
+	}
+}
+
+func TestRetryFlushBatches(t *testing.T) {
+	b, cleanup := tempBuffer(t, 0)
+	defer cleanup()
+
+	for _, n := range []int{40, 40, 40, 100, 10} {
+		if err := b.Put("a", 1, make([]byte, n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var batches [][]int
+	r := &retry{dest: "test"}
+	err := r.flush(b, 100, func(chunks []Chunk) error {
+		var sizes []int
+		for _, c := range chunks {
+			sizes = append(sizes, len(c.Data))
+		}
+		batches = append(batches, sizes)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := [][]int{{40, 40}, {40}, {100}, {10}}; !reflect.DeepEqual(batches, want) {
+		t.Errorf("got batches %v, expected %v", batches, want)
+	}
+	if files, _ := filepath.Glob(path.Join(b.Dir, "*")); len(files) != 0 {
+		t.Errorf("flushed chunks are left, %v", files)
+	}
+}
+
+func TestRetryFlushBackoff(t *testing.T) {
+	b, cleanup := tempBuffer(t, 0)
+	defer cleanup()
+	if err := b.Put("a", 1, []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &retry{dest: "test"}
+	var sends int
+	failing := func([]Chunk) error {
+		sends++
+		return errors.New("connection refused")
+	}
+	if err := r.flush(b, 0, failing); err == nil {
+		t.Fatal("a failed send returned no error")
+	}
+	if err := r.flush(b, 0, failing); err == nil || sends != 1 {
+		t.Errorf("flush during the backoff sent %d times, returned %v", sends, err)
+	}
+	if len(readAll(t, b)) != 1 {
+		t.Errorf("the chunk of a failed send is gone")
+	}
+
+	r.nextTry = time.Time{}
+	err := r.flush(b, 0, func([]Chunk) error {
+		return &PermanentError{Err: errors.New("bad request")}
+	})
+	if err == nil {
+		t.Errorf("a rejected chunk was dropped silently")
+	}
+	if len(readAll(t, b)) != 0 {
+		t.Errorf("the rejected chunk was kept")
+	}
+}
+
+func mustReadFile(t *testing.T, file string) []byte {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
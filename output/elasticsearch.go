@@ -0,0 +1,187 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	TypeElasticsearch = "elasticsearch"
+
+	DefaultIndex = "log-volume-{{.namespace}}-{{.date}}"
+
+	indexDateLayout = "2006.01.02"
+	bulkPath        = "/_bulk"
+)
+
+// Elasticsearch indexes records through the bulk API, the index of a record is Index executed with the
+// record fields as strings plus date, the UTC day of the record.
+type Elasticsearch struct {
+	// URL is the base url of Elasticsearch, the bulk path is appended
+	URL   string
+	Index *template.Template
+	// BatchSize is the bytes of buffered records sent in one bulk request
+	BatchSize int
+	HTTP      HTTPTarget
+	Buffer    *Buffer
+
+	lock  sync.Mutex
+	retry *retry
+}
+
+// ParseIndex parses an index template like DefaultIndex, missing fields render empty.
+func ParseIndex(index string) (*template.Template, error) {
+	t, err := template.New("index").Option("missingkey=zero").Parse(index)
+	if err != nil {
+		return nil, fmt.Errorf("parse index template %s failed, %v", index, err)
+	}
+	return t, nil
+}
+
+type bulkResponse struct {
+	Errors bool                        `json:"errors"`
+	Items  []map[string]bulkItemResult `json:"items"`
+}
+
+type bulkItemResult struct {
+	Status int             `json:"status"`
+	Error  json.RawMessage `json:"error"`
+}
+
+// Write only buffers the records by index, Flush ships them in batches. The tag of a chunk is its index.
+func (e *Elasticsearch) Write(records []Record) error {
+	tagged := make([]Record, len(records))
+	for i, r := range records {
+		index, err := e.index(r)
+		if err != nil {
+			return err
+		}
+		r.Tag = index
+		tagged[i] = r
+	}
+
+	return putRecords(e.Buffer, tagged)
+}
+
+func (e *Elasticsearch) index(r Record) (string, error) {
+	data := make(map[string]string, len(r.Fields)+1)
+	for k, v := range r.Fields {
+		if v != nil {
+			data[k] = fmt.Sprint(v)
+		}
+	}
+	data["date"] = r.Time.UTC().Format(indexDateLayout)
+
+	var buf bytes.Buffer
+	if err := e.Index.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("render index failed, %v", err)
+	}
+	// index names are lower case and must not start with a separator
+	return strings.TrimLeft(strings.ToLower(buf.String()), "-_+"), nil
+}
+
+func (e *Elasticsearch) Flush() error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.retry == nil {
+		e.retry = &retry{dest: e.URL}
+	}
+	return e.retry.flush(e.Buffer, e.BatchSize, e.bulk)
+}
+
+func (e *Elasticsearch) bulk(chunks []Chunk) error {
+	var (
+		body bytes.Buffer
+		sent []Record
+	)
+	enc := json.NewEncoder(&body)
+	enc.SetEscapeHTML(false)
+	for _, c := range chunks {
+		records, err := unpackEntries(c)
+		if err != nil {
+			return &PermanentError{err}
+		}
+		sent = append(sent, records...)
+		for _, r := range records {
+			doc := make(map[string]interface{}, len(r.Fields)+1)
+			for k, v := range r.Fields {
+				doc[k] = v
+			}
+			doc["@timestamp"] = r.Time.UTC().Format(time.RFC3339Nano)
+			action := map[string]interface{}{"index": map[string]string{"_index": c.Tag}}
+			if err := enc.Encode(action); err != nil {
+				return &PermanentError{err}
+			}
+			if err := enc.Encode(doc); err != nil {
+				return &PermanentError{err}
+			}
+		}
+	}
+
+	respBody, err := e.HTTP.post(strings.TrimSuffix(e.URL, "/")+bulkPath, "application/x-ndjson", http.Header{}, body.Bytes())
+	if err != nil {
+		return err
+	}
+	var resp bulkResponse
+	if err := json.Unmarshal(respBody, &resp); err != nil {
+		return fmt.Errorf("parse bulk response failed, %v", err)
+	}
+	if !resp.Errors {
+		return nil
+	}
+
+	// only the items that may succeed later are buffered again, the items of a response are in the
+	// order of the actions
+	if len(resp.Items) != len(sent) {
+		return fmt.Errorf("bulk response has %d items for %d documents", len(resp.Items), len(sent))
+	}
+	var (
+		retry            []Record
+		failed, rejected *bulkItemResult
+	)
+	for i, item := range resp.Items {
+		for _, result := range item {
+			result := result
+			switch {
+			case result.Status == http.StatusTooManyRequests || result.Status >= 500:
+				retry = append(retry, sent[i])
+				if failed == nil {
+					failed = &result
+				}
+			case result.Status >= 300 && rejected == nil:
+				rejected = &result
+			}
+		}
+	}
+
+	var rejectedErr error
+	if rejected != nil {
+		rejectedErr = fmt.Errorf("bulk item rejected with status %d, %s", rejected.Status, rejected.Error)
+	}
+	if len(retry) == 0 {
+		if rejectedErr != nil {
+			return &PermanentError{rejectedErr}
+		}
+		return nil
+	}
+
+	err = fmt.Errorf("%d of %d bulk items failed, first with status %d, %s", len(retry), len(sent), failed.Status, failed.Error)
+	if putErr := putRecords(e.Buffer, retry); putErr != nil {
+		return fmt.Errorf("%v, buffer them again failed, %v", err, putErr)
+	}
+	if rejectedErr != nil {
+		err = fmt.Errorf("%v, dropped the rejected ones, %v", err, rejectedErr)
+	}
+	return &PartialError{err}
+}
+
+func (e *Elasticsearch) Close() error {
+	return nil
+}
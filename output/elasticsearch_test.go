@@ -0,0 +1,204 @@
+package output
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestElasticsearch(t *testing.T, s *httpServer) (*Elasticsearch, func()) {
+	index, err := ParseIndex(DefaultIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, cleanup := tempBuffer(t, 0)
+	return &Elasticsearch{
+		URL:       s.URL,
+		Index:     index,
+		BatchSize: 1 << 20,
+		HTTP:      HTTPTarget{Client: s.Client()},
+		Buffer:    b,
+	}, cleanup
+}
+
+func esRecord(namespace, line string, at time.Time) Record {
+	return Record{Time: at, Fields: map[string]interface{}{"namespace": namespace, "log": line}}
+}
+
+// bulkLines splits a bulk body into its action and document lines.
+func bulkLines(t *testing.T, body []byte) []map[string]interface{} {
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		var v map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &v); err != nil {
+			t.Fatalf("invalid bulk line %s, %v", scanner.Text(), err)
+		}
+		lines = append(lines, v)
+	}
+	return lines
+}
+
+func TestElasticsearchIndex(t *testing.T) {
+	s := newHTTPServer(t)
+	defer s.Close()
+	es, cleanup := newTestElasticsearch(t, s)
+	defer cleanup()
+
+	at := time.Date(2018, 3, 4, 23, 30, 0, 0, time.FixedZone("", -2*3600))
+	cases := []struct {
+		fields map[string]interface{}
+		index  string
+	}{
+		{map[string]interface{}{"namespace": "Default"}, "log-volume-default-2018.03.05"},
+		{map[string]interface{}{}, "log-volume--2018.03.05"},
+	}
+	for _, v := range cases {
+		got, err := es.index(Record{Time: at, Fields: v.fields})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != v.index {
+			t.Errorf("fields %v got index %s, expected %s", v.fields, got, v.index)
+		}
+	}
+
+	es.Index, _ = ParseIndex("{{.project}}-logs")
+	if got, _ := es.index(Record{Time: at, Fields: map[string]interface{}{}}); got != "logs" {
+		t.Errorf("index with a leading separator is %s", got)
+	}
+}
+
+func TestElasticsearchBulk(t *testing.T) {
+	s := newHTTPServer(t)
+	s.response = `{"errors":false,"items":[]}`
+	defer s.Close()
+	es, cleanup := newTestElasticsearch(t, s)
+	defer cleanup()
+
+	at := time.Date(2018, 3, 4, 12, 0, 0, 5, time.UTC)
+	if err := es.Write([]Record{esRecord("a", "one", at), esRecord("b", "<two>", at)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := es.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := s.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("expected one bulk request, got %d", len(requests))
+	}
+	if r := requests[0]; r.URL.Path != bulkPath || r.Header.Get("Content-Type") != "application/x-ndjson" {
+		t.Errorf("bulk to %s with header %v", r.URL.Path, r.Header)
+	}
+	body := s.Bodies()[0]
+	if !bytes.Contains(body, []byte(`"<two>"`)) {
+		t.Errorf("html in the document was escaped, %s", body)
+	}
+
+	lines := bulkLines(t, body)
+	if len(lines) != 4 {
+		t.Fatalf("expected 2 actions and 2 documents, got %s", body)
+	}
+	for i, v := range []struct{ index, log string }{{"log-volume-a-2018.03.04", "one"}, {"log-volume-b-2018.03.04", "<two>"}} {
+		action, _ := lines[2*i]["index"].(map[string]interface{})
+		if action["_index"] != v.index {
+			t.Errorf("action %d is %v, expected index %s", i, lines[2*i], v.index)
+		}
+		doc := lines[2*i+1]
+		if doc["log"] != v.log || doc["@timestamp"] != "2018-03-04T12:00:00.000000005Z" {
+			t.Errorf("document %d is %v", i, doc)
+		}
+	}
+}
+
+func TestElasticsearchBulkItemRejected(t *testing.T) {
+	s := newHTTPServer(t)
+	s.response = `{"errors":true,"items":[{"index":{"status":201}},{"index":{"status":400,"error":{"type":"mapper_parsing_exception"}}}]}`
+	defer s.Close()
+	es, cleanup := newTestElasticsearch(t, s)
+	defer cleanup()
+
+	if err := es.Write([]Record{esRecord("a", "one", time.Now()), esRecord("a", "two", time.Now())}); err != nil {
+		t.Fatal(err)
+	}
+	if err := es.Flush(); err == nil {
+		t.Error("flush returned no error for a rejected item")
+	}
+	if len(readAll(t, es.Buffer)) != 0 {
+		t.Errorf("the rejected chunk is still buffered")
+	}
+}
+
+func TestElasticsearchRetriesOnlyFailedItems(t *testing.T) {
+	s := newHTTPServer(t)
+	s.response = `{"errors":true,"items":[{"index":{"status":201}},{"index":{"status":429,"error":{"type":"es_rejected_execution_exception"}}},{"index":{"status":400,"error":{"type":"mapper_parsing_exception"}}},{"index":{"status":503}}]}`
+	defer s.Close()
+	es, cleanup := newTestElasticsearch(t, s)
+	defer cleanup()
+
+	records := []Record{esRecord("a", "indexed", time.Now()), esRecord("a", "busy", time.Now()), esRecord("b", "rejected", time.Now()), esRecord("b", "unavailable", time.Now())}
+	if err := es.Write(records); err != nil {
+		t.Fatal(err)
+	}
+	if err := es.Flush(); err == nil {
+		t.Fatal("flush returned no error for failed items")
+	}
+	if err := es.Flush(); err == nil || len(s.Bodies()) != 1 {
+		t.Errorf("flush during the backoff sent again, %v", err)
+	}
+
+	s.lock.Lock()
+	s.response = `{"errors":false,"items":[{"index":{"status":201}},{"index":{"status":201}}]}`
+	s.lock.Unlock()
+	es.retry.nextTry = time.Time{}
+	if err := es.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := s.Bodies()
+	if len(bodies) != 2 {
+		t.Fatalf("expected 2 bulk requests, got %d", len(bodies))
+	}
+	var logs []interface{}
+	for i, line := range bulkLines(t, bodies[1]) {
+		if i%2 == 1 {
+			logs = append(logs, line["log"])
+		}
+	}
+	if want := []interface{}{"busy", "unavailable"}; !reflect.DeepEqual(logs, want) {
+		t.Errorf("retried documents %v, expected only the failed ones %v", logs, want)
+	}
+	if len(readAll(t, es.Buffer)) != 0 {
+		t.Errorf("the retried chunks are still buffered")
+	}
+}
+
+func TestElasticsearchServerError(t *testing.T) {
+	s := newHTTPServer(t, http.StatusInternalServerError)
+	s.response = `{"errors":false,"items":[]}`
+	defer s.Close()
+	es, cleanup := newTestElasticsearch(t, s)
+	defer cleanup()
+
+	if err := es.Write([]Record{esRecord("a", "one", time.Now())}); err != nil {
+		t.Fatal(err)
+	}
+	if err := es.Flush(); err == nil {
+		t.Fatal("flush returned no error for a 500")
+	}
+	es.retry.nextTry = time.Time{}
+	if err := es.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(s.Bodies()); n != 2 {
+		t.Errorf("expected the bulk to be sent twice, got %d", n)
+	}
+	if len(readAll(t, es.Buffer)) != 0 {
+		t.Errorf("the indexed chunk is still buffered")
+	}
+}
@@ -0,0 +1,252 @@
+package output
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rancher/log-aggregator/msgpack"
+)
+
+const TypeForward = "forward"
+
+// Forward ships records to a fluentd in_forward in PackedForward mode. Records are buffered to disk
+// first, a chunk is only removed once the server acknowledged it when RequireAck is set.
+type Forward struct {
+	Address string
+	// TLS is nil for plain tcp
+	TLS *tls.Config
+	// SharedKey enables the handshake of the fluentd secure forward
+	SharedKey  string
+	Hostname   string
+	RequireAck bool
+	Timeout    time.Duration
+	Buffer     *Buffer
+
+	lock  sync.Mutex
+	conn  net.Conn
+	dec   *msgpack.Decoder
+	retry *retry
+}
+
+// Write buffers the records and tries to ship everything buffered, a failed send is not an error
+// of Write, the records wait in the buffer and Flush reports it.
+func (f *Forward) Write(records []Record) error {
+	if err := putRecords(f.Buffer, records); err != nil {
+		return err
+	}
+	f.Flush()
+	return nil
+}
+
+// putRecords buffers the records as one chunk of packEntries per tag.
+func putRecords(b *Buffer, records []Record) error {
+	tags, entries, counts, err := packEntries(records)
+	if err != nil {
+		return err
+	}
+	for i, tag := range tags {
+		if err := b.Put(tag, counts[i], entries[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// packEntries encodes the records as concatenated [time, record] entries per tag, in order of appearance.
+func packEntries(records []Record) ([]string, [][]byte, []int, error) {
+	var (
+		tags    []string
+		entries [][]byte
+		counts  []int
+		index   = map[string]int{}
+	)
+	for _, r := range records {
+		i, ok := index[r.Tag]
+		if !ok {
+			i = len(tags)
+			index[r.Tag] = i
+			tags = append(tags, r.Tag)
+			entries = append(entries, nil)
+			counts = append(counts, 0)
+		}
+		var err error
+		if entries[i], err = msgpack.Append(entries[i], []interface{}{msgpack.EventTime(r.Time), r.Fields}); err != nil {
+			return nil, nil, nil, err
+		}
+		counts[i]++
+	}
+	return tags, entries, counts, nil
+}
+
+// Flush ships the buffered chunks oldest first, after a failure it waits with an exponential backoff.
+func (f *Forward) Flush() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.retry == nil {
+		f.retry = &retry{dest: f.Address}
+	}
+	return f.retry.flush(f.Buffer, 0, func(chunks []Chunk) error {
+		if err := f.send(chunks[0]); err != nil {
+			f.closeConn()
+			return err
+		}
+		return nil
+	})
+}
+
+func (f *Forward) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.closeConn()
+	return nil
+}
+
+func (f *Forward) closeConn() {
+	if f.conn != nil {
+		f.conn.Close()
+		f.conn, f.dec = nil, nil
+	}
+}
+
+func (f *Forward) send(c Chunk) error {
+	if err := f.connect(); err != nil {
+		return err
+	}
+
+	chunkID := base64.StdEncoding.EncodeToString(c.ID)
+	option := map[string]interface{}{"size": c.Count}
+	if f.RequireAck {
+		option["chunk"] = chunkID
+	}
+	message, err := msgpack.Marshal([]interface{}{c.Tag, c.Data, option})
+	if err != nil {
+		return err
+	}
+
+	f.conn.SetDeadline(time.Now().Add(f.Timeout))
+	if _, err := f.conn.Write(message); err != nil {
+		return err
+	}
+	if !f.RequireAck {
+		return nil
+	}
+
+	resp, err := f.dec.Decode()
+	if err != nil {
+		return fmt.Errorf("read ack failed, %v", err)
+	}
+	if m, ok := resp.(map[string]interface{}); !ok || m["ack"] != chunkID {
+		return fmt.Errorf("unexpected ack %v for chunk %s", resp, chunkID)
+	}
+	return nil
+}
+
+func (f *Forward) connect() error {
+	if f.conn != nil {
+		return nil
+	}
+
+	dialer := &net.Dialer{Timeout: f.Timeout}
+	var (
+		conn net.Conn
+		err  error
+	)
+	if f.TLS != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", f.Address, f.TLS)
+	} else {
+		conn, err = dialer.Dial("tcp", f.Address)
+	}
+	if err != nil {
+		return err
+	}
+
+	f.conn, f.dec = conn, msgpack.NewDecoder(bufio.NewReader(conn))
+	if f.SharedKey == "" {
+		return nil
+	}
+	if err := f.handshake(); err != nil {
+		f.closeConn()
+		return fmt.Errorf("handshake failed, %v", err)
+	}
+	return nil
+}
+
+// handshake answers the HELO of the server with a PING proving the shared key, and checks that the
+// PONG proves it too.
+func (f *Forward) handshake() error {
+	f.conn.SetDeadline(time.Now().Add(f.Timeout))
+	helo, err := f.dec.Decode()
+	if err != nil {
+		return err
+	}
+	fields, ok := helo.([]interface{})
+	if !ok || len(fields) != 2 || fields[0] != "HELO" {
+		return fmt.Errorf("expected HELO, got %v", helo)
+	}
+	options, _ := fields[1].(map[string]interface{})
+	nonce := bytesOf(options["nonce"])
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	ping, err := msgpack.Marshal([]interface{}{
+		"PING",
+		f.Hostname,
+		salt,
+		sharedKeyDigest(salt, f.Hostname, nonce, f.SharedKey),
+		"",
+		"",
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := f.conn.Write(ping); err != nil {
+		return err
+	}
+
+	pong, err := f.dec.Decode()
+	if err != nil {
+		return err
+	}
+	fields, ok = pong.([]interface{})
+	if !ok || len(fields) != 5 || fields[0] != "PONG" {
+		return fmt.Errorf("expected PONG, got %v", pong)
+	}
+	if authenticated, _ := fields[1].(bool); !authenticated {
+		return fmt.Errorf("server refused, %v", fields[2])
+	}
+	serverHostname, _ := fields[3].(string)
+	if fields[4] != sharedKeyDigest(salt, serverHostname, nonce, f.SharedKey) {
+		return fmt.Errorf("server %s does not know the shared key", serverHostname)
+	}
+	return nil
+}
+
+func sharedKeyDigest(salt []byte, hostname string, nonce []byte, sharedKey string) string {
+	h := sha512.New()
+	h.Write(salt)
+	h.Write([]byte(hostname))
+	h.Write(nonce)
+	h.Write([]byte(sharedKey))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func bytesOf(v interface{}) []byte {
+	switch t := v.(type) {
+	case []byte:
+		return t
+	case string:
+		return []byte(t)
+	}
+	return nil
+}
@@ -0,0 +1,341 @@
+package output
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rancher/log-aggregator/msgpack"
+)
+
+// forwardServer is a minimal fluentd in_forward taking PackedForward messages.
+type forwardServer struct {
+	t         *testing.T
+	ln        net.Listener
+	sharedKey string
+	// badAck answers with an ack for another chunk
+	badAck bool
+
+	lock    sync.Mutex
+	records []Record
+	conns   []net.Conn
+	wg      sync.WaitGroup
+}
+
+func newForwardServer(t *testing.T, sharedKey string) *forwardServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &forwardServer{t: t, ln: ln, sharedKey: sharedKey}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+func (s *forwardServer) Close() {
+	s.ln.Close()
+	s.lock.Lock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.lock.Unlock()
+	s.wg.Wait()
+}
+
+func (s *forwardServer) Records() []Record {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]Record(nil), s.records...)
+}
+
+func (s *forwardServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.lock.Lock()
+		s.conns = append(s.conns, conn)
+		s.lock.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			if err := s.handle(conn); err != nil {
+				s.t.Logf("forward server: %v", err)
+			}
+		}()
+	}
+}
+
+func (s *forwardServer) handle(conn net.Conn) error {
+	dec := msgpack.NewDecoder(bufio.NewReader(conn))
+	if s.sharedKey != "" {
+		if err := s.handshake(conn, dec); err != nil {
+			return err
+		}
+	}
+
+	for {
+		v, err := dec.Decode()
+		if err != nil {
+			return nil
+		}
+		fields, ok := v.([]interface{})
+		if !ok || len(fields) != 3 {
+			return fmt.Errorf("unexpected message %v", v)
+		}
+		tag, _ := fields[0].(string)
+		data, _ := fields[1].([]byte)
+		option, _ := fields[2].(map[string]interface{})
+		size, _ := option["size"].(int64)
+
+		records, err := unpackEntries(Chunk{Tag: tag, Count: int(size), Data: data})
+		if err != nil {
+			return err
+		}
+		s.lock.Lock()
+		s.records = append(s.records, records...)
+		s.lock.Unlock()
+
+		chunk, ok := option["chunk"].(string)
+		if !ok {
+			continue
+		}
+		if s.badAck {
+			chunk = "other"
+		}
+		ack, err := msgpack.Marshal(map[string]interface{}{"ack": chunk})
+		if err != nil {
+			return err
+		}
+		if _, err := conn.Write(ack); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *forwardServer) handshake(conn net.Conn, dec *msgpack.Decoder) error {
+	nonce := []byte("0123456789abcdef")
+	helo, err := msgpack.Marshal([]interface{}{"HELO", map[string]interface{}{"nonce": nonce, "auth": "", "keepalive": true}})
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(helo); err != nil {
+		return err
+	}
+
+	v, err := dec.Decode()
+	if err != nil {
+		return err
+	}
+	ping, ok := v.([]interface{})
+	if !ok || len(ping) != 6 || ping[0] != "PING" {
+		return fmt.Errorf("expected PING, got %v", v)
+	}
+	hostname, _ := ping[1].(string)
+	salt := bytesOf(ping[2])
+	authenticated := ping[3] == sharedKeyDigest(salt, hostname, nonce, s.sharedKey)
+
+	pong, err := msgpack.Marshal([]interface{}{"PONG", authenticated, "", "server", sharedKeyDigest(salt, "server", nonce, s.sharedKey)})
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(pong); err != nil {
+		return err
+	}
+	if !authenticated {
+		return fmt.Errorf("shared key mismatch")
+	}
+	return nil
+}
+
+func newTestForward(t *testing.T, address, sharedKey string) (*Forward, func()) {
+	b, cleanup := tempBuffer(t, 0)
+	f := &Forward{
+		Address:    address,
+		SharedKey:  sharedKey,
+		Hostname:   "node-1",
+		RequireAck: true,
+		Timeout:    5 * time.Second,
+		Buffer:     b,
+	}
+	return f, func() {
+		f.Close()
+		cleanup()
+	}
+}
+
+func testRecords(tags ...string) []Record {
+	at := time.Unix(1500000000, 123000000)
+	var records []Record
+	for i, tag := range tags {
+		records = append(records, Record{Tag: tag, Time: at.Add(time.Duration(i) * time.Second), Fields: map[string]interface{}{"log": fmt.Sprintf("line %d", i)}})
+	}
+	return records
+}
+
+func expectRecords(t *testing.T, got, want []Record) {
+	if len(got) != len(want) {
+		t.Fatalf("server got %d records, expected %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i].Tag != want[i].Tag || !got[i].Time.Equal(want[i].Time) || got[i].Fields["log"] != want[i].Fields["log"] {
+			t.Errorf("record %d is %+v, expected %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestForwardAcknowledged(t *testing.T) {
+	s := newForwardServer(t, "")
+	defer s.Close()
+	f, cleanup := newTestForward(t, s.ln.Addr().String(), "")
+	defer cleanup()
+
+	records := testRecords("a", "a", "b")
+	if err := f.Write(records); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// one chunk per tag, in order of appearance
+	expectRecords(t, s.Records(), records)
+	if chunks := readAll(t, f.Buffer); len(chunks) != 0 {
+		t.Errorf("acknowledged chunks are still buffered, %+v", chunks)
+	}
+}
+
+func TestForwardBuffersWhileServerIsDown(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := ln.Addr().String()
+	ln.Close()
+
+	f, cleanup := newTestForward(t, address, "")
+	defer cleanup()
+
+	records := testRecords("a", "b")
+	if err := f.Write(records); err != nil {
+		t.Fatalf("write failed while the server is down, %v", err)
+	}
+	if err := f.Flush(); err == nil {
+		t.Fatal("flush returned no error while the server is down")
+	}
+	if chunks := readAll(t, f.Buffer); len(chunks) != 2 {
+		t.Fatalf("expected 2 buffered chunks, got %d", len(chunks))
+	}
+
+	ln, err = net.Listen("tcp", address)
+	if err != nil {
+		t.Skipf("can not listen on %s again, %v", address, err)
+	}
+	s := &forwardServer{t: t, ln: ln}
+	s.wg.Add(1)
+	go s.serve()
+	defer s.Close()
+
+	f.retry.nextTry = time.Time{}
+	if err := f.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	expectRecords(t, s.Records(), records)
+	if chunks := readAll(t, f.Buffer); len(chunks) != 0 {
+		t.Errorf("shipped chunks are still buffered, %+v", chunks)
+	}
+}
+
+func TestForwardWrongAckKeepsChunk(t *testing.T) {
+	s := newForwardServer(t, "")
+	s.badAck = true
+	defer s.Close()
+	f, cleanup := newTestForward(t, s.ln.Addr().String(), "")
+	defer cleanup()
+
+	if err := f.Write(testRecords("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Flush(); err == nil {
+		t.Error("flush returned no error for a wrong ack")
+	}
+	if chunks := readAll(t, f.Buffer); len(chunks) != 1 {
+		t.Errorf("expected the unacknowledged chunk to stay buffered, got %d chunks", len(chunks))
+	}
+}
+
+func TestForwardSharedKey(t *testing.T) {
+	s := newForwardServer(t, "secret")
+	defer s.Close()
+
+	f, cleanup := newTestForward(t, s.ln.Addr().String(), "secret")
+	defer cleanup()
+	records := testRecords("a")
+	if err := f.Write(records); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	expectRecords(t, s.Records(), records)
+
+	wrong, cleanupWrong := newTestForward(t, s.ln.Addr().String(), "wrong")
+	defer cleanupWrong()
+	if err := wrong.Write(testRecords("b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := wrong.Flush(); err == nil {
+		t.Error("flush with a wrong shared key returned no error")
+	}
+	if len(s.Records()) != 1 {
+		t.Errorf("records sent with a wrong shared key were accepted")
+	}
+}
+
+// TestForwardConcurrentWrites writes from several goroutines like the tail and fifo tasks sharing the
+// output, every record must arrive exactly once.
+func TestForwardConcurrentWrites(t *testing.T) {
+	s := newForwardServer(t, "")
+	defer s.Close()
+	f, cleanup := newTestForward(t, s.ln.Addr().String(), "")
+	defer cleanup()
+
+	const writers, writes = 4, 25
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				r := Record{Tag: "t", Time: time.Now(), Fields: map[string]interface{}{"log": fmt.Sprintf("%d-%d", w, i)}}
+				if err := f.Write([]Record{r}); err != nil {
+					t.Error(err)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	if err := f.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[interface{}]int{}
+	for _, r := range s.Records() {
+		seen[r.Fields["log"]]++
+	}
+	if len(seen) != writers*writes {
+		t.Errorf("server got %d distinct records, expected %d", len(seen), writers*writes)
+	}
+	for k, n := range seen {
+		if n != 1 {
+			t.Errorf("record %v arrived %d times", k, n)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package output
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// HTTPTarget posts batches to an HTTP API, optionally gzip compressed and with basic auth.
+type HTTPTarget struct {
+	Client   *http.Client
+	Username string
+	Password string
+	Gzip     bool
+}
+
+// post returns the response body of a 2xx, a 429 or 5xx is retried and any other status is permanent.
+func (h *HTTPTarget) post(url, contentType string, header http.Header, body []byte) ([]byte, error) {
+	var reader io.Reader = bytes.NewReader(body)
+	if h.Gzip {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(body); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		reader = &buf
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, reader)
+	if err != nil {
+		return nil, &PermanentError{err}
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", contentType)
+	if h.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if h.Username != "" {
+		req.SetBasicAuth(h.Username, h.Password)
+	}
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response failed, %v", err)
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return respBody, nil
+	}
+	err = fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(respBody))
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return nil, err
+	}
+	return nil, &PermanentError{err}
+}
@@ -0,0 +1,148 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TypeLoki = "loki"
+
+	lokiPushPath = "/loki/api/v1/push"
+)
+
+// DefaultLokiLabels are the record fields that make up a stream, anything finer would explode the
+// number of streams.
+var DefaultLokiLabels = []string{"cluster", "project", "namespace", "workload", "container"}
+
+// Loki pushes records to the push API of Grafana Loki, one stream per distinct set of Labels values.
+// The line of an entry is the log field of the record.
+type Loki struct {
+	// URL is the base url of Loki, the push path is appended
+	URL      string
+	TenantID string
+	Labels   []string
+	// BatchSize is the bytes of buffered records sent in one push
+	BatchSize int
+	HTTP      HTTPTarget
+	Buffer    *Buffer
+
+	lock  sync.Mutex
+	retry *retry
+	// newest is the time of the last entry pushed per stream
+	newest map[string]time.Time
+}
+
+type lokiPush struct {
+	Streams []lokiStream `json:"streams"`
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// Write only buffers the records by stream, Flush ships them in batches. The tag of a chunk is the JSON
+// of its stream labels.
+func (l *Loki) Write(records []Record) error {
+	tagged := make([]Record, len(records))
+	for i, r := range records {
+		labels := map[string]string{}
+		for _, name := range l.Labels {
+			if v, ok := r.Fields[name]; ok && v != nil && fmt.Sprint(v) != "" {
+				labels[name] = fmt.Sprint(v)
+			}
+		}
+		key, err := json.Marshal(labels)
+		if err != nil {
+			return err
+		}
+		r.Tag = string(key)
+		tagged[i] = r
+	}
+
+	return putRecords(l.Buffer, tagged)
+}
+
+// Flush pushes one batch at a time in buffer order and stops at a failed one, so a later batch of a
+// stream never reaches Loki before an earlier one.
+func (l *Loki) Flush() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.retry == nil {
+		l.retry = &retry{dest: l.URL}
+	}
+	return l.retry.flush(l.Buffer, l.BatchSize, l.push)
+}
+
+func (l *Loki) push(chunks []Chunk) error {
+	var (
+		tags    []string
+		streams = map[string][]Record{}
+	)
+	for _, c := range chunks {
+		records, err := unpackEntries(c)
+		if err != nil {
+			return &PermanentError{err}
+		}
+		if _, ok := streams[c.Tag]; !ok {
+			tags = append(tags, c.Tag)
+		}
+		streams[c.Tag] = append(streams[c.Tag], records...)
+	}
+
+	var push lokiPush
+	pushed := map[string]time.Time{}
+	for _, tag := range tags {
+		stream := lokiStream{Stream: map[string]string{}}
+		if err := json.Unmarshal([]byte(tag), &stream.Stream); err != nil {
+			return &PermanentError{fmt.Errorf("invalid stream %s, %v", tag, err)}
+		}
+		// loki rejects entries older than the newest one of their stream, a later chunk of the stream can
+		// hold older entries when several files feed it, they get the time of the newest one pushed
+		records := streams[tag]
+		sort.SliceStable(records, func(i, j int) bool {
+			return records[i].Time.Before(records[j].Time)
+		})
+		newest := l.newest[tag]
+		for _, r := range records {
+			if r.Time.After(newest) {
+				newest = r.Time
+			}
+			line, _ := r.Fields["log"].(string)
+			stream.Values = append(stream.Values, [2]string{strconv.FormatInt(newest.UnixNano(), 10), line})
+		}
+		pushed[tag] = newest
+		push.Streams = append(push.Streams, stream)
+	}
+
+	body, err := json.Marshal(push)
+	if err != nil {
+		return &PermanentError{err}
+	}
+	header := http.Header{}
+	if l.TenantID != "" {
+		header.Set("X-Scope-OrgID", l.TenantID)
+	}
+	if _, err = l.HTTP.post(strings.TrimSuffix(l.URL, "/")+lokiPushPath, "application/json", header, body); err != nil {
+		return err
+	}
+	if l.newest == nil {
+		l.newest = map[string]time.Time{}
+	}
+	for tag, t := range pushed {
+		l.newest[tag] = t
+	}
+	return nil
+}
+
+func (l *Loki) Close() error {
+	return nil
+}
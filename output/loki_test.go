@@ -0,0 +1,250 @@
+package output
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// httpServer records the decoded request bodies and answers with the queued statuses, 200 once they
+// are used up.
+type httpServer struct {
+	*httptest.Server
+
+	lock     sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+	statuses []int
+	response string
+}
+
+func newHTTPServer(t *testing.T, statuses ...int) *httpServer {
+	s := &httpServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reader io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Errorf("invalid gzip body, %v", err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			reader = zr
+		}
+		body, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Errorf("read body failed, %v", err)
+		}
+
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.requests = append(s.requests, r)
+		s.bodies = append(s.bodies, body)
+		status := http.StatusOK
+		if len(s.statuses) != 0 {
+			status, s.statuses = s.statuses[0], s.statuses[1:]
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, s.response)
+	}))
+	return s
+}
+
+func (s *httpServer) Bodies() [][]byte {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([][]byte(nil), s.bodies...)
+}
+
+func (s *httpServer) Requests() []*http.Request {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]*http.Request(nil), s.requests...)
+}
+
+func newTestLoki(t *testing.T, s *httpServer) (*Loki, func()) {
+	b, cleanup := tempBuffer(t, 0)
+	return &Loki{
+		URL:       s.URL + "/",
+		TenantID:  "tenant",
+		Labels:    DefaultLokiLabels,
+		BatchSize: 1 << 20,
+		HTTP:      HTTPTarget{Client: s.Client(), Username: "user", Password: "pass", Gzip: true},
+		Buffer:    b,
+	}, cleanup
+}
+
+func lokiRecord(namespace, line string, at time.Time) Record {
+	return Record{Time: at, Fields: map[string]interface{}{"namespace": namespace, "workload": "nginx", "log": line, "stream": "stdout"}}
+}
+
+func TestLokiPush(t *testing.T) {
+	s := newHTTPServer(t)
+	defer s.Close()
+	l, cleanup := newTestLoki(t, s)
+	defer cleanup()
+
+	at := time.Unix(1500000000, 0)
+	records := []Record{
+		lokiRecord("a", "second", at.Add(time.Second)),
+		lokiRecord("b", "other", at),
+		lokiRecord("a", "first", at),
+	}
+	if err := l.Write(records); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := s.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("expected one push, got %d", len(requests))
+	}
+	r := requests[0]
+	if r.URL.Path != lokiPushPath || r.Header.Get("X-Scope-OrgID") != "tenant" || r.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("push to %s with header %v", r.URL.Path, r.Header)
+	}
+	if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "pass" {
+		t.Errorf("basic auth %s:%s", user, pass)
+	}
+
+	var push lokiPush
+	if err := json.Unmarshal(s.Bodies()[0], &push); err != nil {
+		t.Fatal(err)
+	}
+	// streams in order of appearance, entries of a stream sorted by time
+	want := lokiPush{Streams: []lokiStream{
+		{Stream: map[string]string{"namespace": "a", "workload": "nginx"}, Values: [][2]string{{"1500000000000000000", "first"}, {"1500000001000000000", "second"}}},
+		{Stream: map[string]string{"namespace": "b", "workload": "nginx"}, Values: [][2]string{{"1500000000000000000", "other"}}},
+	}}
+	if !reflect.DeepEqual(push, want) {
+		t.Errorf("got push %+v, expected %+v", push, want)
+	}
+}
+
+func TestLokiBatchSize(t *testing.T) {
+	s := newHTTPServer(t)
+	defer s.Close()
+	l, cleanup := newTestLoki(t, s)
+	defer cleanup()
+	l.BatchSize = 1
+
+	at := time.Unix(1500000000, 0)
+	for _, ns := range []string{"a", "b", "c"} {
+		if err := l.Write([]Record{lokiRecord(ns, "line", at)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(s.Bodies()); n != 3 {
+		t.Errorf("expected a push per chunk, got %d", n)
+	}
+}
+
+func TestLokiRetry(t *testing.T) {
+	s := newHTTPServer(t, http.StatusTooManyRequests, http.StatusServiceUnavailable)
+	defer s.Close()
+	l, cleanup := newTestLoki(t, s)
+	defer cleanup()
+
+	if err := l.Write([]Record{lokiRecord("a", "line", time.Now())}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := l.Flush(); err == nil {
+			t.Fatalf("flush %d returned no error", i)
+		}
+		if len(readAll(t, l.Buffer)) != 1 {
+			t.Fatalf("the chunk was dropped after a retriable failure")
+		}
+		l.retry.nextTry = time.Time{}
+	}
+	if err := l.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(s.Bodies()); n != 3 {
+		t.Errorf("expected 3 pushes, got %d", n)
+	}
+	if len(readAll(t, l.Buffer)) != 0 {
+		t.Errorf("the pushed chunk is still buffered")
+	}
+}
+
+func TestLokiRejected(t *testing.T) {
+	s := newHTTPServer(t, http.StatusBadRequest)
+	defer s.Close()
+	l, cleanup := newTestLoki(t, s)
+	defer cleanup()
+
+	if err := l.Write([]Record{lokiRecord("a", "line", time.Now())}); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Flush(); err == nil {
+		t.Error("a rejected push returned no error")
+	}
+	if len(readAll(t, l.Buffer)) != 0 {
+		t.Errorf("the rejected chunk is still buffered")
+	}
+	if err := l.Flush(); err != nil || len(s.Bodies()) != 1 {
+		t.Errorf("the rejected chunk was pushed again, %v", err)
+	}
+}
+
+func lokiValues(t *testing.T, body []byte) [][2]string {
+	var push lokiPush
+	if err := json.Unmarshal(body, &push); err != nil {
+		t.Fatal(err)
+	}
+	var values [][2]string
+	for _, s := range push.Streams {
+		values = append(values, s.Values...)
+	}
+	return values
+}
+
+func TestLokiStreamStaysInOrder(t *testing.T) {
+	s := newHTTPServer(t, http.StatusServiceUnavailable)
+	defer s.Close()
+	l, cleanup := newTestLoki(t, s)
+	defer cleanup()
+	l.BatchSize = 1
+
+	at := time.Unix(1500000000, 0)
+	// two files of the same container feed one stream, the second chunk holds an older line
+	for _, r := range []Record{lokiRecord("a", "first", at.Add(2*time.Second)), lokiRecord("a", "second", at.Add(3*time.Second)), lokiRecord("a", "late", at)} {
+		if err := l.Write([]Record{r}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Flush(); err == nil {
+		t.Fatal("flush returned no error for a 503")
+	}
+	l.retry.nextTry = time.Time{}
+	if err := l.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got [][2]string
+	for _, body := range s.Bodies() {
+		got = append(got, lokiValues(t, body)...)
+	}
+	want := [][2]string{
+		{"1500000002000000000", "first"},
+		{"1500000002000000000", "first"},
+		{"1500000003000000000", "second"},
+		{"1500000003000000000", "late"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("pushed %v, expected %v", got, want)
+	}
+}
@@ -0,0 +1,62 @@
+// Package output ships the records of the in process tailer.
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const TypeStdout = "stdout"
+
+// Record is one log line with the fields describing where it came from.
+type Record struct {
+	Tag    string
+	Time   time.Time
+	Fields map[string]interface{}
+}
+
+// Output is handed the records of one file at a time, an error means none of them were accepted.
+// Flush retries what a buffering output could not ship yet.
+type Output interface {
+	Write([]Record) error
+	Flush() error
+	Close() error
+}
+
+// Stdout writes the records as JSON lines, for nodes that collect container output anyway.
+type Stdout struct {
+	W io.Writer
+
+	lock sync.Mutex
+}
+
+type stdoutRecord struct {
+	Tag    string                 `json:"tag"`
+	Time   time.Time              `json:"time"`
+	Record map[string]interface{} `json:"record"`
+}
+
+func (s *Stdout) Write(records []Record) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	enc := json.NewEncoder(s.W)
+	enc.SetEscapeHTML(false)
+	for _, v := range records {
+		if err := enc.Encode(stdoutRecord{Tag: v.Tag, Time: v.Time, Record: v.Fields}); err != nil {
+			return fmt.Errorf("write record failed, %v", err)
+		}
+	}
+	return nil
+}
+
+func (s *Stdout) Flush() error {
+	return nil
+}
+
+func (s *Stdout) Close() error {
+	return nil
+}
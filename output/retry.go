@@ -0,0 +1,148 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/rancher/log-aggregator/msgpack"
+)
+
+const maxRetryWait = 5 * time.Minute
+
+// PermanentError is a rejection retrying will not fix, like a malformed request, the chunks are dropped.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+// PartialError is a send that failed for a part of the batch only, that part was buffered again. The
+// batch is removed and the destination retried after the backoff.
+type PartialError struct {
+	Err error
+}
+
+func (e *PartialError) Error() string {
+	return e.Err.Error()
+}
+
+// retry gates the sends to a destination with an exponential backoff after failures.
+type retry struct {
+	dest    string
+	wait    time.Duration
+	nextTry time.Time
+	lastErr error
+}
+
+// flush sends the buffered chunks oldest first, batched until a batch holds batchBytes of data.
+// Chunks are loaded batch by batch, a batch is removed from the buffer once sent or permanently rejected.
+func (r *retry) flush(b *Buffer, batchBytes int, send func([]Chunk) error) error {
+	if time.Now().Before(r.nextTry) {
+		return r.lastErr
+	}
+
+	files, err := b.Chunks()
+	if err != nil {
+		return err
+	}
+
+	var (
+		dropped error
+		next    *Chunk
+	)
+	for {
+		var batch []Chunk
+		size := 0
+		for {
+			if next == nil {
+				if len(files) == 0 {
+					break
+				}
+				c, err := b.Read(files[0])
+				files = files[1:]
+				if err != nil {
+					continue
+				}
+				next = &c
+			}
+			if len(batch) != 0 && size+len(next.Data) > batchBytes {
+				break
+			}
+			batch = append(batch, *next)
+			size += len(next.Data)
+			next = nil
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		err := send(batch)
+		switch e := err.(type) {
+		case nil:
+		case *PermanentError:
+			dropped = fmt.Errorf("%s rejected %d chunks, dropped them, %v", r.dest, len(batch), e.Err)
+		case *PartialError:
+			if err := removeChunks(b, batch); err != nil {
+				return err
+			}
+			r.backoff(e.Err)
+			return r.lastErr
+		default:
+			r.backoff(err)
+			return r.lastErr
+		}
+		if err := removeChunks(b, batch); err != nil {
+			return err
+		}
+	}
+	r.wait, r.nextTry, r.lastErr = 0, time.Time{}, nil
+	return dropped
+}
+
+func removeChunks(b *Buffer, chunks []Chunk) error {
+	for _, c := range chunks {
+		if err := b.Remove(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *retry) backoff(err error) {
+	if r.wait == 0 {
+		r.wait = time.Second
+	} else if r.wait *= 2; r.wait > maxRetryWait {
+		r.wait = maxRetryWait
+	}
+	r.nextTry = time.Now().Add(r.wait)
+	r.lastErr = fmt.Errorf("send to %s failed, retry in %s, %v", r.dest, r.wait, err)
+}
+
+// unpackEntries decodes the [time, record] entries packEntries put in a chunk.
+func unpackEntries(c Chunk) ([]Record, error) {
+	dec := msgpack.NewDecoder(bytes.NewReader(c.Data))
+	records := make([]Record, 0, c.Count)
+	for i := 0; i < c.Count; i++ {
+		v, err := dec.Decode()
+		if err != nil {
+			return nil, fmt.Errorf("decode entry %d of chunk failed, %v", i, err)
+		}
+		entry, ok := v.([]interface{})
+		if !ok || len(entry) != 2 {
+			return nil, fmt.Errorf("invalid entry %d of chunk", i)
+		}
+		t, err := msgpack.Time(entry[0])
+		if err != nil {
+			return nil, err
+		}
+		fields, ok := entry[1].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid record %d of chunk", i)
+		}
+		records = append(records, Record{Tag: c.Tag, Time: t, Fields: fields})
+	}
+	return records, nil
+}
@@ -0,0 +1,64 @@
+package parser
+
+import (
+	"regexp"
+	"strconv"
+)
+
+const accessTimeFormat = "%d/%b/%Y:%H:%M:%S %z"
+
+// the expressions of the fluentd apache2 and nginx parsers
+var (
+	apache2Regexp = regexp.MustCompile(`^(?P<host>[^ ]*) [^ ]* (?P<user>[^ ]*) \[(?P<time>[^\]]*)\] "(?P<method>\S+)(?: +(?P<path>(?:[^\"]|\\.)*?)(?: +\S*)?)?" (?P<code>[^ ]*) (?P<size>[^ ]*)(?: "(?P<referer>(?:[^\"]|\\.)*)" "(?P<agent>(?:[^\"]|\\.)*)")?$`)
+	nginxRegexp   = regexp.MustCompile(`^(?P<remote>[^ ]*) (?P<host>[^ ]*) (?P<user>[^ ]*) \[(?P<time>[^\]]*)\] "(?P<method>\S+)(?: +(?P<path>[^\"]*?)(?: +\S*)?)?" (?P<code>[^ ]*) (?P<size>[^ ]*)(?: "(?P<referer>[^\"]*)" "(?P<agent>[^\"]*)"(?:\s+(?P<http_x_forwarded_for>[^ ]+))?)?$`)
+)
+
+var (
+	apache2Fields     = []string{"host", "user", "method", "path", "code", "size", "referer", "agent"}
+	apache2DashFields = []string{"host", "user", "referer", "agent"}
+)
+
+// apache2Parser differs from a plain regexp parser the way fluentd's does: every field is set, a -
+// is nil and code and size are numbers.
+type apache2Parser struct {
+	*regexpParser
+}
+
+func newApache2Parser(opts Options) (*apache2Parser, error) {
+	p, err := newRegexpParser(apache2Regexp, accessTimeFormat, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &apache2Parser{p}, nil
+}
+
+func (p *apache2Parser) Parse(line string) (Record, error) {
+	fields, ok := p.match(line)
+	if !ok {
+		return Record{}, ErrNoMatch
+	}
+
+	for _, name := range apache2Fields {
+		if _, ok := fields[name]; !ok {
+			fields[name] = nil
+		}
+	}
+	for _, name := range apache2DashFields {
+		if fields[name] == "-" {
+			fields[name] = nil
+		}
+	}
+	if code, _ := fields["code"].(string); code != "" {
+		n, _ := strconv.ParseInt(leadingNumber(code, false), 10, 64)
+		if fields["code"] = n; n == 0 {
+			fields["code"] = nil
+		}
+	}
+	if size, _ := fields["size"].(string); size == "-" {
+		fields["size"] = nil
+	} else if size != "" {
+		n, _ := strconv.ParseInt(leadingNumber(size, false), 10, 64)
+		fields["size"] = n
+	}
+	return finish(fields, p.time, p.opts)
+}
@@ -0,0 +1,55 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// jsonParser reads one JSON object per line, numbers without a fraction become int64 like in ruby.
+type jsonParser struct {
+	time *timeParser
+	opts Options
+}
+
+func newJSONParser(opts Options) (*jsonParser, error) {
+	tp, err := newTimeParser(opts.TimeFormat, opts.Location)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonParser{time: tp, opts: opts}, nil
+}
+
+func (p *jsonParser) Parse(line string) (Record, error) {
+	dec := json.NewDecoder(bytes.NewReader([]byte(line)))
+	dec.UseNumber()
+	var fields map[string]interface{}
+	if err := dec.Decode(&fields); err != nil || fields == nil {
+		return Record{}, ErrNoMatch
+	}
+	for k, v := range fields {
+		fields[k] = normalizeNumbers(v)
+	}
+	return finish(fields, p.time, p.opts)
+}
+
+func normalizeNumbers(v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		if n, err := t.Int64(); err == nil {
+			return n
+		}
+		if f, err := t.Float64(); err == nil {
+			return f
+		}
+		return t.String()
+	case map[string]interface{}:
+		for k, e := range t {
+			t[k] = normalizeNumbers(e)
+		}
+	case []interface{}:
+		for i, e := range t {
+			t[i] = normalizeNumbers(e)
+		}
+	}
+	return v
+}
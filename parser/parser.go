@@ -0,0 +1,108 @@
+// Package parser parses log lines like the fluentd parsers behind the predefined formats of a log
+// volume, so components without fluentd produce the same records from the same lines.
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	FormatJSON    = "json"
+	FormatApache2 = "apache2"
+	FormatNginx   = "nginx"
+	FormatRFC3164 = "rfc3164"
+	FormatRFC5424 = "rfc5424"
+	FormatNone    = "none"
+
+	DefaultTimeKey    = "time"
+	DefaultMessageKey = "message"
+)
+
+// Predefined are the formats besides /regex/.
+var Predefined = []string{FormatJSON, FormatApache2, FormatNginx, FormatRFC3164, FormatRFC5424, FormatNone}
+
+// ErrNoMatch is returned for a line the format does not describe, fluentd reports it as a pattern
+// not matched.
+var ErrNoMatch = errors.New("pattern not matched")
+
+// Record is a parsed line, Time is the current time when the line has none.
+type Record struct {
+	Time   time.Time
+	Fields map[string]interface{}
+}
+
+type Parser interface {
+	Parse(line string) (Record, error)
+}
+
+// Options are the parse parameters of fluentd, zero values mean the fluentd defaults.
+type Options struct {
+	// TimeKey is the field holding the time, DefaultTimeKey when empty
+	TimeKey string
+	// TimeFormat is a strptime format, the format's own when empty
+	TimeFormat string
+	// Location applies to times without an offset, the local time zone when nil
+	Location    *time.Location
+	KeepTimeKey bool
+	Types       Types
+	// WithPriority expects syslog lines to start with <pri>
+	WithPriority bool
+	// Now is the time of lines without one, time.Now when nil
+	Now func() time.Time
+}
+
+func (o Options) timeKey() string {
+	if o.TimeKey == "" {
+		return DefaultTimeKey
+	}
+	return o.TimeKey
+}
+
+func (o Options) now() time.Time {
+	if o.Now == nil {
+		return time.Now()
+	}
+	return o.Now()
+}
+
+// New returns the parser of a volume format, a predefined one or /regex/ with named groups.
+func New(format string, opts Options) (Parser, error) {
+	if opts.Location == nil {
+		opts.Location = time.Local
+	}
+
+	switch {
+	case format == FormatJSON:
+		return newJSONParser(opts)
+	case format == FormatApache2:
+		return newApache2Parser(opts)
+	case format == FormatNginx:
+		return newRegexpParser(nginxRegexp, accessTimeFormat, opts)
+	case format == FormatRFC3164:
+		return newRFC3164Parser(opts)
+	case format == FormatRFC5424:
+		return newRFC5424Parser(opts)
+	case format == FormatNone:
+		return &noneParser{opts: opts}, nil
+	case IsRegexp(format):
+		re, err := CompileRegexp(format)
+		if err != nil {
+			return nil, err
+		}
+		return newRegexpParser(re, opts.TimeFormat, opts)
+	default:
+		return nil, fmt.Errorf("unsupported format %s, expected /regex/ or one of %s", format, strings.Join(Predefined, ", "))
+	}
+}
+
+// noneParser keeps the whole line as the message.
+type noneParser struct {
+	opts Options
+}
+
+func (p *noneParser) Parse(line string) (Record, error) {
+	return Record{Time: p.opts.now(), Fields: map[string]interface{}{DefaultMessageKey: line}}, nil
+}
@@ -0,0 +1,209 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var (
+	tokyo = time.FixedZone("+09:00", 9*3600)
+	now   = time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// golden is a line and the record fluentd emits for it with the same parameters, the expectations
+// are the examples of the fluentd parser documentation where there is one.
+type golden struct {
+	name   string
+	format string
+	opts   Options
+	line   string
+	time   time.Time
+	fields map[string]interface{}
+}
+
+func testOptions(opts Options) Options {
+	if opts.Location == nil {
+		opts.Location = time.UTC
+	}
+	opts.Now = func() time.Time { return now }
+	return opts
+}
+
+func checkGolden(t *testing.T, cases []golden) {
+	for _, v := range cases {
+		p, err := New(v.format, testOptions(v.opts))
+		if err != nil {
+			t.Errorf("%s: %v", v.name, err)
+			continue
+		}
+		r, err := p.Parse(v.line)
+		if err != nil {
+			t.Errorf("%s: parse %q failed, %v", v.name, v.line, err)
+			continue
+		}
+		if !r.Time.Equal(v.time) {
+			t.Errorf("%s: got time %s, expected %s", v.name, r.Time, v.time)
+		}
+		if !reflect.DeepEqual(r.Fields, v.fields) {
+			t.Errorf("%s: got fields %#v, expected %#v", v.name, r.Fields, v.fields)
+		}
+	}
+}
+
+func TestPredefinedFormats(t *testing.T) {
+	feb28 := time.Date(2013, 2, 28, 12, 0, 0, 0, tokyo)
+	checkGolden(t, []golden{
+		{
+			name:   "json",
+			format: FormatJSON,
+			line:   `{"time":1362020400,"host":"192.168.0.1","size":777,"method":"PUT","ratio":0.5,"tags":[1,"a"]}`,
+			time:   feb28,
+			fields: map[string]interface{}{"host": "192.168.0.1", "size": int64(777), "method": "PUT", "ratio": 0.5, "tags": []interface{}{int64(1), "a"}},
+		},
+		{
+			name:   "json without time",
+			format: FormatJSON,
+			line:   `{"log":"line"}`,
+			time:   now,
+			fields: map[string]interface{}{"log": "line"},
+		},
+		{
+			name:   "apache2",
+			format: FormatApache2,
+			line:   `192.168.0.1 - - [28/Feb/2013:12:00:00 +0900] "GET / HTTP/1.1" 200 777 "-" "Opera/12.0"`,
+			time:   feb28,
+			fields: map[string]interface{}{"host": "192.168.0.1", "user": nil, "method": "GET", "path": "/", "code": int64(200), "size": int64(777), "referer": nil, "agent": "Opera/12.0"},
+		},
+		{
+			name:   "apache2 common log",
+			format: FormatApache2,
+			line:   `192.168.0.1 - bob [28/Feb/2013:12:00:00 +0900] "POST /login HTTP/1.1" 302 -`,
+			time:   feb28,
+			fields: map[string]interface{}{"host": "192.168.0.1", "user": "bob", "method": "POST", "path": "/login", "code": int64(302), "size": nil, "referer": nil, "agent": nil},
+		},
+		{
+			name:   "nginx",
+			format: FormatNginx,
+			line:   `127.0.0.1 192.168.0.1 - [28/Feb/2013:12:00:00 +0900] "GET / HTTP/1.1" 200 777 "-" "Opera/12.0"`,
+			time:   feb28,
+			fields: map[string]interface{}{"remote": "127.0.0.1", "host": "192.168.0.1", "user": "-", "method": "GET", "path": "/", "code": "200", "size": "777", "referer": "-", "agent": "Opera/12.0"},
+		},
+		{
+			name:   "nginx forwarded for",
+			format: FormatNginx,
+			line:   `127.0.0.1 192.168.0.1 - [28/Feb/2013:12:00:00 +0900] "GET / HTTP/1.1" 200 777 "-" "Opera/12.0" 10.0.0.1`,
+			time:   feb28,
+			fields: map[string]interface{}{"remote": "127.0.0.1", "host": "192.168.0.1", "user": "-", "method": "GET", "path": "/", "code": "200", "size": "777", "referer": "-", "agent": "Opera/12.0", "http_x_forwarded_for": "10.0.0.1"},
+		},
+		{
+			name:   "rfc3164",
+			format: FormatRFC3164,
+			line:   `Feb 28 12:00:00 192.168.0.1 fluentd[11111]: [error] Syslog test`,
+			time:   time.Date(time.Now().UTC().Year(), 2, 28, 12, 0, 0, 0, time.UTC),
+			fields: map[string]interface{}{"host": "192.168.0.1", "ident": "fluentd", "pid": "11111", "message": "[error] Syslog test"},
+		},
+		{
+			name:   "rfc3164 with priority",
+			format: FormatRFC3164,
+			opts:   Options{WithPriority: true},
+			line:   `<6>Feb  8 12:00:00 192.168.0.1 fluentd: Syslog test`,
+			time:   time.Date(time.Now().UTC().Year(), 2, 8, 12, 0, 0, 0, time.UTC),
+			fields: map[string]interface{}{"pri": int64(6), "host": "192.168.0.1", "ident": "fluentd", "message": "Syslog test"},
+		},
+		{
+			name:   "rfc5424 with priority",
+			format: FormatRFC5424,
+			opts:   Options{WithPriority: true},
+			line:   `<16>1 2013-02-28T12:00:00.003Z 192.168.0.1 fluentd 11111 ID24224 [exampleSDID@32473 iut="3" eventSource="Application" eventID="1011"] Hi, from Fluentd!`,
+			time:   time.Date(2013, 2, 28, 12, 0, 0, 3000000, time.UTC),
+			fields: map[string]interface{}{"pri": int64(16), "host": "192.168.0.1", "ident": "fluentd", "pid": "11111", "msgid": "ID24224", "extradata": `[exampleSDID@32473 iut="3" eventSource="Application" eventID="1011"]`, "message": "Hi, from Fluentd!"},
+		},
+		{
+			name:   "rfc5424 escaped bracket without message",
+			format: FormatRFC5424,
+			line:   `2013-02-28T12:00:00+09:00 host app - - [id a="x\]y"]`,
+			time:   feb28,
+			fields: map[string]interface{}{"host": "host", "ident": "app", "pid": "-", "msgid": "-", "extradata": `[id a="x\]y"]`},
+		},
+		{
+			name:   "none",
+			format: FormatNone,
+			line:   `{"not":"parsed"}`,
+			time:   now,
+			fields: map[string]interface{}{"message": `{"not":"parsed"}`},
+		},
+	})
+}
+
+func TestRegexpFormat(t *testing.T) {
+	types, err := ParseTypes("id:integer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, []golden{
+		{
+			name:   "regexp with time key and types",
+			format: `/^\[(?<logtime>[^\]]*)\] (?<name>[^ ]*) (?<title>[^ ]*) (?<id>\d*)$/`,
+			opts:   Options{TimeKey: "logtime", TimeFormat: "%Y-%m-%d %H:%M:%S %z", Types: types},
+			line:   `[2013-02-28 12:00:00 +0900] alice engineer 1`,
+			time:   time.Date(2013, 2, 28, 12, 0, 0, 0, tokyo),
+			fields: map[string]interface{}{"name": "alice", "title": "engineer", "id": int64(1)},
+		},
+		{
+			name:   "regexp keeping the time key",
+			format: `/^(?<time>\S+) (?<level>\w+)(?: (?<message>.*))?$/`,
+			opts:   Options{TimeFormat: "%Y-%m-%dT%H:%M:%S.%L", KeepTimeKey: true, Location: tokyo},
+			line:   `2013-02-28T12:00:00.250 INFO`,
+			time:   time.Date(2013, 2, 28, 12, 0, 0, 250000000, tokyo),
+			fields: map[string]interface{}{"time": "2013-02-28T12:00:00.250", "level": "INFO"},
+		},
+		{
+			name:   "regexp with epoch time",
+			format: `/^(?<time>\S+) (?<message>.*)$/`,
+			opts:   Options{TimeFormat: "%s"},
+			line:   `1362020400.5 message`,
+			time:   time.Unix(1362020400, 500000000),
+			fields: map[string]interface{}{"message": "message"},
+		},
+	})
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := []struct {
+		format string
+		opts   Options
+		line   string
+	}{
+		{FormatJSON, Options{}, `not json`},
+		{FormatJSON, Options{}, `["array"]`},
+		{FormatApache2, Options{}, `GET / HTTP/1.1`},
+		{FormatRFC5424, Options{WithPriority: true}, `2013-02-28T12:00:00Z host app - - -`},
+		{`/^(?<a>\d+)$/`, Options{}, `abc`},
+	}
+	for _, v := range cases {
+		p, err := New(v.format, testOptions(v.opts))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := p.Parse(v.line); err != ErrNoMatch {
+			t.Errorf("format %s parsed %q with %v, expected ErrNoMatch", v.format, v.line, err)
+		}
+	}
+
+	p, err := New(FormatJSON, testOptions(Options{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Parse(`{"time":"yesterday"}`); err == nil || err == ErrNoMatch {
+		t.Errorf("an invalid time returned %v", err)
+	}
+}
+
+func TestNewInvalidFormat(t *testing.T) {
+	for _, format := range []string{"ltsv", "/unclosed(/", "/(?<=lookbehind)a/", "/a/x"} {
+		if _, err := New(format, Options{}); err == nil {
+			t.Errorf("format %s was accepted", format)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// IsRegexp reports whether a volume format is a /regex/ instead of a predefined format.
+func IsRegexp(format string) bool {
+	return len(format) >= 2 && strings.HasPrefix(format, "/") && strings.HasSuffix(format, "/")
+}
+
+// CompileRegexp compiles a /regex/ format, a ruby regex whose named groups are spelled the go way
+// first. Ruby only features like lookbehind are not supported.
+func CompileRegexp(format string) (*regexp.Regexp, error) {
+	expr := strings.TrimSuffix(strings.TrimPrefix(format, "/"), "/")
+	re, err := regexp.Compile(strings.Replace(expr, "(?<", "(?P<", -1))
+	if err != nil {
+		return nil, fmt.Errorf("format %q is not a valid regular expression, %v", format, err)
+	}
+	return re, nil
+}
+
+// regexpParser puts every named group that took part in the match into the record, the time group
+// is parsed into the record time.
+type regexpParser struct {
+	re   *regexp.Regexp
+	time *timeParser
+	opts Options
+}
+
+func newRegexpParser(re *regexp.Regexp, timeFormat string, opts Options) (*regexpParser, error) {
+	if opts.TimeFormat != "" {
+		timeFormat = opts.TimeFormat
+	}
+	tp, err := newTimeParser(timeFormat, opts.Location)
+	if err != nil {
+		return nil, err
+	}
+	return &regexpParser{re: re, time: tp, opts: opts}, nil
+}
+
+func (p *regexpParser) Parse(line string) (Record, error) {
+	fields, ok := p.match(line)
+	if !ok {
+		return Record{}, ErrNoMatch
+	}
+	return finish(fields, p.time, p.opts)
+}
+
+func (p *regexpParser) match(line string) (map[string]interface{}, bool) {
+	m := p.re.FindStringSubmatchIndex(line)
+	if m == nil {
+		return nil, false
+	}
+	fields := map[string]interface{}{}
+	for i, name := range p.re.SubexpNames() {
+		if name == "" || m[2*i] < 0 {
+			continue
+		}
+		fields[name] = line[m[2*i]:m[2*i+1]]
+	}
+	return fields, true
+}
+
+// finish converts the typed fields and moves the time field into the record time, like the parser
+// base of fluentd does for every format.
+func finish(fields map[string]interface{}, tp *timeParser, opts Options) (Record, error) {
+	record := Record{Fields: fields}
+	if err := opts.Types.convert(fields, opts.Location); err != nil {
+		return Record{}, err
+	}
+
+	key := opts.timeKey()
+	v, ok := fields[key]
+	if !ok || v == nil {
+		record.Time = opts.now()
+		return record, nil
+	}
+	t, err := tp.parse(v)
+	if err != nil {
+		return Record{}, err
+	}
+	record.Time = t
+	if !opts.KeepTimeKey {
+		delete(fields, key)
+	}
+	return record, nil
+}
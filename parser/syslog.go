@@ -0,0 +1,74 @@
+package parser
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	rfc3164TimeFormat = "%b %d %H:%M:%S"
+	rfc5424TimeFormat = "%Y-%m-%dT%H:%M:%S%:z"
+)
+
+// the expressions of the fluentd syslog parser, the lookbehind ending the rfc5424 structured data
+// is spelled as an escape aware character class
+var (
+	rfc3164Regexp         = regexp.MustCompile(`^(?P<time>[^ ]*\s*[^ ]* [^ ]*) (?P<host>[^ ]*) (?P<ident>[^ :\[]*)(?:\[(?P<pid>[0-9]+)\])?(?:[^\:]*\:)? *(?P<message>.*)$`)
+	rfc3164PriorityRegexp = regexp.MustCompile(`^\<(?P<pri>[0-9]+)\>(?P<time>[^ ]* {1,2}[^ ]* [^ ]*) (?P<host>[^ ]*) (?P<ident>[^ :\[]*)(?:\[(?P<pid>[0-9]+)\])?(?:[^\:]*\:)? *(?P<message>.*)$`)
+	rfc5424Regexp         = regexp.MustCompile(`(?s)\A(?P<time>[^ ]+) (?P<host>[!-~]{1,255}) (?P<ident>[!-~]{1,48}) (?P<pid>[!-~]{1,128}) (?P<msgid>[!-~]{1,32}) (?P<extradata>(?:\-|(?:\[(?:[^\]\\]|\\.)*\])+))(?: (?P<message>.+))?\z`)
+	rfc5424PriorityRegexp = regexp.MustCompile(`(?s)\A\<(?P<pri>[0-9]{1,3})\>[1-9]\d{0,2} (?P<time>[^ ]+) (?P<host>[!-~]{1,255}) (?P<ident>[!-~]{1,48}) (?P<pid>[!-~]{1,128}) (?P<msgid>[!-~]{1,32}) (?P<extradata>(?:\-|(?:\[(?:[^\]\\]|\\.)*\])+))(?: (?P<message>.+))?\z`)
+)
+
+// syslogParser is a regexp parser whose pri is a number, the time has no year in rfc3164 and
+// repeated spaces in it are squeezed first.
+type syslogParser struct {
+	*regexpParser
+}
+
+func newRFC3164Parser(opts Options) (*syslogParser, error) {
+	re := rfc3164Regexp
+	if opts.WithPriority {
+		re = rfc3164PriorityRegexp
+	}
+	p, err := newRegexpParser(re, rfc3164TimeFormat, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogParser{p}, nil
+}
+
+func newRFC5424Parser(opts Options) (*syslogParser, error) {
+	re := rfc5424Regexp
+	if opts.WithPriority {
+		re = rfc5424PriorityRegexp
+	}
+	p, err := newRegexpParser(re, rfc5424TimeFormat, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogParser{p}, nil
+}
+
+func (p *syslogParser) Parse(line string) (Record, error) {
+	fields, ok := p.match(line)
+	if !ok {
+		return Record{}, ErrNoMatch
+	}
+
+	if pri, ok := fields["pri"].(string); ok {
+		n, _ := strconv.ParseInt(pri, 10, 64)
+		fields["pri"] = n
+	}
+	if t, ok := fields["time"].(string); ok {
+		fields["time"] = squeezeSpaces(t)
+	}
+	return finish(fields, p.time, p.opts)
+}
+
+func squeezeSpaces(s string) string {
+	for strings.Contains(s, "  ") {
+		s = strings.Replace(s, "  ", " ", -1)
+	}
+	return s
+}
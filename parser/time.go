@@ -0,0 +1,188 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// strptimeLayouts maps the strptime directives fluentd time formats use to go layouts, %d takes one
+// digit days like ruby does.
+var strptimeLayouts = map[byte]string{
+	'Y': "2006",
+	'y': "06",
+	'm': "01",
+	'd': "_2",
+	'e': "_2",
+	'H': "15",
+	'I': "03",
+	'M': "04",
+	'S': "05",
+	'p': "PM",
+	'b': "Jan",
+	'h': "Jan",
+	'B': "January",
+	'a': "Mon",
+	'A': "Monday",
+	'z': "Z0700",
+	'Z': "MST",
+	'T': "15:04:05",
+	'F': "2006-01-02",
+	'D': "01/02/06",
+	'R': "15:04",
+	'%': "%",
+}
+
+// defaultLayouts stand in for the ruby Time.parse fluentd falls back to without a time format.
+var defaultLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05 Z0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"02/Jan/2006:15:04:05 Z0700",
+	time.RFC1123Z,
+	time.RFC1123,
+	time.ANSIC,
+	time.UnixDate,
+	"Jan _2 15:04:05",
+}
+
+// ParseTimezone accepts the fluentd timezone parameter, an offset like +09:00 or a zone name.
+func ParseTimezone(tz string) (*time.Location, error) {
+	if tz == "" {
+		return time.Local, nil
+	}
+	if tz[0] == '+' || tz[0] == '-' {
+		offset := strings.Replace(tz[1:], ":", "", 1)
+		if len(offset) != 4 {
+			return nil, fmt.Errorf("invalid timezone offset %s", tz)
+		}
+		hours, err1 := strconv.Atoi(offset[:2])
+		minutes, err2 := strconv.Atoi(offset[2:])
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("invalid timezone offset %s", tz)
+		}
+		seconds := hours*3600 + minutes*60
+		if tz[0] == '-' {
+			seconds = -seconds
+		}
+		return time.FixedZone(tz, seconds), nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %s, %v", tz, err)
+	}
+	return loc, nil
+}
+
+// StrptimeLayout converts a strptime format to a go layout. Fractional seconds are only supported
+// right after the seconds, where go parses them without a layout element.
+func StrptimeLayout(format string) (string, error) {
+	var layout []byte
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' {
+			layout = append(layout, c)
+			continue
+		}
+		if i++; i == len(format) {
+			return "", fmt.Errorf("time format %s ends with %%", format)
+		}
+
+		d := format[i]
+		if d == ':' && i+1 < len(format) && format[i+1] == 'z' {
+			i++
+			layout = append(layout, "Z07:00"...)
+			continue
+		}
+		// %L, %N and %3N..%9N
+		if d >= '1' && d <= '9' && i+1 < len(format) && format[i+1] == 'N' {
+			i++
+			d = 'N'
+		}
+		if d == 'L' || d == 'N' {
+			if len(layout) == 0 || layout[len(layout)-1] != '.' {
+				return "", fmt.Errorf("time format %s has fractional seconds not following seconds", format)
+			}
+			layout = layout[:len(layout)-1]
+			continue
+		}
+
+		l, ok := strptimeLayouts[d]
+		if !ok {
+			return "", fmt.Errorf("time format %s uses unsupported directive %%%c", format, d)
+		}
+		layout = append(layout, l...)
+	}
+	return string(layout), nil
+}
+
+type timeParser struct {
+	layouts []string
+	epoch   bool
+	loc     *time.Location
+}
+
+func newTimeParser(format string, loc *time.Location) (*timeParser, error) {
+	p := &timeParser{loc: loc}
+	switch format {
+	case "":
+		p.layouts = defaultLayouts
+	case "%s":
+		p.epoch = true
+	default:
+		layout, err := StrptimeLayout(format)
+		if err != nil {
+			return nil, err
+		}
+		p.layouts = []string{layout}
+		// ruby takes +09:00 for %z too
+		if strings.Contains(layout, "Z0700") {
+			p.layouts = append(p.layouts, strings.Replace(layout, "Z0700", "Z07:00", -1))
+		}
+	}
+	return p, nil
+}
+
+// parse takes numbers as unix seconds, the way json times without a time format are read.
+func (p *timeParser) parse(v interface{}) (time.Time, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, nil
+	case float64:
+		sec, frac := math.Modf(t)
+		return time.Unix(int64(sec), int64(frac*1e9)), nil
+	case int64:
+		return time.Unix(t, 0), nil
+	case string:
+		return p.parseString(t)
+	default:
+		return time.Time{}, fmt.Errorf("invalid time value %v", v)
+	}
+}
+
+func (p *timeParser) parseString(value string) (time.Time, error) {
+	if p.epoch {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid time value %q, expected unix seconds", value)
+		}
+		return p.parse(f)
+	}
+
+	for _, layout := range p.layouts {
+		t, err := time.ParseInLocation(layout, value, p.loc)
+		if err != nil {
+			continue
+		}
+		// a time without a year is in the current one
+		if t.Year() == 0 {
+			t = t.AddDate(time.Now().In(p.loc).Year(), 0, 0)
+		}
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time value %q", value)
+}
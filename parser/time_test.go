@@ -0,0 +1,101 @@
+package parser
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStrptimeLayout(t *testing.T) {
+	cases := []struct {
+		format string
+		layout string
+	}{
+		{"%d/%b/%Y:%H:%M:%S %z", "_2/Jan/2006:15:04:05 Z0700"},
+		{"%Y-%m-%dT%H:%M:%S%:z", "2006-01-_2T15:04:05Z07:00"},
+		{"%Y-%m-%dT%H:%M:%S.%L", "2006-01-_2T15:04:05"},
+		{"%F %T.%6N %Z", "2006-01-02 15:04:05 MST"},
+		{"%I:%M %p 100%%", "03:04 PM 100%"},
+	}
+	for _, v := range cases {
+		layout, err := StrptimeLayout(v.format)
+		if err != nil {
+			t.Errorf("format %s failed, %v", v.format, err)
+			continue
+		}
+		if layout != v.layout {
+			t.Errorf("format %s got layout %s, expected %s", v.format, layout, v.layout)
+		}
+	}
+
+	for _, format := range []string{"%Y%", "%Y %L", "%Q"} {
+		if _, err := StrptimeLayout(format); err == nil {
+			t.Errorf("format %s was accepted", format)
+		}
+	}
+}
+
+func TestParseTimezone(t *testing.T) {
+	cases := []struct {
+		tz     string
+		offset int
+	}{
+		{"+09:00", 9 * 3600},
+		{"-0530", -(5*3600 + 30*60)},
+		{"UTC", 0},
+	}
+	at := time.Date(2013, 2, 28, 12, 0, 0, 0, time.UTC)
+	for _, v := range cases {
+		loc, err := ParseTimezone(v.tz)
+		if err != nil {
+			t.Errorf("timezone %s failed, %v", v.tz, err)
+			continue
+		}
+		if _, offset := at.In(loc).Zone(); offset != v.offset {
+			t.Errorf("timezone %s has offset %d, expected %d", v.tz, offset, v.offset)
+		}
+	}
+	if loc, err := ParseTimezone(""); err != nil || loc != time.Local {
+		t.Errorf("an empty timezone is %v, %v", loc, err)
+	}
+
+	for _, tz := range []string{"+9", "+0a:00", "Nowhere/Town"} {
+		if _, err := ParseTimezone(tz); err == nil {
+			t.Errorf("timezone %s was accepted", tz)
+		}
+	}
+}
+
+// TestTimeFallbacks covers the formats ruby Time.parse reads when no time format is set.
+func TestTimeFallbacks(t *testing.T) {
+	p, err := newTimeParser("", tokyo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2013, 2, 28, 12, 0, 0, 0, tokyo)
+	for _, v := range []interface{}{
+		"2013-02-28T12:00:00+09:00",
+		"2013-02-28T03:00:00Z",
+		"2013-02-28 12:00:00",
+		"2013-02-28T12:00:00",
+		"28/Feb/2013:12:00:00 +0900",
+		"Thu, 28 Feb 2013 12:00:00 +0900",
+		int64(1362020400),
+		1362020400.0,
+	} {
+		got, err := p.parse(v)
+		if err != nil {
+			t.Errorf("time %v failed, %v", v, err)
+			continue
+		}
+		if !got.Equal(want) {
+			t.Errorf("time %v is %s, expected %s", v, got, want)
+		}
+	}
+
+	if _, err := p.parse("28.02.2013"); err == nil {
+		t.Errorf("an unknown time layout was accepted")
+	}
+	if _, err := p.parse(true); err == nil {
+		t.Errorf("a bool time was accepted")
+	}
+}
@@ -0,0 +1,146 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TypeString  = "string"
+	TypeBool    = "bool"
+	TypeInteger = "integer"
+	TypeFloat   = "float"
+	TypeTime    = "time"
+	TypeArray   = "array"
+)
+
+var FieldTypes = []string{TypeString, TypeBool, TypeInteger, TypeFloat, TypeTime, TypeArray}
+
+// FieldType is one entry of the fluentd types parameter, Arg is the time format of a time and the
+// delimiter of an array.
+type FieldType struct {
+	Type string
+	Arg  string
+}
+
+// Types maps fields to the type their value is converted to.
+type Types map[string]FieldType
+
+// ParseTypes parses the fluentd types parameter, <field>:<type>[:<arg>] separated by commas.
+func ParseTypes(s string) (Types, error) {
+	types := Types{}
+	if s == "" {
+		return types, nil
+	}
+	for _, v := range strings.Split(s, ",") {
+		parts := strings.SplitN(v, ":", 3)
+		if len(parts) < 2 || parts[0] == "" || !isFieldType(parts[1]) {
+			return nil, fmt.Errorf("types entry %q should be <field>:<type>, type one of %s", v, strings.Join(FieldTypes, ", "))
+		}
+		t := FieldType{Type: parts[1]}
+		if len(parts) == 3 {
+			if t.Type != TypeTime && t.Type != TypeArray {
+				return nil, fmt.Errorf("types entry %q only time and array take a third part", v)
+			}
+			t.Arg = parts[2]
+		}
+		types[parts[0]] = t
+	}
+	return types, nil
+}
+
+func isFieldType(t string) bool {
+	for _, v := range FieldTypes {
+		if v == t {
+			return true
+		}
+	}
+	return false
+}
+
+func (t Types) convert(fields map[string]interface{}, loc *time.Location) error {
+	for name, ft := range t {
+		v, ok := fields[name]
+		if !ok || v == nil {
+			continue
+		}
+		converted, err := ft.convert(v, loc)
+		if err != nil {
+			return fmt.Errorf("convert %s to %s failed, %v", name, ft.Type, err)
+		}
+		fields[name] = converted
+	}
+	return nil
+}
+
+// convert follows the ruby conversions fluentd uses, an integer is the leading digits, 0 without any.
+func (ft FieldType) convert(v interface{}, loc *time.Location) (interface{}, error) {
+	s, isString := v.(string)
+	if !isString {
+		s = fmt.Sprint(v)
+	}
+
+	switch ft.Type {
+	case TypeString:
+		return s, nil
+	case TypeInteger:
+		if f, ok := v.(float64); ok {
+			return int64(f), nil
+		}
+		n, _ := strconv.ParseInt(leadingNumber(s, false), 10, 64)
+		return n, nil
+	case TypeFloat:
+		f, _ := strconv.ParseFloat(leadingNumber(s, true), 64)
+		return f, nil
+	case TypeBool:
+		switch s {
+		case "true", "yes":
+			return true, nil
+		case "false", "no":
+			return false, nil
+		}
+		return nil, nil
+	case TypeTime:
+		tp, err := newTimeParser(ft.Arg, loc)
+		if err != nil {
+			return nil, err
+		}
+		return tp.parse(v)
+	case TypeArray:
+		delimiter := ft.Arg
+		if delimiter == "" {
+			delimiter = ","
+		}
+		if list, ok := v.([]interface{}); ok {
+			return list, nil
+		}
+		var list []interface{}
+		for _, e := range strings.Split(s, delimiter) {
+			list = append(list, e)
+		}
+		return list, nil
+	}
+	return v, nil
+}
+
+func leadingNumber(s string, float bool) string {
+	s = strings.TrimSpace(s)
+	end, dot, exp := 0, false, false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= '0' && c <= '9':
+		case (c == '-' || c == '+') && (i == 0 || float && (s[i-1] == 'e' || s[i-1] == 'E')):
+		case c == '.' && float && !dot && !exp:
+			dot = true
+		case (c == 'e' || c == 'E') && float && !exp && end > 0:
+			exp = true
+		default:
+			return strings.TrimRight(s[:end], "+-eE.")
+		}
+		end = i + 1
+	}
+	return strings.TrimRight(s[:end], "+-eE.")
+}
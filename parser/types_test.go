@@ -0,0 +1,76 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseTypes(t *testing.T) {
+	types, err := ParseTypes("code:integer,at:time:%d/%b/%Y,tags:array:|,ok:bool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Types{
+		"code": {Type: TypeInteger},
+		"at":   {Type: TypeTime, Arg: "%d/%b/%Y"},
+		"tags": {Type: TypeArray, Arg: "|"},
+		"ok":   {Type: TypeBool},
+	}
+	if !reflect.DeepEqual(types, want) {
+		t.Errorf("got types %+v, expected %+v", types, want)
+	}
+
+	for _, v := range []string{"code", ":integer", "code:int", "code:integer:x", "a:string,b"} {
+		if _, err := ParseTypes(v); err == nil {
+			t.Errorf("types %q were accepted", v)
+		}
+	}
+}
+
+// TestTypeConversions compares with the ruby conversions of the fluentd type converters.
+func TestTypeConversions(t *testing.T) {
+	cases := []struct {
+		ft   FieldType
+		in   interface{}
+		want interface{}
+	}{
+		{FieldType{Type: TypeString}, int64(12), "12"},
+		{FieldType{Type: TypeInteger}, "12", int64(12)},
+		{FieldType{Type: TypeInteger}, "-12abc", int64(-12)},
+		{FieldType{Type: TypeInteger}, "abc", int64(0)},
+		{FieldType{Type: TypeInteger}, " 7 ", int64(7)},
+		{FieldType{Type: TypeInteger}, 3.9, int64(3)},
+		{FieldType{Type: TypeFloat}, "1.5e3x", 1500.0},
+		{FieldType{Type: TypeFloat}, "2.", 2.0},
+		{FieldType{Type: TypeFloat}, "x", 0.0},
+		{FieldType{Type: TypeBool}, "yes", true},
+		{FieldType{Type: TypeBool}, "false", false},
+		{FieldType{Type: TypeBool}, "1", nil},
+		{FieldType{Type: TypeArray}, "a,b,,c", []interface{}{"a", "b", "", "c"}},
+		{FieldType{Type: TypeArray, Arg: "|"}, "a|b", []interface{}{"a", "b"}},
+		{FieldType{Type: TypeArray}, []interface{}{"a"}, []interface{}{"a"}},
+		{FieldType{Type: TypeTime, Arg: "%d/%b/%Y"}, "28/Feb/2013", time.Date(2013, 2, 28, 0, 0, 0, 0, time.UTC)},
+		{FieldType{Type: TypeTime}, int64(1362020400), time.Unix(1362020400, 0)},
+	}
+	for _, v := range cases {
+		got, err := v.ft.convert(v.in, time.UTC)
+		if err != nil {
+			t.Errorf("convert %v to %+v failed, %v", v.in, v.ft, err)
+			continue
+		}
+		if gt, ok := got.(time.Time); ok {
+			if wt, _ := v.want.(time.Time); !gt.Equal(wt) {
+				t.Errorf("convert %v to %+v got %s, expected %s", v.in, v.ft, gt, wt)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(got, v.want) {
+			t.Errorf("convert %v to %+v got %#v, expected %#v", v.in, v.ft, got, v.want)
+		}
+	}
+
+	if _, err := (FieldType{Type: TypeTime, Arg: "%Y"}).convert("soon", time.UTC); err == nil {
+		t.Errorf("an invalid time was converted")
+	}
+}
@@ -0,0 +1,142 @@
+package posfile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// UnwatchedPosition is written by in_tail for files it stopped following.
+const UnwatchedPosition uint64 = 0xffffffffffffffff
+
+type Entry struct {
+	Path   string
+	Offset uint64
+	Inode  uint64
+}
+
+func (e Entry) Unwatched() bool {
+	return e.Offset == UnwatchedPosition
+}
+
+func Parse(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		fields := strings.Split(text, "\t")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid pos entry at line %d: %q", line, text)
+		}
+
+		offset, err := strconv.ParseUint(fields[1], 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid offset at line %d, %v", line, err)
+		}
+
+		inode, err := strconv.ParseUint(fields[2], 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid inode at line %d, %v", line, err)
+		}
+
+		entries = append(entries, Entry{
+			Path:   fields[0],
+			Offset: offset,
+			Inode:  inode,
+		})
+	}
+	return entries, scanner.Err()
+}
+
+// Write renders the entries in the in_tail format, so tools reading fluentd pos files read them too.
+func Write(w io.Writer, entries []Entry) error {
+	for _, e := range entries {
+		if _, err := fmt.Fprintf(w, "%s\t%016x\t%016x\n", e.Path, e.Offset, e.Inode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ParseFile(file string) ([]Entry, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("parse pos file %s failed, %v", file, err)
+	}
+	return entries, nil
+}
+
+// Index holds the entries of every pos file in a directory, keyed by pos file path.
+type Index map[string][]Entry
+
+// Under returns the watched entries of the files below dir, keyed by tailed file path.
+func (idx Index) Under(dir string) map[string][]Entry {
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	result := make(map[string][]Entry)
+	for _, entries := range idx {
+		for _, e := range entries {
+			if e.Unwatched() || !strings.HasPrefix(e.Path, prefix) {
+				continue
+			}
+			result[e.Path] = append(result[e.Path], e)
+		}
+	}
+	return result
+}
+
+// PosFiles returns the pos files tracking any file below dir.
+func (idx Index) PosFiles(dir string) []string {
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	var result []string
+	for file, entries := range idx {
+		for _, e := range entries {
+			if strings.HasPrefix(e.Path, prefix) {
+				result = append(result, file)
+				break
+			}
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// ParseDir parses every *.pos file in dir.
+func ParseDir(dir string) (Index, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Index{}, nil
+		}
+		return nil, err
+	}
+
+	result := make(Index)
+	for _, v := range infos {
+		if v.IsDir() || path.Ext(v.Name()) != ".pos" {
+			continue
+		}
+		file := path.Join(dir, v.Name())
+		entries, err := ParseFile(file)
+		if err != nil {
+			return nil, err
+		}
+		result[file] = entries
+	}
+	return result, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/urfave/cli"
+
+	"github.com/rancher/log-aggregator/driver"
+)
+
+var previewFlags = []cli.Flag{
+	cli.StringFlag{Name: "format", Usage: "volume format, a predefined one or /regex/ with named groups"},
+	cli.StringFlag{Name: "time-key", Usage: "timeKey volume option"},
+	cli.StringFlag{Name: "time-format", Usage: "timeFormat volume option, a strptime format"},
+	cli.StringFlag{Name: "timezone", Usage: "timezone volume option"},
+	cli.StringFlag{Name: "keep-time-key", Usage: "keepTimeKey volume option, true or false"},
+	cli.StringFlag{Name: "types", Usage: "types volume option, <field>:<type>[:<arg>],..."},
+	cli.StringFlag{Name: "output, o", Value: "text", Usage: "output format, one of text, json"},
+}
+
+func runPreview(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("expected one sample file, - for stdin")
+	}
+	if c.String("format") == "" {
+		return fmt.Errorf("--format is required")
+	}
+
+	samples := io.Reader(os.Stdin)
+	if name := c.Args().First(); name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		samples = f
+	}
+
+	preview, err := driver.PreviewFormat(driver.Options{
+		Format:      c.String("format"),
+		TimeKey:     c.String("time-key"),
+		TimeFormat:  c.String("time-format"),
+		Timezone:    c.String("timezone"),
+		KeepTimeKey: c.String("keep-time-key"),
+		Types:       c.String("types"),
+	}, samples)
+	if err != nil {
+		return fmt.Errorf("mount would reject the format, %v", err)
+	}
+	if err := printPreview(preview, c.String("output")); err != nil {
+		return err
+	}
+
+	if failed := preview.Failed(); failed != 0 {
+		return fmt.Errorf("%d of %d lines failed to parse", failed, len(preview.Lines))
+	}
+	return nil
+}
+
+func printPreview(preview *driver.Preview, output string) error {
+	switch output {
+	case "json":
+		return printJSON(preview)
+	case "text", "":
+		if preview.ClusterConfig == "" {
+			fmt.Println("# the main fluentd config tails this format, mount publishes no config")
+		} else {
+			fmt.Print(preview.ClusterConfig)
+		}
+		fmt.Println()
+		if preview.ParseError != "" {
+			fmt.Printf("# lines not parsed, %s\n", preview.ParseError)
+			return nil
+		}
+
+		for _, v := range preview.Lines {
+			if v.Error != "" {
+				fmt.Printf("%d\tFAIL\t%s\t%q\n", v.Number, v.Error, v.Text)
+				continue
+			}
+			record, err := json.Marshal(v.Record)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%d\tOK\t%s\t%s\n", v.Number, v.Time.Format(time.RFC3339Nano), record)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported output %s", output)
+	}
+}
@@ -0,0 +1,102 @@
+// Package socket creates unix sockets inside log volumes for the applications of the pod.
+package socket
+
+import (
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	minRetryDelay = 5 * time.Millisecond
+	maxRetryDelay = time.Second
+)
+
+// Backoff paces a loop that retries a failing accept or read, a persistent error like EMFILE would
+// spin otherwise. The delay doubles up to a second like net/http does for temporary accept errors.
+type Backoff struct {
+	delay time.Duration
+}
+
+// Wait sleeps for the next delay.
+func (b *Backoff) Wait() {
+	if b.delay == 0 {
+		b.delay = minRetryDelay
+	} else if b.delay *= 2; b.delay > maxRetryDelay {
+		b.delay = maxRetryDelay
+	}
+	time.Sleep(b.delay)
+}
+
+// Reset starts over at the shortest delay once a retry succeeded.
+func (b *Backoff) Reset() {
+	b.delay = 0
+}
+
+// Listen creates a stream socket in dir, writable for every user of the volume. A leftover of an
+// earlier daemon is replaced.
+func Listen(dir, name string) (*net.UnixListener, error) {
+	var l *net.UnixListener
+	err := bindInDir(dir, name, func(p string) error {
+		var err error
+		if l, err = net.ListenUnix("unix", &net.UnixAddr{Name: p, Net: "unix"}); err != nil {
+			return err
+		}
+		// the bound path goes through a dir fd that is closed by then
+		l.SetUnlinkOnClose(false)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path.Join(dir, name), 0666); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// ListenPacket creates a datagram socket in dir like Listen.
+func ListenPacket(dir, name string) (*net.UnixConn, error) {
+	var c *net.UnixConn
+	err := bindInDir(dir, name, func(p string) error {
+		var err error
+		c, err = net.ListenUnixgram("unixgram", &net.UnixAddr{Name: p, Net: "unixgram"})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path.Join(dir, name), 0666); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Remove removes the socket file, closing the socket leaves it behind.
+func Remove(dir, name string) error {
+	if err := os.Remove(path.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// bindInDir binds through /proc/self/fd, a socket path is limited to 108 bytes and host dirs are
+// longer than that.
+func bindInDir(dir, name string, bind func(p string) error) error {
+	if err := Remove(dir, name); err != nil {
+		return err
+	}
+
+	fd, err := unix.Open(dir, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	return bind(path.Join("/proc/self/fd", strconv.Itoa(fd), name))
+}
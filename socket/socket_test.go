@@ -0,0 +1,30 @@
+package socket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	var b Backoff
+	var delays []time.Duration
+	for i := 0; i < 3; i++ {
+		b.Wait()
+		delays = append(delays, b.delay)
+	}
+	if delays[0] != minRetryDelay || delays[1] != 2*minRetryDelay || delays[2] != 4*minRetryDelay {
+		t.Errorf("delays %v do not double from %s", delays, minRetryDelay)
+	}
+
+	b.delay = maxRetryDelay/2 + time.Millisecond
+	b.Wait()
+	if b.delay != maxRetryDelay {
+		t.Errorf("delay %s is not capped at %s", b.delay, maxRetryDelay)
+	}
+
+	b.Reset()
+	b.Wait()
+	if b.delay != minRetryDelay {
+		t.Errorf("delay after reset is %s", b.delay)
+	}
+}
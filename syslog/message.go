@@ -0,0 +1,93 @@
+// Package syslog receives syslog messages on unix sockets, for applications that can only log to syslog.
+package syslog
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rancher/log-aggregator/parser"
+)
+
+const rfc3164TimeLayout = "Jan _2 15:04:05"
+
+var (
+	priorityRegexp = regexp.MustCompile(`^<([0-9]{1,3})>`)
+	// the tag of a local message, glibc leaves the host out
+	tagRegexp = regexp.MustCompile(`(?s)^(?P<ident>[^ :\[]+)(?:\[(?P<pid>[0-9]+)\])?: ?(?P<message>.*)$`)
+
+	rfc5424Parser, _ = parser.New(parser.FormatRFC5424, parser.Options{Location: time.UTC})
+)
+
+// Message is a received message, a part the sender left out is empty. A message that is neither
+// RFC5424 nor RFC3164 is kept whole in Message.
+type Message struct {
+	Time           time.Time `json:"time"`
+	Priority       *int      `json:"pri,omitempty"`
+	Facility       *int      `json:"facility,omitempty"`
+	Severity       *int      `json:"severity,omitempty"`
+	Host           string    `json:"host,omitempty"`
+	Ident          string    `json:"ident,omitempty"`
+	PID            string    `json:"pid,omitempty"`
+	MsgID          string    `json:"msgid,omitempty"`
+	StructuredData string    `json:"extradata,omitempty"`
+	Message        string    `json:"message"`
+}
+
+// Parse reads a message of either RFC, RFC3164 with or without the host. Times without a year or zone
+// are taken in loc, a message without a time gets now.
+func Parse(b []byte, now time.Time, loc *time.Location) Message {
+	text := strings.TrimRight(string(b), "\x00\r\n")
+	m := Message{Time: now}
+
+	if match := priorityRegexp.FindStringSubmatch(text); match != nil {
+		pri, _ := strconv.Atoi(match[1])
+		facility, severity := pri/8, pri%8
+		m.Priority, m.Facility, m.Severity = &pri, &facility, &severity
+		text = text[len(match[0]):]
+	}
+
+	if strings.HasPrefix(text, "1 ") {
+		if record, err := rfc5424Parser.Parse(text[2:]); err == nil {
+			m.Time = record.Time
+			m.Host = nilDash(record.Fields["host"])
+			m.Ident = nilDash(record.Fields["ident"])
+			m.PID = nilDash(record.Fields["pid"])
+			m.MsgID = nilDash(record.Fields["msgid"])
+			m.StructuredData = nilDash(record.Fields["extradata"])
+			m.Message, _ = record.Fields["message"].(string)
+			return m
+		}
+	}
+
+	m.Message = text
+	if len(text) < len(rfc3164TimeLayout)+1 || text[len(rfc3164TimeLayout)] != ' ' {
+		return m
+	}
+	t, err := time.ParseInLocation(rfc3164TimeLayout, text[:len(rfc3164TimeLayout)], loc)
+	if err != nil {
+		return m
+	}
+	m.Time = t.AddDate(now.In(loc).Year(), 0, 0)
+	rest := text[len(rfc3164TimeLayout)+1:]
+
+	// a first word that is no tag is the host
+	if first := strings.SplitN(rest, " ", 2); len(first) == 2 && !strings.HasSuffix(first[0], ":") && !strings.Contains(first[0], "[") {
+		m.Host, rest = first[0], first[1]
+	}
+	if match := tagRegexp.FindStringSubmatch(rest); match != nil {
+		m.Ident, m.PID, m.Message = match[1], match[2], match[3]
+		return m
+	}
+	m.Message = rest
+	return m
+}
+
+func nilDash(v interface{}) string {
+	s, _ := v.(string)
+	if s == "-" {
+		return ""
+	}
+	return s
+}
@@ -0,0 +1,184 @@
+package syslog
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rancher/log-aggregator/socket"
+)
+
+const (
+	// DatagramSocket is the socket for syslog(3) and other /dev/log clients
+	DatagramSocket = "dev-log"
+	// StreamSocket takes newline or octet count framed messages, RFC6587
+	StreamSocket = "dev-log-stream"
+
+	maxMessageSize = 64 * 1024
+)
+
+type Handler func(Message)
+
+// Server listens on both sockets in Dir until it is closed.
+type Server struct {
+	Dir      string
+	Handler  Handler
+	Location *time.Location
+
+	dgram  *net.UnixConn
+	stream *net.UnixListener
+	wg     sync.WaitGroup
+	lock   sync.Mutex
+	conns  map[net.Conn]bool
+	closed bool
+}
+
+// Listen creates the sockets in dir, writable for every user of the volume. Leftovers of an earlier
+// daemon are replaced.
+func Listen(dir string, handler Handler, loc *time.Location) (*Server, error) {
+	s := &Server{Dir: dir, Handler: handler, Location: loc, conns: map[net.Conn]bool{}}
+
+	var err error
+	if s.dgram, err = socket.ListenPacket(dir, DatagramSocket); err == nil {
+		s.stream, err = socket.Listen(dir, StreamSocket)
+	}
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("listen on syslog sockets in %s failed, %v", dir, err)
+	}
+
+	s.wg.Add(2)
+	go s.readDatagrams()
+	go s.accept()
+	return s, nil
+}
+
+func (s *Server) readDatagrams() {
+	defer s.wg.Done()
+	buf := make([]byte, maxMessageSize)
+	var backoff socket.Backoff
+	for {
+		n, err := s.dgram.Read(buf)
+		if err != nil {
+			if s.isClosed() {
+				return
+			}
+			backoff.Wait()
+			continue
+		}
+		backoff.Reset()
+		s.Handler(Parse(buf[:n], time.Now(), s.Location))
+	}
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	var backoff socket.Backoff
+	for {
+		conn, err := s.stream.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return
+			}
+			backoff.Wait()
+			continue
+		}
+		backoff.Reset()
+
+		s.lock.Lock()
+		if s.closed {
+			s.lock.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = true
+		s.wg.Add(1)
+		s.lock.Unlock()
+		go s.readStream(conn)
+	}
+}
+
+func (s *Server) readStream(conn net.Conn) {
+	defer func() {
+		s.lock.Lock()
+		delete(s.conns, conn)
+		s.lock.Unlock()
+		conn.Close()
+		s.wg.Done()
+	}()
+
+	r := bufio.NewReaderSize(conn, maxMessageSize)
+	for {
+		msg, err := readFrame(r)
+		if len(msg) != 0 {
+			s.Handler(Parse(msg, time.Now(), s.Location))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// readFrame reads an octet counted message when the frame starts with a digit, a message up to the
+// next newline otherwise.
+func readFrame(r *bufio.Reader) ([]byte, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if first[0] >= '1' && first[0] <= '9' {
+		count, err := r.ReadSlice(' ')
+		if err != nil {
+			return nil, err
+		}
+		n, err := strconv.Atoi(string(count[:len(count)-1]))
+		if err != nil || n > maxMessageSize {
+			return nil, fmt.Errorf("invalid frame length %q", count)
+		}
+		msg := make([]byte, n)
+		_, err = io.ReadFull(r, msg)
+		return msg, err
+	}
+
+	// a longer message is split at the buffer size
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		err = nil
+	}
+	return append([]byte(nil), line...), err
+}
+
+func (s *Server) isClosed() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.closed
+}
+
+// Close stops receiving, removes the sockets and waits for the handlers to return.
+func (s *Server) Close() error {
+	s.lock.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.lock.Unlock()
+
+	if s.dgram != nil {
+		s.dgram.Close()
+	}
+	if s.stream != nil {
+		s.stream.Close()
+	}
+	s.wg.Wait()
+
+	for _, name := range []string{DatagramSocket, StreamSocket} {
+		if err := socket.Remove(s.Dir, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+// Package tailer follows the log files of a volume in process, for nodes that run no fluentd. It keeps
+// its checkpoints in the fluentd pos file format, so lag, drain and rotation treat it like fluentd.
+package tailer
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/rancher/log-aggregator/posfile"
+)
+
+// DefaultMaxBatch bounds the bytes read from one file per poll, a longer line is split at this size.
+const DefaultMaxBatch = 1 << 20
+
+type Line struct {
+	Path  string
+	Inode uint64
+	// Offset is where the line ends, the checkpoint of the file once the line is handled
+	Offset int64
+	Text   []byte
+	Time   time.Time
+}
+
+// Handler gets the lines of one file, the checkpoint only moves past them when it returns nil.
+type Handler func([]Line) error
+
+// Tailer follows the regular files directly in Dir. Files are tracked by inode: a file renamed within Dir
+// keeps its checkpoint, a file renamed out of Dir or removed is read to the end through the open file,
+// and a file shorter than its checkpoint was truncated by copytruncate and is read from the start.
+type Tailer struct {
+	Dir      string
+	PosFile  string
+	Handler  Handler
+	MaxBatch int
+
+	files map[uint64]*tailedFile
+	dirty bool
+}
+
+type tailedFile struct {
+	path   string
+	inode  uint64
+	offset int64
+	f      *os.File
+	// gone is set once the file left Dir, it is dropped after the last line is handled
+	gone bool
+}
+
+func New(dir, posFile string, handler Handler) (*Tailer, error) {
+	t := &Tailer{
+		Dir:      dir,
+		PosFile:  posFile,
+		Handler:  handler,
+		MaxBatch: DefaultMaxBatch,
+		files:    map[uint64]*tailedFile{},
+	}
+
+	entries, err := posfile.ParseFile(posFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.Unwatched() {
+			continue
+		}
+		t.files[e.Inode] = &tailedFile{path: e.Path, inode: e.Inode, offset: int64(e.Offset)}
+	}
+	return t, nil
+}
+
+// Poll reads what was written since the last poll and saves the checkpoints, the first error is
+// returned after every file had its turn. Once Dir is removed Poll does nothing, the volume is gone.
+func (t *Tailer) Poll() error {
+	if _, err := os.Stat(t.Dir); os.IsNotExist(err) {
+		return nil
+	}
+	if err := t.scan(); err != nil {
+		return err
+	}
+
+	var firstErr error
+	for _, tf := range t.sortedFiles() {
+		if tf.f == nil {
+			continue
+		}
+		if err := t.read(tf); err != nil && firstErr == nil {
+			firstErr = errors.Wrapf(err, "tail %s failed", tf.path)
+		}
+	}
+
+	if t.dirty {
+		if err := t.save(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Close releases the open files, the checkpoints were saved by the last Poll.
+func (t *Tailer) Close() {
+	for _, tf := range t.files {
+		if tf.f != nil {
+			tf.f.Close()
+			tf.f = nil
+		}
+	}
+}
+
+func (t *Tailer) scan() error {
+	infos, err := ioutil.ReadDir(t.Dir)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[uint64]bool, len(infos))
+	for _, info := range infos {
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok || !info.Mode().IsRegular() || strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		file := path.Join(t.Dir, info.Name())
+		seen[stat.Ino] = true
+
+		tf, ok := t.files[stat.Ino]
+		if !ok {
+			tf = &tailedFile{path: file, inode: stat.Ino}
+			t.files[stat.Ino] = tf
+			t.dirty = true
+		}
+		if tf.path != file {
+			tf.path = file
+			t.dirty = true
+		}
+		tf.gone = false
+
+		if tf.f == nil {
+			f, err := openInode(file, stat.Ino)
+			if err != nil {
+				continue
+			}
+			tf.f = f
+		}
+	}
+
+	for inode, tf := range t.files {
+		if seen[inode] {
+			continue
+		}
+		if tf.f == nil {
+			delete(t.files, inode)
+			t.dirty = true
+			continue
+		}
+		tf.gone = true
+	}
+	return nil
+}
+
+// openInode guards against the file being replaced between the listing and the open.
+func openInode(file string, inode uint64) (*os.File, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); !ok || stat.Ino != inode {
+		f.Close()
+		return nil, errors.Errorf("%s was replaced", file)
+	}
+	return f, nil
+}
+
+func (t *Tailer) read(tf *tailedFile) error {
+	info, err := tf.f.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	if size < tf.offset {
+		tf.offset = 0
+		t.dirty = true
+	}
+
+	for tf.offset < size {
+		n := size - tf.offset
+		if n > int64(t.MaxBatch) {
+			n = int64(t.MaxBatch)
+		}
+		buf := make([]byte, n)
+		read, err := tf.f.ReadAt(buf, tf.offset)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		// a line without its newline is complete when it fills the whole batch or the writer is gone
+		full := read == t.MaxBatch && bytes.IndexByte(buf[:read], '\n') < 0
+		lines := splitLines(tf, buf[:read], full || tf.gone)
+		if len(lines) == 0 {
+			break
+		}
+		if err := t.Handler(lines); err != nil {
+			return err
+		}
+		tf.offset = lines[len(lines)-1].Offset
+		t.dirty = true
+	}
+
+	if tf.gone && tf.offset >= size {
+		tf.f.Close()
+		delete(t.files, tf.inode)
+		t.dirty = true
+	}
+	return nil
+}
+
+func splitLines(tf *tailedFile, buf []byte, flush bool) []Line {
+	var (
+		lines  []Line
+		offset = tf.offset
+		now    = time.Now()
+	)
+	for len(buf) != 0 {
+		i := bytes.IndexByte(buf, '\n')
+		end := i + 1
+		if i < 0 {
+			if !flush {
+				break
+			}
+			i, end = len(buf), len(buf)
+		}
+		offset += int64(end)
+		lines = append(lines, Line{
+			Path:   tf.path,
+			Inode:  tf.inode,
+			Offset: offset,
+			Text:   bytes.TrimSuffix(buf[:i], []byte("\r")),
+			Time:   now,
+		})
+		buf = buf[end:]
+	}
+	return lines
+}
+
+func (t *Tailer) sortedFiles() []*tailedFile {
+	result := make([]*tailedFile, 0, len(t.files))
+	for _, tf := range t.files {
+		result = append(result, tf)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].path < result[j].path
+	})
+	return result
+}
+
+func (t *Tailer) save() error {
+	var entries []posfile.Entry
+	for _, tf := range t.sortedFiles() {
+		entries = append(entries, posfile.Entry{Path: tf.path, Offset: uint64(tf.offset), Inode: tf.inode})
+	}
+
+	var buf bytes.Buffer
+	if err := posfile.Write(&buf, entries); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(t.PosFile), os.ModePerm); err != nil {
+		return errors.Wrapf(err, "create pos dir of %s failed", t.PosFile)
+	}
+	tmp := path.Join(path.Dir(t.PosFile), "."+path.Base(t.PosFile)+".tmp")
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return errors.Wrapf(err, "write pos file %s failed", tmp)
+	}
+	if err := os.Rename(tmp, t.PosFile); err != nil {
+		return errors.Wrapf(err, "rename pos file %s failed", t.PosFile)
+	}
+	t.dirty = false
+	return nil
+}
@@ -0,0 +1,260 @@
+package tailer
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/rancher/log-aggregator/posfile"
+)
+
+type collector struct {
+	lines []string
+	err   error
+}
+
+func (c *collector) handle(lines []Line) error {
+	if c.err != nil {
+		return c.err
+	}
+	for _, v := range lines {
+		c.lines = append(c.lines, path.Base(v.Path)+":"+string(v.Text))
+	}
+	return nil
+}
+
+func (c *collector) take() []string {
+	lines := c.lines
+	c.lines = nil
+	return lines
+}
+
+type testDir struct {
+	t       *testing.T
+	root    string
+	dir     string
+	posFile string
+}
+
+func newTestDir(t *testing.T) *testDir {
+	root, err := ioutil.TempDir("", "tailer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &testDir{t: t, root: root, dir: path.Join(root, "volume"), posFile: path.Join(root, "pos", "native.pos")}
+	if err := os.Mkdir(d.dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func (d *testDir) cleanup() {
+	os.RemoveAll(d.root)
+}
+
+func (d *testDir) append(name, data string) {
+	f, err := os.OpenFile(path.Join(d.dir, name), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		d.t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		d.t.Fatal(err)
+	}
+}
+
+func (d *testDir) tailer(c *collector) *Tailer {
+	t, err := New(d.dir, d.posFile, c.handle)
+	if err != nil {
+		d.t.Fatal(err)
+	}
+	return t
+}
+
+func poll(t *testing.T, tl *Tailer) {
+	if err := tl.Poll(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectLines(t *testing.T, c *collector, want ...string) {
+	got := c.take()
+	if len(got) == 0 && len(want) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got lines %q, expected %q", got, want)
+	}
+}
+
+func TestTailPartialLines(t *testing.T) {
+	d := newTestDir(t)
+	defer d.cleanup()
+	c := &collector{}
+	tl := d.tailer(c)
+	defer tl.Close()
+
+	d.append("app.log", "one\ntw")
+	poll(t, tl)
+	expectLines(t, c, "app.log:one")
+
+	d.append("app.log", "o\r\nthree\n")
+	poll(t, tl)
+	expectLines(t, c, "app.log:two", "app.log:three")
+
+	poll(t, tl)
+	expectLines(t, c)
+}
+
+func TestTailSkipsHiddenAndNonRegularFiles(t *testing.T) {
+	d := newTestDir(t)
+	defer d.cleanup()
+	d.append(".hidden.log", "hidden\n")
+	if err := os.Mkdir(path.Join(d.dir, ".rotated"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	d.append("app.log", "line\n")
+
+	c := &collector{}
+	tl := d.tailer(c)
+	defer tl.Close()
+	poll(t, tl)
+	expectLines(t, c, "app.log:line")
+}
+
+func TestTailResumesFromPosFile(t *testing.T) {
+	d := newTestDir(t)
+	defer d.cleanup()
+	c := &collector{}
+
+	d.append("app.log", "one\ntwo\n")
+	tl := d.tailer(c)
+	poll(t, tl)
+	tl.Close()
+	expectLines(t, c, "app.log:one", "app.log:two")
+
+	entries, err := posfile.ParseFile(d.posFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Path != path.Join(d.dir, "app.log") || entries[0].Offset != 8 {
+		t.Fatalf("unexpected pos file entries %+v", entries)
+	}
+
+	d.append("app.log", "three\n")
+	tl = d.tailer(c)
+	defer tl.Close()
+	poll(t, tl)
+	expectLines(t, c, "app.log:three")
+}
+
+func TestTailRenameRotation(t *testing.T) {
+	d := newTestDir(t)
+	defer d.cleanup()
+	c := &collector{}
+	tl := d.tailer(c)
+	defer tl.Close()
+
+	d.append("app.log", "one\n")
+	poll(t, tl)
+	expectLines(t, c, "app.log:one")
+
+	// the writer still appends to the renamed file before it reopens app.log
+	if err := os.Rename(path.Join(d.dir, "app.log"), path.Join(d.dir, "app.log.1")); err != nil {
+		t.Fatal(err)
+	}
+	d.append("app.log.1", "two\n")
+	d.append("app.log", "three\n")
+	poll(t, tl)
+	expectLines(t, c, "app.log:three", "app.log.1:two")
+}
+
+func TestTailRemovedFileIsReadToTheEnd(t *testing.T) {
+	d := newTestDir(t)
+	defer d.cleanup()
+	c := &collector{}
+	tl := d.tailer(c)
+	defer tl.Close()
+
+	d.append("app.log", "one\n")
+	poll(t, tl)
+	expectLines(t, c, "app.log:one")
+
+	d.append("app.log", "two\nunterminated")
+	if err := os.Rename(path.Join(d.dir, "app.log"), path.Join(d.root, "moved.log")); err != nil {
+		t.Fatal(err)
+	}
+	poll(t, tl)
+	expectLines(t, c, "app.log:two", "app.log:unterminated")
+
+	if len(tl.files) != 0 {
+		t.Errorf("the file is still tracked after it was read to the end, %+v", tl.files)
+	}
+}
+
+func TestTailCopyTruncate(t *testing.T) {
+	d := newTestDir(t)
+	defer d.cleanup()
+	c := &collector{}
+	tl := d.tailer(c)
+	defer tl.Close()
+
+	d.append("app.log", "one\ntwo\n")
+	poll(t, tl)
+	expectLines(t, c, "app.log:one", "app.log:two")
+
+	if err := os.Truncate(path.Join(d.dir, "app.log"), 0); err != nil {
+		t.Fatal(err)
+	}
+	d.append("app.log", "x\n")
+	poll(t, tl)
+	expectLines(t, c, "app.log:x")
+}
+
+func TestTailHandlerErrorKeepsCheckpoint(t *testing.T) {
+	d := newTestDir(t)
+	defer d.cleanup()
+	c := &collector{err: errors.New("output down")}
+	tl := d.tailer(c)
+	defer tl.Close()
+
+	d.append("app.log", "one\n")
+	if err := tl.Poll(); err == nil {
+		t.Fatal("poll returned no error while the handler failed")
+	}
+	expectLines(t, c)
+
+	c.err = nil
+	poll(t, tl)
+	expectLines(t, c, "app.log:one")
+}
+
+func TestTailSplitsLongLines(t *testing.T) {
+	d := newTestDir(t)
+	defer d.cleanup()
+	c := &collector{}
+	tl := d.tailer(c)
+	tl.MaxBatch = 4
+	defer tl.Close()
+
+	d.append("app.log", "abcdefghij\nk\n")
+	poll(t, tl)
+	expectLines(t, c, "app.log:abcd", "app.log:efgh", "app.log:ij", "app.log:k")
+}
+
+func TestTailGoneDir(t *testing.T) {
+	d := newTestDir(t)
+	defer d.cleanup()
+	c := &collector{}
+	tl := d.tailer(c)
+	defer tl.Close()
+
+	if err := os.RemoveAll(d.dir); err != nil {
+		t.Fatal(err)
+	}
+	poll(t, tl)
+	expectLines(t, c)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/rancher/log-aggregator/driver"
+)
+
+func printIssues(issues []driver.Issue, output string) error {
+	switch output {
+	case "json":
+		return printJSON(issues)
+	case "table", "":
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "KIND\tSUBJECT\tMESSAGE\tFIX\tREPAIRED")
+		for _, v := range issues {
+			repaired := "-"
+			switch {
+			case v.Repaired:
+				repaired = "yes"
+			case v.RepairError != "":
+				repaired = "failed: " + v.RepairError
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", v.Kind, v.Subject, v.Message, v.Fix, repaired)
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unsupported output %s", output)
+	}
+}
@@ -0,0 +1,109 @@
+package volume
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/rancher/log-aggregator/posfile"
+)
+
+type FileStatus struct {
+	Path        string    `json:"path"`
+	Size        int64     `json:"size"`
+	Inode       uint64    `json:"inode"`
+	Offset      int64     `json:"offset"`
+	Tracked     bool      `json:"tracked"`
+	UnreadBytes int64     `json:"unreadBytes"`
+	ModTime     time.Time `json:"modTime"`
+}
+
+type Lag struct {
+	Files       []FileStatus `json:"files,omitempty"`
+	UnreadBytes int64        `json:"unreadBytes"`
+	UnreadFiles int          `json:"unreadFiles"`
+	// UnreadIdleSince is the modification time of the least recently written file with unread data.
+	// Files are appended to while their tailer lags, so this is not how old the unread data is, only
+	// how long such a file saw no writes.
+	UnreadIdleSince time.Time `json:"unreadIdleSince,omitempty"`
+}
+
+func (l Lag) Drained() bool {
+	return l.UnreadFiles == 0
+}
+
+func (l Lag) UnreadIdle(now time.Time) time.Duration {
+	if l.UnreadIdleSince.IsZero() {
+		return 0
+	}
+	return now.Sub(l.UnreadIdleSince)
+}
+
+// ComputeLag compares the size of every file under dir with the offsets recorded for it in the pos files,
+// a file no tailer picked up yet counts as entirely unread. Hidden directories, like the one
+// holding rotated files, are not tailed and skipped.
+func ComputeLag(dir string, idx posfile.Index) (Lag, error) {
+	var lag Lag
+	entries := idx.Under(dir)
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() && p != dir && isHidden(info.Name()) {
+			return filepath.SkipDir
+		}
+		if !info.Mode().IsRegular() || p == path.Join(dir, MetadataFile) {
+			return nil
+		}
+
+		status := fileStatus(p, info, entries[p])
+		lag.Files = append(lag.Files, status)
+		if status.UnreadBytes == 0 {
+			return nil
+		}
+
+		lag.UnreadBytes += status.UnreadBytes
+		lag.UnreadFiles++
+		if lag.UnreadIdleSince.IsZero() || status.ModTime.Before(lag.UnreadIdleSince) {
+			lag.UnreadIdleSince = status.ModTime
+		}
+		return nil
+	})
+	return lag, err
+}
+
+func fileStatus(p string, info os.FileInfo, entries []posfile.Entry) FileStatus {
+	status := FileStatus{
+		Path:    p,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		status.Inode = st.Ino
+	}
+
+	// with several tailers on one file the slowest one decides
+	status.Offset = -1
+	for _, e := range entries {
+		if e.Inode != status.Inode {
+			continue
+		}
+		status.Tracked = true
+		if offset := int64(e.Offset); status.Offset < 0 || offset < status.Offset {
+			status.Offset = offset
+		}
+	}
+
+	if !status.Tracked {
+		status.Offset = 0
+	}
+	if status.Offset < status.Size {
+		status.UnreadBytes = status.Size - status.Offset
+	}
+	return status
+}
@@ -0,0 +1,46 @@
+package volume
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+type Usage struct {
+	Bytes int64 `json:"bytes"`
+	Files int   `json:"files"`
+}
+
+// DiskUsage sums the allocated size of every regular file under dir.
+func DiskUsage(dir string) (Usage, error) {
+	var usage Usage
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		usage.Files++
+		usage.Bytes += AllocatedSize(info)
+		return nil
+	})
+	return usage, err
+}
+
+// AllocatedSize is the disk space of the file, which DiskUsage sums.
+func AllocatedSize(info os.FileInfo) int64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return st.Blocks * 512
+	}
+	return info.Size()
+}
+
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
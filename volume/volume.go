@@ -0,0 +1,120 @@
+package volume
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// MetadataFile is written next to the format dir by mount and holds the volume options.
+const MetadataFile = "metadata.json"
+
+type Volume struct {
+	PodUID     string            `json:"podUID"`
+	VolumeName string            `json:"volumeName"`
+	Format     string            `json:"format,omitempty"`
+	Dir        string            `json:"dir"`
+	HostDir    string            `json:"hostDir,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+}
+
+func IdentifyName(podUID, volumeName string) string {
+	return fmt.Sprintf("%s_%s", podUID, volumeName)
+}
+
+// ParseIdentifyName splits <podUID>_<volumeName>, pod UIDs never contain "_".
+func ParseIdentifyName(name string) (string, string, error) {
+	i := strings.Index(name, "_")
+	if i <= 0 || i == len(name)-1 {
+		return "", "", fmt.Errorf("invalid volume dir name %s", name)
+	}
+	return name[:i], name[i+1:], nil
+}
+
+func (v Volume) IdentifyName() string {
+	return IdentifyName(v.PodUID, v.VolumeName)
+}
+
+// List returns the volumes laid out as <baseDir>/<podUID>_<volumeName>/<format>/<generateDir>.
+func List(baseDir string) ([]Volume, error) {
+	infos, err := ioutil.ReadDir(baseDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var volumes []Volume
+	for _, v := range infos {
+		if !v.IsDir() || strings.HasPrefix(v.Name(), ".") {
+			continue
+		}
+
+		vol, err := Get(baseDir, v.Name())
+		if err != nil {
+			continue
+		}
+		volumes = append(volumes, vol)
+	}
+
+	sort.Slice(volumes, func(i, j int) bool {
+		return volumes[i].IdentifyName() < volumes[j].IdentifyName()
+	})
+	return volumes, nil
+}
+
+func Get(baseDir, identifyName string) (Volume, error) {
+	podUID, volumeName, err := ParseIdentifyName(identifyName)
+	if err != nil {
+		return Volume{}, err
+	}
+
+	vol := Volume{
+		PodUID:     podUID,
+		VolumeName: volumeName,
+		Dir:        path.Join(baseDir, identifyName),
+	}
+
+	if b, err := ioutil.ReadFile(path.Join(vol.Dir, MetadataFile)); err == nil {
+		if err = json.Unmarshal(b, &vol.Metadata); err != nil {
+			return Volume{}, fmt.Errorf("parse metadata of %s failed, %v", identifyName, err)
+		}
+	}
+
+	format, err := FirstSubDir(vol.Dir)
+	if err != nil {
+		return Volume{}, err
+	}
+	if format == "" {
+		return vol, nil
+	}
+	vol.Format = format
+
+	generateDir, err := FirstSubDir(path.Join(vol.Dir, format))
+	if err != nil {
+		return Volume{}, err
+	}
+	if generateDir != "" {
+		vol.HostDir = path.Join(vol.Dir, format, generateDir)
+	}
+	return vol, nil
+}
+
+// FirstSubDir returns the first non hidden sub dir, the layout has exactly one per level.
+func FirstSubDir(dir string) (string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	for _, v := range infos {
+		if v.IsDir() && !strings.HasPrefix(v.Name(), ".") {
+			return v.Name(), nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/rancher/log-aggregator/driver"
+)
+
+func printVolumes(volumes []driver.VolumeInfo, output string) error {
+	switch output {
+	case "json":
+		for i := range volumes {
+			volumes[i].Lag.Files = nil
+		}
+		return printJSON(volumes)
+	case "table", "":
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "POD UID\tVOLUME\tNAMESPACE\tWORKLOAD\tCONTAINER\tFORMAT\tMOUNTED\tDRAINING\tFILES\tDISK BYTES\tUNREAD BYTES")
+		for _, v := range volumes {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%t\t%t\t%d\t%d\t%d\n", v.PodUID, v.VolumeName, orDash(v.Metadata["namespace"]),
+				orDash(v.Metadata["workloadName"]), orDash(v.Metadata["containerName"]), v.Format, v.Mounted, v.Draining,
+				v.Usage.Files, v.Usage.Bytes, v.Lag.UnreadBytes)
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unsupported output %s", output)
+	}
+}
+
+// parseVolumeRef accepts <podUID>/<volumeName> and the <podUID>_<volumeName> dir name.
+func parseVolumeRef(ref string) (string, string, error) {
+	parts := strings.SplitN(ref, "/", 2)
+	if len(parts) == 2 && parts[0] != "" && parts[1] != "" {
+		return parts[0], parts[1], nil
+	}
+	parts = strings.SplitN(ref, "_", 2)
+	if len(parts) == 2 && parts[0] != "" && parts[1] != "" {
+		return parts[0], parts[1], nil
+	}
+	return "", "", fmt.Errorf("invalid volume %q, expected <podUID>/<volumeName>", ref)
+}
+
+// printJSON keeps <, > and & readable, they are common in configs and formats.
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}